@@ -75,6 +75,18 @@ var levels = []compressionLevel{
 	{32, 258, 258, 4096, skipNever, 9},
 }
 
+// lazyLevels are used for levels 1-6 when options require lazy matching.
+// The values are taken from zlib.
+var lazyLevels = []compressionLevel{
+	{},
+	{4, 4, 8, 4, skipNever, 1},
+	{4, 5, 16, 8, skipNever, 2},
+	{4, 6, 32, 32, skipNever, 3},
+	{4, 4, 16, 16, skipNever, 4},
+	{8, 16, 32, 32, skipNever, 5},
+	{8, 16, 128, 128, skipNever, 6},
+}
+
 // advancedState contains state for the advanced levels, with bigger hash tables, etc.
 type advancedState struct {
 	// deflate state
@@ -118,9 +130,14 @@ type compressor struct {
 	tokens tokens
 	fast   fastEnc
 	state  *advancedState
+	opt    *optimalState
 
 	sync          bool // requesting flush
 	byteAvailable bool // if true, still need to process window[index-1].
+
+	maxDist     int // Maximum match distance.
+	blockTokens int // Maximum tokens per block for lazy matching.
+	minMatch    int // Minimum match length for lazy matching.
 }
 
 func (d *compressor) fillDeflate(b []byte) int {
@@ -209,6 +226,10 @@ func (d *compressor) fillWindow(b []byte) {
 	if d.level <= 0 {
 		return
 	}
+	if d.opt != nil {
+		d.opt.fillWindow(d, b)
+		return
+	}
 	if d.fast != nil {
 		// encode the last data, but discard the result
 		if len(b) > maxMatchOffset {
@@ -286,7 +307,7 @@ func (d *compressor) findMatch(pos int, prevHead int, prevLength int, lookahead
 
 	wEnd := win[pos+length]
 	wPos := win[pos:]
-	minIndex := pos - windowSize
+	minIndex := pos - d.maxDist
 
 	for i := prevHead; tries > 0; tries-- {
 		if wEnd == win[i+length] {
@@ -316,6 +337,10 @@ func (d *compressor) findMatch(pos int, prevHead int, prevLength int, lookahead
 }
 
 func (d *compressor) writeStoredBlock(buf []byte) error {
+	if d.w.blockType == BlockFixed || d.w.blockType == BlockDynamic {
+		d.w.writeBlockHuff(false, buf, d.sync)
+		return d.w.err
+	}
 	if d.w.writeStoredHeader(len(buf), false); d.w.err != nil {
 		return d.w.err
 	}
@@ -420,13 +445,13 @@ func (d *compressor) deflateLazy() {
 		prevOffset := s.offset
 		s.length = minMatchLength - 1
 		s.offset = 0
-		minIndex := s.index - windowSize
+		minIndex := s.index - d.maxDist
 		if minIndex < 0 {
 			minIndex = 0
 		}
 
 		if s.chainHead-s.hashOffset >= minIndex && lookahead > prevLength && prevLength < d.lazy {
-			if newLength, newOffset, ok := d.findMatch(s.index, s.chainHead-s.hashOffset, minMatchLength-1, lookahead); ok {
+			if newLength, newOffset, ok := d.findMatch(s.index, s.chainHead-s.hashOffset, minMatchLength-1, lookahead); ok && newLength >= d.minMatch {
 				s.length = newLength
 				s.offset = newOffset
 			}
@@ -473,7 +498,7 @@ func (d *compressor) deflateLazy() {
 			s.index = newIndex
 			d.byteAvailable = false
 			s.length = minMatchLength - 1
-			if d.tokens.n == maxFlateBlockTokens {
+			if int(d.tokens.n) == d.blockTokens {
 				// The block includes the current character
 				if d.err = d.writeBlock(&d.tokens, s.index, false); d.err != nil {
 					return
@@ -489,7 +514,7 @@ func (d *compressor) deflateLazy() {
 			if d.byteAvailable {
 				s.ii++
 				d.tokens.AddLiteral(d.window[s.index-1])
-				if d.tokens.n == maxFlateBlockTokens {
+				if int(d.tokens.n) == d.blockTokens {
 					if d.err = d.writeBlock(&d.tokens, s.index, false); d.err != nil {
 						return
 					}
@@ -507,7 +532,7 @@ func (d *compressor) deflateLazy() {
 						}
 
 						d.tokens.AddLiteral(d.window[s.index-1])
-						if d.tokens.n == maxFlateBlockTokens {
+						if int(d.tokens.n) == d.blockTokens {
 							if d.err = d.writeBlock(&d.tokens, s.index, false); d.err != nil {
 								return
 							}
@@ -519,7 +544,7 @@ func (d *compressor) deflateLazy() {
 					d.tokens.AddLiteral(d.window[s.index-1])
 					d.byteAvailable = false
 					// s.length = minMatchLength - 1 // not needed, since s.ii is reset above, so it should never be > minMatchLength
-					if d.tokens.n == maxFlateBlockTokens {
+					if int(d.tokens.n) == d.blockTokens {
 						if d.err = d.writeBlock(&d.tokens, s.index, false); d.err != nil {
 							return
 						}
@@ -535,7 +560,7 @@ func (d *compressor) deflateLazy() {
 }
 
 func (d *compressor) store() {
-	if d.windowEnd > 0 && (d.windowEnd == maxStoreBlockSize || d.sync) {
+	if d.windowEnd > 0 && (d.windowEnd == len(d.window) || d.sync) {
 		d.err = d.writeStoredBlock(d.window[:d.windowEnd])
 		d.windowEnd = 0
 	}
@@ -637,7 +662,31 @@ func (d *compressor) syncFlush() error {
 }
 
 func (d *compressor) init(w io.Writer, level int) (err error) {
+	return d.initOpts(w, writerOptions{level: level, windowBits: logWindowSize})
+}
+
+func (d *compressor) initOpts(w io.Writer, o writerOptions) (err error) {
 	d.w = newHuffmanBitWriter(w)
+	d.w.blockType = o.blockType
+	d.w.tables = o.tables
+	d.maxDist = 1 << uint(o.windowBits)
+	d.blockTokens = maxFlateBlockTokens
+	d.minMatch = minMatchLength
+
+	level := o.level
+	switch {
+	case o.blockType == BlockStored:
+		level = NoCompression
+	case o.strategy == StrategyHuffmanOnly:
+		level = HuffmanOnly
+	case level == DefaultCompression:
+		level = 5
+	}
+	if o.strategy == StrategyFiltered {
+		d.minMatch = filteredMinMatch
+	}
+	// The specialized encoders only support the full window without filtering.
+	lazy := o.windowBits < logWindowSize || o.strategy == StrategyFiltered
 
 	switch {
 	case level == NoCompression:
@@ -649,19 +698,25 @@ func (d *compressor) init(w io.Writer, level int) (err error) {
 		d.window = make([]byte, maxStoreBlockSize)
 		d.fill = (*compressor).fillBlock
 		d.step = (*compressor).storeHuff
-	case level == DefaultCompression:
-		level = 5
-		fallthrough
-	case level >= 1 && level <= 6:
+	case level >= 1 && level <= 9 && o.strategy == StrategyRLE:
+		d.w.logNewTablePenalty = 6
+		d.fast = &rleEnc{}
+		d.window = make([]byte, maxStoreBlockSize)
+		d.fill = (*compressor).fillBlock
+		d.step = (*compressor).storeFast
+	case level >= 1 && level <= 6 && !lazy:
 		d.w.logNewTablePenalty = 6
 		d.fast = newFastEnc(level)
 		d.window = make([]byte, maxStoreBlockSize)
 		d.fill = (*compressor).fillBlock
 		d.step = (*compressor).storeFast
-	case 7 <= level && level <= 9:
+	case level >= 1 && level <= 9:
 		d.w.logNewTablePenalty = 10
 		d.state = &advancedState{}
 		d.compressionLevel = levels[level]
+		if level <= 6 {
+			d.compressionLevel = lazyLevels[level]
+		}
 		d.initDeflate()
 		d.fill = (*compressor).fillDeflate
 		d.step = (*compressor).deflateLazy
@@ -669,6 +724,14 @@ func (d *compressor) init(w io.Writer, level int) (err error) {
 		return fmt.Errorf("flate: invalid compression level %d: want value in range [-2, 9]", level)
 	}
 	d.level = level
+	if o.blockTokens > 0 {
+		if d.state != nil {
+			d.blockTokens = o.blockTokens
+		} else if o.blockTokens < len(d.window) {
+			// Each byte produces at most one token.
+			d.window = d.window[:o.blockTokens]
+		}
+	}
 	return nil
 }
 
@@ -684,6 +747,12 @@ func (d *compressor) reset(w io.Writer) {
 		d.tokens.Reset()
 		return
 	}
+	if d.opt != nil {
+		d.opt.reset()
+		d.windowEnd = 0
+		d.tokens.Reset()
+		return
+	}
 	switch d.compressionLevel.chain {
 	case 0:
 		// level was NoCompression or ConstantCompresssion.
@@ -31,6 +31,8 @@ type dictDecoder struct {
 	wrPos int  // Current output position in buffer
 	rdPos int  // Have emitted hist[:rdPos] already
 	full  bool // Has a full window length been written yet?
+
+	total int64 // Number of bytes returned by readFlush.
 }
 
 // init initializes dictDecoder to have a sliding window dictionary of the given
@@ -175,6 +177,7 @@ loop:
 // before calling any other dictDecoder methods.
 func (dd *dictDecoder) readFlush() []byte {
 	toRead := dd.hist[dd.rdPos:dd.wrPos]
+	dd.total += int64(len(toRead))
 	dd.rdPos = dd.wrPos
 	if dd.wrPos == len(dd.hist) {
 		dd.wrPos, dd.rdPos = 0, 0
@@ -182,3 +185,17 @@ func (dd *dictDecoder) readFlush() []byte {
 	}
 	return toRead
 }
+
+// written returns the number of bytes written to the dictionary,
+// excluding any preset dictionary.
+func (dd *dictDecoder) written() int64 {
+	return dd.total + int64(dd.availRead())
+}
+
+// appendHist appends the history to dst, oldest byte first.
+func (dd *dictDecoder) appendHist(dst []byte) []byte {
+	if dd.full {
+		dst = append(dst, dd.hist[dd.wrPos:]...)
+	}
+	return append(dst, dd.hist[:dd.wrPos]...)
+}
@@ -15,9 +15,9 @@ func main() {
 		panic(err)
 	}
 	defer f.Close()
-	types := []string{"*bytes.Buffer", "*bytes.Reader", "*bufio.Reader", "*strings.Reader"}
-	names := []string{"BytesBuffer", "BytesReader", "BufioReader", "StringsReader"}
-	imports := []string{"bytes", "bufio", "io", "strings", "math/bits"}
+	types := []string{"*bytes.Buffer", "*bytes.Reader", "*bufio.Reader", "*strings.Reader", "*peekReader"}
+	names := []string{"BytesBuffer", "BytesReader", "BufioReader", "StringsReader", "PeekReader"}
+	imports := []string{"bytes", "bufio", "fmt", "strings", "math/bits"}
 	f.WriteString(`// Code generated by go generate gen_inflate.go. DO NOT EDIT.
 
 package flate
@@ -148,8 +148,14 @@ readLiteral:
 			length = v*32 - (281*32 - 131)
 			n = 5
 		case v < maxNumLit:
-			length = 258
-			n = 0
+			if f.deflate64 {
+				// Deflate64 stores 16 extra bits for the last length code.
+				length = 3
+				n = 16
+			} else {
+				length = 258
+				n = 0
+			}
 		default:
 			if debugDecode {
 				fmt.Println(v, ">= maxNumLit")
@@ -199,7 +205,7 @@ readLiteral:
 		switch {
 		case dist < 4:
 			dist++
-		case dist < maxNumDist:
+		case dist < maxNumDist, f.deflate64 && dist < maxNumDist64:
 			nb := uint(dist-2) >> 1
 			// have 1 bit in bottom of dist, need nb more.
 			extra := (dist & 1) << nb
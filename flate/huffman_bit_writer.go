@@ -5,6 +5,7 @@
 package flate
 
 import (
+	"bytes"
 	"io"
 )
 
@@ -95,6 +96,9 @@ type huffmanBitWriter struct {
 	// Set between 0 (reused block can be up to 2x the size)
 	logNewTablePenalty uint
 	lastHuffMan        bool
+	blockType          BlockType // Forced block type, unless BlockAuto.
+	tables             *Tables   // Tables used for all blocks, if set.
+	tablesHeader       tablesHeader
 	bytes              [256]byte
 	literalFreq        [lengthCodesStart + 32]uint16
 	offsetFreq         [32]uint16
@@ -123,6 +127,14 @@ type huffmanBitWriter struct {
 // A Huffman table is not optimal, which is why we add a penalty, and generating a new table
 // is slower both for compression and decompression.
 
+// tablesHeader is the encoded dynamic block header of a Tables.
+// It is kept when the writer is reset.
+type tablesHeader struct {
+	t     *Tables
+	bits  []uint16 // The header after the first 3 bits, 16 bits per entry.
+	nbits int      // The number of bits in bits.
+}
+
 func newHuffmanBitWriter(w io.Writer) *huffmanBitWriter {
 	return &huffmanBitWriter{
 		writer:          w,
@@ -544,12 +556,22 @@ func (w *huffmanBitWriter) writeBlock(tokens *tokens, eof bool, input []byte) {
 		return
 	}
 
+	if w.blockType == BlockFixed {
+		w.writeBlockFixed(tokens, eof)
+		return
+	}
+	if w.tables != nil && w.writeBlockTables(tokens, eof, input) {
+		return
+	}
 	tokens.AddEOB()
 	if w.lastHeader > 0 {
 		// We owe an EOB
 		w.writeCode(w.literalEncoding.codes[endBlockMarker])
 		w.lastHeader = 0
 	}
+	if w.blockType == BlockDynamic {
+		input = nil
+	}
 	numLiterals, numOffsets := w.indexTokens(tokens, false)
 	w.generate(tokens)
 	var extraBits int
@@ -573,7 +595,7 @@ func (w *huffmanBitWriter) writeBlock(tokens *tokens, eof bool, input []byte) {
 	w.codegenEncoding.generate(w.codegenFreq[:], 7)
 	dynamicSize, numCodegens := w.dynamicSize(w.literalEncoding, w.offsetEncoding, extraBits)
 
-	if dynamicSize < size {
+	if dynamicSize < size || w.blockType == BlockDynamic {
 		size = dynamicSize
 		literalEncoding = w.literalEncoding
 		offsetEncoding = w.offsetEncoding
@@ -606,6 +628,16 @@ func (w *huffmanBitWriter) writeBlockDynamic(tokens *tokens, eof bool, input []b
 	if w.err != nil {
 		return
 	}
+	if w.blockType == BlockFixed {
+		w.writeBlockFixed(tokens, eof)
+		return
+	}
+	if w.tables != nil && w.writeBlockTables(tokens, eof, input) {
+		return
+	}
+	if w.blockType == BlockDynamic {
+		input = nil
+	}
 
 	sync = sync || eof
 	if sync {
@@ -684,6 +716,156 @@ func (w *huffmanBitWriter) writeBlockDynamic(tokens *tokens, eof bool, input []b
 	w.writeTokens(tokens.Slice(), w.literalEncoding.codes, w.offsetEncoding.codes)
 }
 
+// writeBlockFixed writes a block of tokens using the fixed Huffman codes.
+func (w *huffmanBitWriter) writeBlockFixed(tokens *tokens, eof bool) {
+	tokens.AddEOB()
+	w.writeFixedHeader(eof)
+	w.writeTokens(tokens.Slice(), fixedLiteralEncoding.codes, fixedOffsetEncoding.codes)
+}
+
+// writeBlockTables encodes a block of tokens with w.tables,
+// the fixed Huffman codes or as a stored block, whichever is smaller.
+// If no tokens were generated, input is encoded as literals.
+// If the tables cannot encode the block false is returned,
+// and nothing is written.
+func (w *huffmanBitWriter) writeBlockTables(tokens *tokens, eof bool, input []byte) bool {
+	if w.err != nil {
+		return true
+	}
+	t := w.tables
+	if tokens.n == 0 {
+		for _, b := range input {
+			if t.lit.codes[b].len == 0 {
+				return false
+			}
+		}
+	} else if !t.canEncode(tokens.litHist[:], tokens.extraHist[:literalCount-256], tokens.offHist[:offsetCodeCount]) {
+		return false
+	}
+	if w.lastHeader > 0 {
+		// We owe an EOB
+		w.writeCode(w.literalEncoding.codes[endBlockMarker])
+		w.lastHeader = 0
+		w.lastHuffMan = false
+	}
+	w.cacheTablesHeader(t)
+	if tokens.n == 0 {
+		for _, b := range input {
+			tokens.AddLiteral(b)
+		}
+	}
+	tokens.AddEOB()
+
+	w.indexTokens(tokens, true)
+	extraBits := w.extraBitSize()
+	size := 3 + w.tablesHeader.nbits +
+		t.lit.bitLength(w.literalFreq[:literalCount]) +
+		t.off.bitLength(w.offsetFreq[:offsetCodeCount]) +
+		extraBits
+	if w.blockType != BlockDynamic {
+		fixedSize := w.fixedSize(extraBits)
+		if ssize, storable := w.storedSize(input); storable && ssize < size && ssize < fixedSize {
+			w.writeStoredHeader(len(input), eof)
+			w.writeBytes(input)
+			return true
+		}
+		if fixedSize < size {
+			w.writeFixedHeader(eof)
+			w.writeTokens(tokens.Slice(), fixedLiteralEncoding.codes, fixedOffsetEncoding.codes)
+			return true
+		}
+	}
+	w.writeTablesHeader(eof)
+	w.writeTokens(tokens.Slice(), t.lit.codes, t.off.codes)
+	return true
+}
+
+// writeBlockTablesHuff encodes input as literals with w.tables,
+// unless it is smaller stored.
+// If the tables cannot encode input false is returned,
+// and nothing is written.
+func (w *huffmanBitWriter) writeBlockTablesHuff(eof bool, input []byte) bool {
+	if w.err != nil {
+		return true
+	}
+	t := w.tables
+	codes := t.lit.codes[:256]
+	size := 3 + int(t.lit.codes[endBlockMarker].len)
+	for _, b := range input {
+		if codes[b].len == 0 {
+			return false
+		}
+		size += int(codes[b].len)
+	}
+	if w.lastHeader > 0 {
+		// We owe an EOB
+		w.writeCode(w.literalEncoding.codes[endBlockMarker])
+		w.lastHeader = 0
+		w.lastHuffMan = false
+	}
+	w.cacheTablesHeader(t)
+	size += w.tablesHeader.nbits
+	if ssize, storable := w.storedSize(input); storable && ssize < size && w.blockType != BlockDynamic {
+		w.writeStoredHeader(len(input), eof)
+		w.writeBytes(input)
+		return true
+	}
+	w.writeTablesHeader(eof)
+	for _, b := range input {
+		w.writeCode(codes[b])
+	}
+	w.writeCode(t.lit.codes[endBlockMarker])
+	return true
+}
+
+// cacheTablesHeader encodes the dynamic block header of t,
+// unless it is already cached.
+func (w *huffmanBitWriter) cacheTablesHeader(t *Tables) {
+	h := &w.tablesHeader
+	if h.t == t {
+		return
+	}
+	var buf bytes.Buffer
+	hw := newHuffmanBitWriter(&buf)
+	hw.generateCodegen(t.numLiterals, t.numOffsets, t.lit, t.off)
+	hw.codegenEncoding.generate(hw.codegenFreq[:], 7)
+	hw.writeDynamicHeader(t.numLiterals, t.numOffsets, hw.codegens(), false)
+	size, _ := hw.headerSize()
+	hw.flush()
+	b := buf.Bytes()
+
+	// Store the bits after the block type.
+	h.t = t
+	h.nbits = size - 3
+	h.bits = h.bits[:0]
+	for pos := 3; pos < size; pos += 16 {
+		var v uint16
+		for i := 0; i < 16 && pos+i < size; i++ {
+			bit := pos + i
+			v |= uint16(b[bit>>3]>>uint(bit&7)&1) << uint(i)
+		}
+		h.bits = append(h.bits, v)
+	}
+}
+
+// writeTablesHeader writes the cached header of w.tables.
+func (w *huffmanBitWriter) writeTablesHeader(eof bool) {
+	var firstBits int32 = 4
+	if eof {
+		firstBits = 5
+	}
+	w.writeBits(firstBits, 3)
+	n := w.tablesHeader.nbits
+	for _, v := range w.tablesHeader.bits {
+		nb := 16
+		if n < nb {
+			nb = n
+		}
+		w.writeBits(int32(v), uint16(nb))
+		n -= nb
+	}
+}
+
 // indexTokens indexes a slice of tokens, and updates
 // literalFreq and offsetFreq, and generates literalEncoding
 // and offsetEncoding.
@@ -815,6 +997,18 @@ func (w *huffmanBitWriter) writeBlockHuff(eof bool, input []byte, sync bool) {
 		return
 	}
 
+	if w.blockType == BlockFixed {
+		w.writeFixedHeader(eof)
+		for _, b := range input {
+			w.writeCode(fixedLiteralEncoding.codes[b])
+		}
+		w.writeCode(fixedLiteralEncoding.codes[endBlockMarker])
+		return
+	}
+	if w.tables != nil && w.writeBlockTablesHuff(eof, input) {
+		return
+	}
+
 	// Clear histogram
 	for i := range w.literalFreq[:] {
 		w.literalFreq[i] = 0
@@ -839,7 +1033,7 @@ func (w *huffmanBitWriter) writeBlockHuff(eof bool, input []byte, sync bool) {
 
 	// Store bytes, if we don't get a reasonable improvement.
 	ssize, storable := w.storedSize(input)
-	if storable && ssize < estBits {
+	if storable && ssize < estBits && w.blockType != BlockDynamic {
 		w.writeStoredHeader(len(input), eof)
 		w.writeBytes(input)
 		return
@@ -26,6 +26,10 @@ const (
 	maxNumDist = 30
 	numCodes   = 19 // number of codes in Huffman meta-code
 
+	// Deflate64 allows distance codes 30 and 31 and a 64KB window.
+	maxNumDist64 = 32
+	windowSize64 = 1 << 16
+
 	debugDecode = false
 )
 
@@ -289,17 +293,36 @@ type Reader interface {
 	io.ByteReader
 }
 
+// BufferedReader is a Reader that gives access to its buffered input.
+// If the reader passed to NewReader implements BufferedReader,
+// the decompressor decodes directly from the slices returned by Peek,
+// which is much faster than calling ReadByte for every byte.
+// *bufio.Reader implements BufferedReader.
+//
+// Buffered must return the number of bytes that can be returned by Peek
+// without blocking. Input is removed with Discard once it is decoded,
+// so r is never read past the end of the compressed stream.
+type BufferedReader interface {
+	Reader
+	Buffered() int
+	Peek(n int) ([]byte, error)
+	Discard(n int) (discarded int, err error)
+}
+
 // Decompress state.
 type decompressor struct {
 	// Input source.
 	r       Reader
 	roffset int64
 
+	// Input read from a BufferedReader, when r is &pr.
+	pr peekReader
+
 	// Huffman decoders for literal/length, distance.
 	h1, h2 huffmanDecoder
 
 	// Length arrays used to define Huffman codes.
-	bits     *[maxNumLit + maxNumDist]int
+	bits     *[maxNumLit + maxNumDist64]int
 	codebits *[numCodes]int
 
 	// Output history, buffer.
@@ -323,6 +346,15 @@ type decompressor struct {
 
 	nb    uint
 	final bool
+
+	// blockFn is called at the start of each block, if set.
+	blockFn func(BlockInfo)
+	// skipBits is the number of bits to skip in the first byte when resuming.
+	skipBits uint
+	// afterSync is set if the previous block was an empty stored block.
+	afterSync bool
+	// deflate64 is set when decoding Deflate64 streams.
+	deflate64 bool
 }
 
 func (f *decompressor) nextBlock() {
@@ -332,9 +364,19 @@ func (f *decompressor) nextBlock() {
 		}
 	}
 	f.final = f.b&1 == 1
-	f.b >>= 1
-	typ := f.b & 3
-	f.b >>= 2
+	typ := (f.b >> 1) & 3
+	if f.blockFn != nil && typ != 3 {
+		f.blockFn(BlockInfo{
+			BitOffset: f.roffset*8 - int64(f.nb),
+			Offset:    f.dict.written(),
+			Type:      blockTypes[typ],
+			Final:     f.final,
+			AfterSync: f.afterSync,
+			dict:      &f.dict,
+		})
+	}
+	f.afterSync = false
+	f.b >>= 1 + 2
 	f.nb -= 1 + 2
 	switch typ {
 	case 0:
@@ -375,6 +417,7 @@ func (f *decompressor) Read(b []byte) (int, error) {
 			return 0, f.err
 		}
 		f.step(f)
+		f.releaseInput()
 		if f.err != nil && len(f.toRead) == 0 {
 			f.toRead = f.dict.readFlush() // Flush what's left in case of error
 		}
@@ -406,6 +449,7 @@ func (f *decompressor) WriteTo(w io.Writer) (int64, error) {
 		}
 		if f.err == nil {
 			f.step(f)
+			f.releaseInput()
 		}
 		if len(f.toRead) == 0 && f.err != nil && !flushed {
 			f.toRead = f.dict.readFlush() // Flush what's left in case of error
@@ -442,7 +486,7 @@ func (f *decompressor) readHuffman() error {
 	}
 	f.b >>= 5
 	ndist := int(f.b&0x1F) + 1
-	if ndist > maxNumDist {
+	if ndist > maxNumDist && !f.deflate64 {
 		if debugDecode {
 			fmt.Println("ndist > maxNumDist", ndist)
 		}
@@ -666,8 +710,14 @@ readLiteral:
 			length = v*32 - (281*32 - 131)
 			n = 5
 		case v < maxNumLit:
-			length = 258
-			n = 0
+			if f.deflate64 {
+				// Deflate64 stores 16 extra bits for the last length code.
+				length = 3
+				n = 16
+			} else {
+				length = 258
+				n = 0
+			}
 		default:
 			if debugDecode {
 				fmt.Println(v, ">= maxNumLit")
@@ -717,7 +767,7 @@ readLiteral:
 		switch {
 		case dist < 4:
 			dist++
-		case dist < maxNumDist:
+		case dist < maxNumDist, f.deflate64 && dist < maxNumDist64:
 			nb := uint(dist-2) >> 1
 			// have 1 bit in bottom of dist, need nb more.
 			extra := (dist & 1) << nb
@@ -789,7 +839,7 @@ func (f *decompressor) dataBlock() {
 	f.buf[2] = uint8(f.b >> 16)
 	f.buf[3] = uint8(f.b >> 24)
 
-	f.roffset += int64(offBytes)
+	// The bytes in f.b have already been counted in f.roffset.
 	f.nb, f.b = 0, 0
 
 	// Length then ones-complement of length.
@@ -811,6 +861,8 @@ func (f *decompressor) dataBlock() {
 	}
 
 	if n == 0 {
+		// Empty stored blocks are written on flush.
+		f.afterSync = true
 		f.toRead = f.dict.readFlush()
 		f.finishBlock()
 		return
@@ -927,6 +979,96 @@ func makeReader(r io.Reader) Reader {
 	return bufio.NewReader(r)
 }
 
+// setReader sets the input of f to r.
+func (f *decompressor) setReader(r io.Reader) {
+	switch rr := r.(type) {
+	case *bufio.Reader:
+		// Has its own decoder.
+		f.r = rr
+	case BufferedReader:
+		f.pr = peekReader{r: rr}
+		f.r = &f.pr
+	default:
+		f.r = makeReader(r)
+	}
+}
+
+// releaseInput discards the input decoded from a BufferedReader.
+func (f *decompressor) releaseInput() {
+	if f.pr.n == 0 {
+		return
+	}
+	if err := f.pr.release(); err != nil && f.err == nil {
+		f.err = err
+	}
+}
+
+// peekReader reads from the buffer of a BufferedReader.
+// Bytes that have been read are discarded from r by release.
+type peekReader struct {
+	r   BufferedReader
+	buf []byte // Input returned by Peek.
+	n   int    // Bytes read from buf.
+}
+
+func (p *peekReader) ReadByte() (byte, error) {
+	if p.n < len(p.buf) {
+		c := p.buf[p.n]
+		p.n++
+		return c, nil
+	}
+	return p.readByteSlow()
+}
+
+// readByteSlow peeks at all buffered input and returns the first byte.
+func (p *peekReader) readByteSlow() (byte, error) {
+	if err := p.release(); err != nil {
+		return 0, err
+	}
+	n := p.r.Buffered()
+	if n == 0 {
+		// Wait for input.
+		if _, err := p.r.Peek(1); err != nil {
+			return 0, err
+		}
+		if n = p.r.Buffered(); n == 0 {
+			n = 1
+		}
+	}
+	buf, err := p.r.Peek(n)
+	if len(buf) == 0 {
+		if err == nil {
+			err = io.ErrNoProgress
+		}
+		return 0, err
+	}
+	p.buf, p.n = buf, 1
+	return buf[0], nil
+}
+
+func (p *peekReader) Read(b []byte) (int, error) {
+	if p.n < len(p.buf) {
+		n := copy(b, p.buf[p.n:])
+		p.n += n
+		return n, nil
+	}
+	if err := p.release(); err != nil {
+		return 0, err
+	}
+	return p.r.Read(b)
+}
+
+// release discards the bytes read from buf from r.
+func (p *peekReader) release() error {
+	n := p.n
+	p.buf, p.n = nil, 0
+	if n == 0 {
+		return nil
+	}
+	_, err := p.r.Discard(n)
+	return err
+}
+
 func fixedHuffmanDecoderInit() {
 	fixedOnce.Do(func() {
 		// These come from the RFC section 3.2.6.
@@ -947,17 +1089,27 @@ func fixedHuffmanDecoderInit() {
 	})
 }
 
+// windowSize returns the size of the history window.
+func (f *decompressor) windowSize() int {
+	if f.deflate64 {
+		return windowSize64
+	}
+	return maxMatchOffset
+}
+
 func (f *decompressor) Reset(r io.Reader, dict []byte) error {
 	*f = decompressor{
-		r:        makeReader(r),
-		bits:     f.bits,
-		codebits: f.codebits,
-		h1:       f.h1,
-		h2:       f.h2,
-		dict:     f.dict,
-		step:     (*decompressor).nextBlock,
+		bits:      f.bits,
+		codebits:  f.codebits,
+		h1:        f.h1,
+		h2:        f.h2,
+		dict:      f.dict,
+		step:      (*decompressor).nextBlock,
+		blockFn:   f.blockFn,
+		deflate64: f.deflate64,
 	}
-	f.dict.init(maxMatchOffset, dict)
+	f.setReader(r)
+	f.dict.init(f.windowSize(), dict)
 	return nil
 }
 
@@ -973,8 +1125,8 @@ func NewReader(r io.Reader) io.ReadCloser {
 	fixedHuffmanDecoderInit()
 
 	var f decompressor
-	f.r = makeReader(r)
-	f.bits = new([maxNumLit + maxNumDist]int)
+	f.setReader(r)
+	f.bits = new([maxNumLit + maxNumDist64]int)
 	f.codebits = new([numCodes]int)
 	f.step = (*decompressor).nextBlock
 	f.dict.init(maxMatchOffset, nil)
@@ -992,10 +1144,32 @@ func NewReaderDict(r io.Reader, dict []byte) io.ReadCloser {
 	fixedHuffmanDecoderInit()
 
 	var f decompressor
-	f.r = makeReader(r)
-	f.bits = new([maxNumLit + maxNumDist]int)
+	f.setReader(r)
+	f.bits = new([maxNumLit + maxNumDist64]int)
 	f.codebits = new([numCodes]int)
 	f.step = (*decompressor).nextBlock
 	f.dict.init(maxMatchOffset, dict)
 	return &f
 }
+
+// NewReader64 returns a new ReadCloser that can be used to read the
+// uncompressed version of r, which must be compressed with Deflate64,
+// also known as Enhanced Deflate.
+// Deflate64 uses a 64KB window, distance codes 30 and 31,
+// and 16 extra bits for the last length code.
+// It is used by zip files with method 9.
+//
+// The ReadCloser returned by NewReader64 also implements Resetter.
+// Reset keeps reading Deflate64 streams.
+func NewReader64(r io.Reader) io.ReadCloser {
+	fixedHuffmanDecoderInit()
+
+	var f decompressor
+	f.setReader(r)
+	f.bits = new([maxNumLit + maxNumDist64]int)
+	f.codebits = new([numCodes]int)
+	f.step = (*decompressor).nextBlock
+	f.deflate64 = true
+	f.dict.init(windowSize64, nil)
+	return &f
+}
@@ -0,0 +1,221 @@
+package flate
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// testBitWriter writes deflate bit streams for hand-made test vectors.
+type testBitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+// writeBits writes the n lowest bits of v, least significant bit first.
+func (w *testBitWriter) writeBits(v uint64, n uint) {
+	w.bits |= v << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+// writeCode writes a Huffman code of n bits, most significant bit first.
+func (w *testBitWriter) writeCode(code uint64, n uint) {
+	var rev uint64
+	for i := uint(0); i < n; i++ {
+		rev = rev<<1 | (code>>i)&1
+	}
+	w.writeBits(rev, n)
+}
+
+func (w *testBitWriter) align() {
+	if w.nbits > 0 {
+		w.writeBits(0, 8-w.nbits)
+	}
+}
+
+// canonicalCodes returns the canonical Huffman codes for the given lengths.
+func canonicalCodes(lengths []int) []uint64 {
+	var count [maxCodeLen + 1]uint64
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [maxCodeLen + 1]uint64
+	var code uint64
+	for i := 1; i <= maxCodeLen; i++ {
+		code = (code + count[i-1]) << 1
+		next[i] = code
+	}
+	codes := make([]uint64, len(lengths))
+	for i, l := range lengths {
+		if l > 0 {
+			codes[i] = next[l]
+			next[l]++
+		}
+	}
+	return codes
+}
+
+// deflate64Stream returns a Deflate64 stream and the expected output.
+// The stream contains a stored block, a fixed block and a dynamic block
+// with 32 distance codes, and uses lengths and distances only valid in Deflate64.
+func deflate64Stream() (stream, want []byte) {
+	rng := rand.New(rand.NewSource(64))
+	want = make([]byte, 60000)
+	rng.Read(want)
+
+	var w testBitWriter
+	// Stored block.
+	w.writeBits(0, 1)
+	w.writeBits(0, 2)
+	w.align()
+	w.writeBits(uint64(len(want)), 16)
+	w.writeBits(uint64(^uint16(len(want))), 16)
+	w.out = append(w.out, want...)
+
+	copyMatch := func(length, dist int) {
+		for i := 0; i < length; i++ {
+			want = append(want, want[len(want)-dist])
+		}
+	}
+
+	// Fixed block.
+	w.writeBits(0, 1)
+	w.writeBits(1, 2)
+	// Length code 285 with 16 extra bits, distance code 31.
+	w.writeCode(0xc0+285-280, 8)
+	w.writeBits(1000, 16)
+	w.writeCode(31, 5)
+	w.writeBits(100, 14)
+	copyMatch(3+1000, 49153+100)
+	// Literal.
+	w.writeCode(0x30+'x', 8)
+	want = append(want, 'x')
+	// Maximum length with distance code 30.
+	w.writeCode(0xc0+285-280, 8)
+	w.writeBits(0xffff, 16)
+	w.writeCode(30, 5)
+	w.writeBits(0, 14)
+	copyMatch(3+0xffff, 32769)
+	// End of block.
+	w.writeCode(0, 7)
+
+	// Dynamic block with all literal/length codes and 32 distance codes.
+	w.writeBits(1, 1)
+	w.writeBits(2, 2)
+	w.writeBits(maxNumLit-257, 5)
+	w.writeBits(maxNumDist64-1, 5)
+	// Code lengths 8, 9 and 5 are used; 5 is at index 9 of codeOrder.
+	w.writeBits(10-4, 4)
+	clLengths := make([]int, numCodes)
+	clLengths[8], clLengths[9], clLengths[5] = 1, 2, 2
+	for _, c := range codeOrder[:10] {
+		w.writeBits(uint64(clLengths[c]), 3)
+	}
+	clCodes := canonicalCodes(clLengths)
+	litLengths := make([]int, maxNumLit)
+	for i := range litLengths {
+		litLengths[i] = 8
+		if i >= 226 {
+			litLengths[i] = 9
+		}
+	}
+	distLengths := make([]int, maxNumDist64)
+	for i := range distLengths {
+		distLengths[i] = 5
+	}
+	for _, l := range append(append([]int{}, litLengths...), distLengths...) {
+		w.writeCode(clCodes[l], uint(clLengths[l]))
+	}
+	litCodes := canonicalCodes(litLengths)
+	distCodes := canonicalCodes(distLengths)
+	writeLit := func(v int) {
+		w.writeCode(litCodes[v], uint(litLengths[v]))
+	}
+	writeLit('y')
+	want = append(want, 'y')
+	writeLit(285)
+	w.writeBits(12345, 16)
+	w.writeCode(distCodes[31], 5)
+	w.writeBits(0x3fff, 14)
+	copyMatch(3+12345, 49153+0x3fff)
+	writeLit(285)
+	w.writeBits(0, 16)
+	w.writeCode(distCodes[30], 5)
+	w.writeBits(7, 14)
+	copyMatch(3, 32769+7)
+	writeLit(endBlockMarker)
+	w.align()
+	return w.out, want
+}
+
+// plainByteReader only implements Reader, to use the generic decoder.
+type plainByteReader struct {
+	r *bytes.Reader
+}
+
+func (p *plainByteReader) Read(b []byte) (int, error) { return p.r.Read(b) }
+func (p *plainByteReader) ReadByte() (byte, error)    { return p.r.ReadByte() }
+
+func TestReader64(t *testing.T) {
+	stream, want := deflate64Stream()
+	readers := map[string]func() io.Reader{
+		"bytes.Buffer":   func() io.Reader { return bytes.NewBuffer(stream) },
+		"bytes.Reader":   func() io.Reader { return bytes.NewReader(stream) },
+		"bufio.Reader":   func() io.Reader { return bufio.NewReader(bytes.NewReader(stream)) },
+		"strings.Reader": func() io.Reader { return strings.NewReader(string(stream)) },
+		"generic":        func() io.Reader { return &plainByteReader{r: bytes.NewReader(stream)} },
+	}
+	for name, fn := range readers {
+		t.Run(name, func(t *testing.T) {
+			r := NewReader64(fn())
+			for i := 0; i < 2; i++ {
+				got, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("output mismatch, got %d bytes, want %d", len(got), len(want))
+				}
+				// Reset must keep decoding Deflate64.
+				if err := r.(Resetter).Reset(fn(), nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+
+	// The regular decoder must reject the stream.
+	if _, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream))); err == nil {
+		t.Fatal("deflate decoder accepted deflate64 stream")
+	} else if _, ok := err.(CorruptInputError); !ok {
+		t.Fatalf("got %v, want CorruptInputError", err)
+	}
+}
+
+func TestReader64Deflate(t *testing.T) {
+	// Streams without length code 285 and distance codes 30 and 31
+	// decode the same with both decoders.
+	input := bytes.Repeat([]byte("deflate64 decodes most deflate streams "), 1000)
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, HuffmanOnly)
+	w.Write(input)
+	w.Close()
+	got, err := ioutil.ReadAll(NewReader64(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, input) {
+		t.Fatal("output mismatch")
+	}
+}
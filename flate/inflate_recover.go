@@ -0,0 +1,367 @@
+package flate
+
+import (
+	"bytes"
+	"io"
+)
+
+// recoverHeaderBytes is the maximum size of a dynamic block header,
+// rounded up.
+const recoverHeaderBytes = 600
+
+// RecoveryGap describes corrupt input skipped by a RecoveryReader.
+type RecoveryGap struct {
+	// Err is the error that stopped decompression.
+	Err error
+
+	// BitOffset is the offset of the header of the corrupt block
+	// in the compressed input, in bits.
+	BitOffset int64
+
+	// ResumeBitOffset is the offset of the block header
+	// where decompression resumed, in bits.
+	ResumeBitOffset int64
+
+	// Offset is the number of uncompressed bytes returned before decompression resumed.
+	// This includes data decompressed from the corrupt block before the error
+	// was detected, which may be wrong.
+	Offset int64
+}
+
+// A RecoveryReader decompresses a deflate stream and continues after
+// corrupt input, returning whatever data can be recovered.
+//
+// When a CorruptInputError occurs, the compressed input following the start
+// of the corrupt block is scanned bit by bit for the next plausible block header.
+// This is a stored block with a valid length and zero padding,
+// or a dynamic block with Huffman tables that decode cleanly.
+// Fixed Huffman blocks cannot be told apart from random data,
+// and are skipped until the next header of another type.
+//
+// Decompression resumes at the header found. Since the amount of data lost
+// is unknown, the history before it is replaced with placeholder bytes,
+// and back references to data before the gap will return placeholders.
+// Each gap is reported to the callback.
+//
+// If no header is found before the end of the input, the original error is returned.
+// Headers found by scanning may be false positives, in which case
+// garbage may be returned until the next error.
+type RecoveryReader struct {
+	f           decompressor
+	in          recordReader
+	fn          func(RecoveryGap)
+	err         error
+	blockStart  int64 // Bit offset of the current block header.
+	placeholder byte
+	window      []byte // Placeholder window used when resuming.
+
+	// trial is used to check dynamic headers.
+	trial   decompressor
+	trialIn bytes.Reader
+}
+
+// NewRecoveryReader returns a new RecoveryReader reading r.
+// fn is called on every gap in the output and may be nil.
+// The placeholder byte is 0.
+//
+// As with NewReader, if r does not also implement io.ByteReader,
+// the reader may read more data than necessary from r.
+func NewRecoveryReader(r io.Reader, fn func(RecoveryGap)) *RecoveryReader {
+	fixedHuffmanDecoderInit()
+
+	z := &RecoveryReader{fn: fn}
+	z.in.r = makeReader(r)
+	z.f.r = &z.in
+	z.f.bits = new([maxNumLit + maxNumDist64]int)
+	z.f.codebits = new([numCodes]int)
+	z.f.step = (*decompressor).nextBlock
+	z.f.blockFn = z.block
+	z.f.dict.init(maxMatchOffset, nil)
+	z.trial.bits = new([maxNumLit + maxNumDist64]int)
+	z.trial.codebits = new([numCodes]int)
+	return z
+}
+
+// SetPlaceholder sets the byte returned for back references to data lost in a gap.
+func (z *RecoveryReader) SetPlaceholder(b byte) {
+	if b != z.placeholder {
+		z.placeholder = b
+		z.window = nil
+	}
+}
+
+// Read implements io.Reader.
+func (z *RecoveryReader) Read(p []byte) (int, error) {
+	for {
+		if z.err != nil {
+			return 0, z.err
+		}
+		n, err := z.f.Read(p)
+		if _, ok := err.(CorruptInputError); !ok {
+			return n, err
+		}
+		if n > 0 {
+			// The error is returned again on the next call.
+			return n, nil
+		}
+		z.err = z.recover(err)
+	}
+}
+
+// WriteTo implements io.WriterTo.
+func (z *RecoveryReader) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		if z.err != nil {
+			return total, z.err
+		}
+		n, err := z.f.WriteTo(w)
+		total += n
+		if _, ok := err.(CorruptInputError); !ok {
+			return total, err
+		}
+		z.err = z.recover(err)
+	}
+}
+
+// Close returns the error that stopped decompression, if any.
+// It does not close the underlying reader.
+func (z *RecoveryReader) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	return z.f.Close()
+}
+
+// Reset discards the state of z and continues reading from r,
+// with dict as preset dictionary.
+// The callback and placeholder are retained.
+func (z *RecoveryReader) Reset(r io.Reader, dict []byte) error {
+	z.in = recordReader{r: makeReader(r), rec: z.in.rec[:0]}
+	z.err = nil
+	z.blockStart = 0
+	return z.f.Reset(&z.in, dict)
+}
+
+// ReadAhead returns compressed input that was read while scanning
+// for a block header but has not been decompressed.
+// After the end of the stream this is input following the stream,
+// which would otherwise have been left in r.
+// The returned slice is only valid until the next call to z.
+func (z *RecoveryReader) ReadAhead() []byte {
+	return z.in.pending
+}
+
+// block is called at the start of each block.
+func (z *RecoveryReader) block(b BlockInfo) {
+	z.blockStart = b.BitOffset
+	z.in.discard(b.BitOffset / 8)
+}
+
+// recover scans for the next block header after the block that caused
+// cause and resumes decompression there.
+// If no header can be found cause is returned.
+func (z *RecoveryReader) recover(cause error) error {
+	in := &z.in
+	for pos := z.blockStart + 1; ; pos++ {
+		if pos%8 == 0 {
+			in.discard(pos / 8)
+		}
+		typ, ok := in.bits(pos+1, 2)
+		if !ok {
+			return cause
+		}
+		switch typ {
+		case 0:
+			if !in.validStored(pos) {
+				continue
+			}
+			// The last bit of the previous block can be read as the final bit
+			// of a stored header starting one bit earlier.
+			// Non-final stored blocks must be followed by another header.
+			if final, _ := in.bits(pos, 1); final == 1 {
+				if z.validNext(pos + 1) {
+					pos++
+				}
+			} else if !z.validNext(pos) {
+				continue
+			}
+		case 2:
+			if !z.validDynamic(pos) {
+				continue
+			}
+		default:
+			continue
+		}
+		gap := RecoveryGap{
+			Err:             cause,
+			BitOffset:       z.blockStart,
+			ResumeBitOffset: pos,
+			Offset:          z.f.dict.total,
+		}
+		z.resume(pos)
+		if z.fn != nil {
+			z.fn(gap)
+		}
+		return nil
+	}
+}
+
+// validNext returns whether pos is a non-final stored block header
+// followed by another plausible header.
+func (z *RecoveryReader) validNext(pos int64) bool {
+	in := &z.in
+	if final, ok := in.bits(pos, 1); !ok || final != 0 || !in.validStored(pos) {
+		return false
+	}
+	start := (pos + 3 + 7) &^ 7
+	n, _ := in.bits(start, 16)
+	next := start + 32 + 8*int64(n)
+	typ, ok := in.bits(next+1, 2)
+	if !ok {
+		return false
+	}
+	switch typ {
+	case 0:
+		return in.validStored(next)
+	case 1:
+		return true
+	case 2:
+		return z.validDynamic(next)
+	}
+	return false
+}
+
+// validDynamic returns whether a dynamic block header at bit pos decodes cleanly.
+func (z *RecoveryReader) validDynamic(pos int64) bool {
+	in := &z.in
+	idx := int(pos/8 - in.recBase)
+	// If the input ends before, the header fails to decode.
+	in.fill(idx + recoverHeaderBytes)
+	z.trialIn.Reset(in.rec[idx:])
+	t := &z.trial
+	t.r = &z.trialIn
+	t.roffset, t.b, t.nb = 0, 0, 0
+	t.deflate64 = z.f.deflate64
+	skip := uint(pos%8) + 3
+	for t.nb < skip {
+		if t.moreBits() != nil {
+			return false
+		}
+	}
+	t.b >>= skip
+	t.nb -= skip
+	return t.readHuffman() == nil
+}
+
+// resume continues decompression at the block header at bit pos,
+// with a window of placeholders.
+func (z *RecoveryReader) resume(pos int64) {
+	in := &z.in
+	idx := pos/8 - in.recBase
+	pending := make([]byte, 0, int64(len(in.rec))-idx+int64(len(in.pending)))
+	pending = append(pending, in.rec[idx:]...)
+	in.pending = append(pending, in.pending...)
+	in.rec = in.rec[:0]
+	in.recBase = pos / 8
+
+	if len(z.window) != z.f.windowSize() {
+		z.window = bytes.Repeat([]byte{z.placeholder}, z.f.windowSize())
+	}
+	offset := z.f.dict.total
+	z.f.Reset(in, z.window)
+	z.f.resumeAt(pos, offset)
+}
+
+// recordReader records the input read since recBase,
+// so it can be scanned if the data is corrupt.
+type recordReader struct {
+	r       Reader
+	pending []byte // Input read ahead, returned before reading from r.
+	rec     []byte // Input read, starting at byte recBase.
+	recBase int64
+}
+
+func (rr *recordReader) Read(p []byte) (int, error) {
+	var n int
+	var err error
+	if len(rr.pending) > 0 {
+		n = copy(p, rr.pending)
+		rr.pending = rr.pending[n:]
+	} else {
+		n, err = rr.r.Read(p)
+	}
+	rr.rec = append(rr.rec, p[:n]...)
+	return n, err
+}
+
+func (rr *recordReader) ReadByte() (byte, error) {
+	var b byte
+	if len(rr.pending) > 0 {
+		b = rr.pending[0]
+		rr.pending = rr.pending[1:]
+	} else {
+		var err error
+		if b, err = rr.r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	rr.rec = append(rr.rec, b)
+	return b, nil
+}
+
+// discard drops recorded input before byte offset off.
+func (rr *recordReader) discard(off int64) {
+	n := off - rr.recBase
+	if n <= 0 {
+		return
+	}
+	if n > int64(len(rr.rec)) {
+		n = int64(len(rr.rec))
+	}
+	rr.rec = append(rr.rec[:0], rr.rec[n:]...)
+	rr.recBase += n
+}
+
+// fill reads input until n bytes are recorded.
+// It returns false if the input ends before.
+func (rr *recordReader) fill(n int) bool {
+	for len(rr.rec) < n {
+		if _, err := rr.ReadByte(); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// bits returns n bits starting at bit offset pos.
+// It returns false if the input ends before.
+func (rr *recordReader) bits(pos int64, n uint) (uint32, bool) {
+	if !rr.fill(int((pos+int64(n)+7)/8 - rr.recBase)) {
+		return 0, false
+	}
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		p := pos + int64(i)
+		v |= uint32(rr.rec[p/8-rr.recBase]>>uint(p%8)&1) << i
+	}
+	return v, true
+}
+
+// validStored returns whether a stored block header at bit pos
+// has zero padding and a length matching its complement.
+// The block type is not checked.
+func (rr *recordReader) validStored(pos int64) bool {
+	start := (pos + 3 + 7) &^ 7
+	if pad := uint(start - pos - 3); pad > 0 {
+		if v, ok := rr.bits(pos+3, pad); !ok || v != 0 {
+			return false
+		}
+	}
+	n, ok := rr.bits(start, 16)
+	if !ok {
+		return false
+	}
+	nn, ok := rr.bits(start+16, 16)
+	return ok && uint16(nn) == ^uint16(n)
+}
@@ -0,0 +1,161 @@
+package flate
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+// recoverSegments returns segments of text compressed independently
+// into one stream, and the compressed offset of the start of each segment.
+func recoverSegments(t *testing.T) (segs [][]byte, offsets []int, stream []byte) {
+	var buf bytes.Buffer
+	for i := 0; i < 20; i++ {
+		var seg bytes.Buffer
+		for j := 0; j < 500; j++ {
+			fmt.Fprintf(&seg, "segment %d, line %d: %d\n", i, j, i*j*j)
+		}
+		segs = append(segs, seg.Bytes())
+		offsets = append(offsets, buf.Len())
+		if err := StatelessDeflate(&buf, seg.Bytes(), i == 19, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return segs, offsets, buf.Bytes()
+}
+
+func TestRecoveryReader(t *testing.T) {
+	segs, offsets, stream := recoverSegments(t)
+	want := bytes.Join(segs, nil)
+
+	// Uncorrupted input decodes as usual.
+	got, err := ioutil.ReadAll(NewRecoveryReader(bytes.NewReader(stream), func(g RecoveryGap) {
+		t.Errorf("unexpected gap: %+v", g)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("output mismatch")
+	}
+
+	corrupt := append([]byte{}, stream...)
+	mid := (offsets[5] + offsets[6]) / 2
+	for i := mid; i < mid+16; i++ {
+		corrupt[i] = 0xff
+	}
+	if _, err := ioutil.ReadAll(NewReader(bytes.NewReader(corrupt))); err == nil {
+		t.Fatal("corruption not detected")
+	}
+
+	for _, writeTo := range []bool{false, true} {
+		var gaps []RecoveryGap
+		r := NewRecoveryReader(bytes.NewReader(corrupt), func(g RecoveryGap) {
+			gaps = append(gaps, g)
+		})
+		var out bytes.Buffer
+		if writeTo {
+			_, err = r.WriteTo(&out)
+		} else {
+			_, err = out.ReadFrom(ioutil.NopCloser(r))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := out.Bytes()
+		if len(gaps) == 0 {
+			t.Fatal("no gaps reported")
+		}
+		g := gaps[0]
+		if g.BitOffset > int64(mid)*8 || g.ResumeBitOffset <= int64(mid)*8 {
+			t.Errorf("gap %+v does not cover corruption at bit %d", g, mid*8)
+		}
+		if _, ok := g.Err.(CorruptInputError); !ok {
+			t.Errorf("got error %v, want CorruptInputError", g.Err)
+		}
+		head := bytes.Join(segs[:5], nil)
+		if !bytes.HasPrefix(got, head) {
+			t.Error("data before corruption lost")
+		}
+		if g.Offset < int64(len(head)) {
+			t.Errorf("gap offset %d, want >= %d", g.Offset, len(head))
+		}
+		if tail := bytes.Join(segs[7:], nil); !bytes.HasSuffix(got, tail) {
+			t.Error("data after corruption not recovered")
+		}
+		t.Logf("writeTo: %v, gaps: %d, output %d bytes, uncorrupted %d bytes", writeTo, len(gaps), len(got), len(want))
+	}
+}
+
+func TestRecoveryReaderStored(t *testing.T) {
+	// Stored blocks with a window reference after the gap.
+	var buf bytes.Buffer
+	var offsets []int
+	w, _ := NewWriter(&buf, NoCompression)
+	var want []byte
+	for i := 0; i < 10; i++ {
+		seg := bytes.Repeat([]byte{byte('a' + i)}, 1000)
+		want = append(want, seg...)
+		offsets = append(offsets, buf.Len())
+		w.Write(seg)
+		w.Flush()
+	}
+	// A final fixed block that copies 10 bytes from 5000 bytes back.
+	stream := buf.Bytes()
+	var bw testBitWriter
+	bw.writeBits(1, 1)
+	bw.writeBits(1, 2)
+	bw.writeCode(0x0+264-256, 7) // Length 10.
+	bw.writeCode(24, 5)          // Distance 4097-6144.
+	bw.writeBits(5000-4097, 11)
+	bw.writeCode(0, 7)
+	bw.align()
+	stream = append(stream, bw.out...)
+	want = append(want, want[len(want)-5000:][:10]...)
+
+	got, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("test stream mismatch")
+	}
+
+	// Corrupt the length of the eighth block.
+	stream[offsets[7]+1] ^= 0x10
+	var gaps []RecoveryGap
+	r := NewRecoveryReader(bytes.NewReader(stream), func(g RecoveryGap) {
+		gaps = append(gaps, g)
+	})
+	r.SetPlaceholder('?')
+	got, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaps) != 1 {
+		t.Fatalf("got %d gaps, want 1", len(gaps))
+	}
+	if gaps[0].Offset != 7000 {
+		t.Errorf("gap offset %d, want 7000", gaps[0].Offset)
+	}
+	// The empty sync block after the corrupt block is found first.
+	wantOut := append(append([]byte{}, want[:7000]...), want[8000:len(want)-10]...)
+	wantOut = append(wantOut, bytes.Repeat([]byte("?"), 10)...)
+	if !bytes.Equal(got, wantOut) {
+		t.Fatalf("got %d bytes, want %d:\n%q", len(got), len(wantOut), got[len(got)-20:])
+	}
+
+	// Reset keeps the placeholder and callback.
+	gaps = gaps[:0]
+	if err := r.Reset(bytes.NewReader(stream), nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaps) != 1 || !bytes.Equal(got, wantOut) {
+		t.Fatal("Reset: output mismatch")
+	}
+}
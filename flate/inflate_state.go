@@ -0,0 +1,94 @@
+package flate
+
+import (
+	"io"
+)
+
+// BlockInfo contains the position of the start of a deflate block.
+// It is sent to the callback given to NewReaderCallback.
+type BlockInfo struct {
+	// BitOffset is the offset of the block header in the compressed input, in bits.
+	BitOffset int64
+
+	// Offset is the number of uncompressed bytes preceding the block.
+	Offset int64
+
+	// Type is the type of the block: BlockStored, BlockFixed or BlockDynamic.
+	Type BlockType
+
+	// Final is set if this is the last block of the stream.
+	Final bool
+
+	// AfterSync is set if the block follows an empty stored block,
+	// as written by Flush and the zlib Z_SYNC_FLUSH and Z_FULL_FLUSH modes.
+	// The block starts at a byte boundary.
+	// If the stream was written with a full flush, no data before the
+	// block is referenced, and decompression can be resumed without a window.
+	AfterSync bool
+
+	dict *dictDecoder
+}
+
+// Window appends the history preceding the block to dst and returns it.
+// This is the last 32KB of uncompressed data, or less at the start of the stream.
+// Together with BitOffset and Offset, the window can be used
+// to resume decompression at the block with NewReaderResume.
+//
+// Window may only be called during the callback.
+func (b BlockInfo) Window(dst []byte) []byte {
+	return b.dict.appendHist(dst)
+}
+
+// blockTypes converts the block type of the header to a BlockType.
+var blockTypes = [4]BlockType{BlockStored, BlockFixed, BlockDynamic, BlockAuto}
+
+// NewReaderCallback returns a new ReadCloser like NewReader,
+// that calls fn at the start of each deflate block.
+// fn is called on the goroutine decompressing the data,
+// when the first 3 bits of the block header have been read.
+//
+// The callback is retained if the ReadCloser is Reset.
+func NewReaderCallback(r io.Reader, fn func(BlockInfo)) io.ReadCloser {
+	rc := NewReader(r)
+	rc.(*decompressor).blockFn = fn
+	return rc
+}
+
+// NewReaderResume returns a new ReadCloser that resumes decompression at the
+// start of a block, using the position and window reported by NewReaderCallback.
+//
+// r must be positioned at byte bitOffset/8 of the compressed input.
+// offset is the uncompressed offset of the block and is only used for the offsets
+// reported to fn. fn may be nil.
+func NewReaderResume(r io.Reader, bitOffset, offset int64, window []byte, fn func(BlockInfo)) io.ReadCloser {
+	rc := NewReaderDict(r, window)
+	f := rc.(*decompressor)
+	f.blockFn = fn
+	f.resumeAt(bitOffset, offset)
+	return rc
+}
+
+// resumeAt continues decompression at the block starting at bitOffset,
+// with offset bytes of output preceding it.
+// f.r must be positioned at byte bitOffset/8 of the input.
+func (f *decompressor) resumeAt(bitOffset, offset int64) {
+	f.roffset = bitOffset / 8
+	f.dict.total = offset
+	if skip := uint(bitOffset % 8); skip > 0 {
+		f.skipBits = skip
+		f.step = (*decompressor).resumeBlock
+	}
+}
+
+// resumeBlock skips the bits preceding the block in the first byte
+// and continues with the block.
+func (f *decompressor) resumeBlock() {
+	if f.err = f.moreBits(); f.err != nil {
+		return
+	}
+	f.b >>= f.skipBits
+	f.nb -= f.skipBits
+	f.skipBits = 0
+	f.step = (*decompressor).nextBlock
+	f.nextBlock()
+}
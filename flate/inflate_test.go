@@ -5,6 +5,7 @@
 package flate
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"io"
@@ -12,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestReset(t *testing.T) {
@@ -42,6 +44,71 @@ func TestReset(t *testing.T) {
 	}
 }
 
+// bufferedReader is a BufferedReader without a dedicated decoder.
+type bufferedReader struct {
+	*bufio.Reader
+}
+
+func TestReaderBuffered(t *testing.T) {
+	var in bytes.Buffer
+	for i := 0; in.Len() < 200000; i++ {
+		in.WriteString("the quick brown fox " + strconv.Itoa(i) + " jumped over ")
+	}
+	random := make([]byte, 10000)
+	rand.Read(random)
+	in.Write(random)
+	const trailer = "trailer"
+
+	for _, level := range []int{HuffmanOnly, BestSpeed, BestCompression} {
+		var buf bytes.Buffer
+		w, _ := NewWriter(&buf, level)
+		w.Write(in.Bytes())
+		w.Close()
+		buf.WriteString(trailer)
+		stream := buf.Bytes()
+
+		readers := map[string]func() bufferedReader{
+			"bufio": func() bufferedReader {
+				return bufferedReader{bufio.NewReaderSize(bytes.NewReader(stream), 4096)}
+			},
+			"one-byte": func() bufferedReader {
+				return bufferedReader{bufio.NewReader(iotest.OneByteReader(bytes.NewReader(stream)))}
+			},
+		}
+		for name, newReader := range readers {
+			br := newReader()
+			zr := NewReader(br)
+			if _, ok := zr.(*decompressor).r.(*peekReader); !ok {
+				t.Fatalf("level %d, %s: BufferedReader not used", level, name)
+			}
+			got, err := ioutil.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("level %d, %s: %v", level, name, err)
+			}
+			if !bytes.Equal(got, in.Bytes()) {
+				t.Fatalf("level %d, %s: output mismatch", level, name)
+			}
+			if rest, _ := ioutil.ReadAll(br); string(rest) != trailer {
+				t.Fatalf("level %d, %s: got %q after stream, want %q", level, name, rest, trailer)
+			}
+
+			// Same with WriteTo after Reset.
+			br = newReader()
+			zr.(Resetter).Reset(br, nil)
+			var out bytes.Buffer
+			if _, err := io.Copy(&out, zr); err != nil {
+				t.Fatalf("level %d, %s: %v", level, name, err)
+			}
+			if !bytes.Equal(out.Bytes(), in.Bytes()) {
+				t.Fatalf("level %d, %s: WriteTo output mismatch", level, name)
+			}
+			if rest, _ := ioutil.ReadAll(br); string(rest) != trailer {
+				t.Fatalf("level %d, %s: got %q after stream, want %q", level, name, rest, trailer)
+			}
+		}
+	}
+}
+
 func TestReaderTruncated(t *testing.T) {
 	vectors := []struct{ input, output string }{
 		{"\x00", ""},
@@ -280,3 +347,132 @@ func TestWriteTo(t *testing.T) {
 		t.Fatal("output did not match input")
 	}
 }
+
+func TestReaderCallbackResume(t *testing.T) {
+	var input []byte
+	for i := 0; len(input) < 1<<20; i++ {
+		input = strconv.AppendInt(input, int64(i*i), 10)
+		if i%100 == 0 {
+			rnd := make([]byte, 1000)
+			rand.Read(rnd)
+			input = append(input, rnd...)
+		}
+	}
+	for _, level := range []int{HuffmanOnly, NoCompression, BestSpeed, DefaultCompression, BestCompression} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Write in pieces and flush to get non-byte aligned blocks and sync flushes.
+		for i := 0; i < len(input); i += 100000 {
+			end := i + 100000
+			if end > len(input) {
+				end = len(input)
+			}
+			w.Write(input[i:end])
+			if i%300000 == 0 {
+				w.Flush()
+			}
+		}
+		w.Close()
+		compressed := buf.Bytes()
+
+		type point struct {
+			BlockInfo
+			window []byte
+		}
+		var points []point
+		r := NewReaderCallback(bytes.NewReader(compressed), func(b BlockInfo) {
+			points = append(points, point{BlockInfo: b, window: b.Window(nil)})
+		})
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Fatalf("level %d: output mismatch", level)
+		}
+		if len(points) < 2 {
+			t.Fatalf("level %d: got %d blocks", level, len(points))
+		}
+		var syncs int
+		for i, p := range points {
+			if p.Final != (i == len(points)-1) {
+				t.Fatalf("level %d: block %d of %d: final is %v", level, i, len(points), p.Final)
+			}
+			if p.AfterSync {
+				syncs++
+				if p.BitOffset%8 != 0 {
+					t.Fatalf("level %d: block %d after sync not byte aligned: %+v", level, i, p.BlockInfo)
+				}
+			}
+		}
+		if syncs == 0 {
+			t.Fatalf("level %d: no blocks after sync flush", level)
+		}
+		for i, p := range points {
+			if p.BitOffset < 0 || p.BitOffset > int64(len(compressed))*8 || p.Offset > int64(len(input)) {
+				t.Fatalf("level %d: invalid block %d: %+v", level, i, p.BlockInfo)
+			}
+			wantWin := input[:p.Offset]
+			if len(wantWin) > maxMatchOffset {
+				wantWin = wantWin[len(wantWin)-maxMatchOffset:]
+			}
+			if !bytes.Equal(p.window, wantWin) {
+				t.Fatalf("level %d: block %d window mismatch", level, i)
+			}
+			// Resume and check remaining offsets and output.
+			var resumed []BlockInfo
+			rr := NewReaderResume(bytes.NewReader(compressed[p.BitOffset/8:]), p.BitOffset, p.Offset, p.window, func(b BlockInfo) {
+				resumed = append(resumed, b)
+			})
+			got, err := ioutil.ReadAll(rr)
+			if err != nil {
+				t.Fatalf("level %d: block %d: %v", level, i, err)
+			}
+			if !bytes.Equal(got, input[p.Offset:]) {
+				t.Fatalf("level %d: block %d resumed output mismatch", level, i)
+			}
+			if len(resumed) != len(points)-i {
+				t.Fatalf("level %d: block %d got %d blocks after resume, want %d", level, i, len(resumed), len(points)-i)
+			}
+			for j, b := range resumed {
+				want := points[i+j]
+				if b.BitOffset != want.BitOffset || b.Offset != want.Offset || b.Type != want.Type || b.Final != want.Final {
+					t.Fatalf("level %d: block %d: got %+v, want %+v", level, i+j, b, want.BlockInfo)
+				}
+			}
+		}
+	}
+}
+
+func TestReaderCallbackBlockType(t *testing.T) {
+	input := bytes.Repeat([]byte("deflate block types "), 10000)
+	for _, bt := range []BlockType{BlockStored, BlockFixed, BlockDynamic} {
+		var buf bytes.Buffer
+		w, err := NewWriterOpts(&buf, WithBlockType(bt), WithLevel(BestCompression))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(input)
+		w.Close()
+		var types []BlockType
+		r := NewReaderCallback(&buf, func(b BlockInfo) {
+			if !b.Final {
+				types = append(types, b.Type)
+			}
+		})
+		if _, err := io.Copy(ioutil.Discard, r); err != nil {
+			t.Fatal(err)
+		}
+		if len(types) == 0 {
+			t.Fatal("no blocks")
+		}
+		for i, typ := range types {
+			if typ != bt {
+				t.Fatalf("block %d: got type %d, want %d", i, typ, bt)
+			}
+		}
+	}
+}
@@ -0,0 +1,486 @@
+package flate
+
+import (
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// optBlockSize is the number of bytes parsed as a unit by the optimal compressor.
+	// Each unit may be split into several blocks.
+	// One less than maxStoreBlockSize leaves room for the EOB token.
+	optBlockSize = maxStoreBlockSize - 1
+
+	optHashBits   = 16
+	optChainLimit = 8192
+
+	// optMaxBlocks is the maximum number of blocks a unit is split into.
+	optMaxBlocks = 15
+	// optMinSplit is the smallest number of tokens that will be considered for splitting.
+	optMinSplit = 256
+	// optSplitSamples is the number of split points tested in each search round.
+	optSplitSamples = 9
+
+	defaultOptimalIterations = 15
+)
+
+// optMatch is a match found at a position,
+// or the step used to reach a position when parsing.
+type optMatch struct {
+	length, dist uint16
+}
+
+// optimalState contains the state of the optimal parser.
+//
+// Input is processed in units of up to optBlockSize bytes.
+// For each unit all matches are found, and the unit is parsed
+// several times using the shortest path through the input,
+// where the cost of each literal and match is given by the Huffman
+// code lengths of the previous parse.
+// The parse is split into the blocks that give the smallest output,
+// and each block is parsed again using its own statistics.
+type optimalState struct {
+	iterations int
+	hist       int // Bytes of history at the start of the window.
+
+	// Hash chains.
+	head []int32
+	prev []int32
+
+	// Matches for position i of the unit are matches[matchIdx[i]:matchIdx[i+1]],
+	// ordered by increasing length.
+	matches  []optMatch
+	matchIdx []int32
+
+	// Shortest path.
+	cost []uint32
+	from []optMatch
+
+	// Cost model, in bits.
+	litCost [literalCount]uint32
+	offCost [offsetCodeCount]uint32
+	lenCost [maxMatchLength + 1]uint32
+	litEnc  *huffmanEncoder
+	offEnc  *huffmanEncoder
+
+	// Size estimation.
+	est *huffmanBitWriter
+	tok tokens
+
+	unit, cur, best []token
+	pos             []int
+	splits          []int
+}
+
+func newOptimalState(iterations int) *optimalState {
+	if iterations <= 0 {
+		iterations = defaultOptimalIterations
+	}
+	return &optimalState{
+		iterations: iterations,
+		head:       make([]int32, 1<<optHashBits),
+		prev:       make([]int32, windowSize+optBlockSize),
+		matchIdx:   make([]int32, 0, optBlockSize+1),
+		cost:       make([]uint32, optBlockSize+1),
+		from:       make([]optMatch, optBlockSize+1),
+		litEnc:     newHuffmanEncoder(literalCount),
+		offEnc:     newHuffmanEncoder(offsetCodeCount),
+		est:        newHuffmanBitWriter(nil),
+	}
+}
+
+// NewWriterOptimal returns a new Writer that produces the smallest output
+// this package can produce, at a very high CPU cost.
+//
+// Each block of input is parsed several times, where every parse uses a
+// cost model based on the Huffman codes resulting from the previous parse.
+// The result is split into the blocks giving the smallest output
+// and each block is optimized again.
+// This is similar to the approach used by Zopfli.
+//
+// iterations is the number of parses done for each block.
+// If iterations <= 0, 15 iterations are used.
+// Compression is typically more than 100 times slower than BestCompression,
+// so this is intended for content that is compressed once and decompressed many times.
+// Decompression speed is unaffected.
+func NewWriterOptimal(w io.Writer, iterations int) *Writer {
+	var dw Writer
+	dw.d.initOptimal(w, iterations)
+	return &dw
+}
+
+func (d *compressor) initOptimal(w io.Writer, iterations int) {
+	d.w = newHuffmanBitWriter(w)
+	d.level = BestCompression
+	d.maxDist = windowSize
+	d.opt = newOptimalState(iterations)
+	d.window = make([]byte, windowSize+optBlockSize)
+	d.fill = (*compressor).fillOptimal
+	d.step = (*compressor).storeOptimal
+}
+
+// fillOptimal adds input until a unit is full.
+func (d *compressor) fillOptimal(b []byte) int {
+	n := copy(d.window[d.windowEnd:d.opt.hist+optBlockSize], b)
+	d.windowEnd += n
+	return n
+}
+
+// storeOptimal will compress and store the current unit,
+// if it is full or we are flushing.
+// Any error that occurred will be in d.err
+func (d *compressor) storeOptimal() {
+	o := d.opt
+	if d.windowEnd-o.hist < optBlockSize && !d.sync || d.windowEnd == o.hist {
+		return
+	}
+	buf := d.window[:d.windowEnd]
+	in := buf[o.hist:]
+	o.findMatches(buf, o.hist)
+	o.unit = append(o.unit[:0], o.parse(in, 0, len(in), nil)...)
+
+	for _, blk := range o.split(o.unit, in) {
+		s, e := blk[0], blk[1]
+		toks := o.parse(in, s, e, o.unit[blk[2]:blk[3]])
+		d.tokens.Reset()
+		for _, t := range toks {
+			if t < matchType {
+				d.tokens.AddLiteral(t.literal())
+			} else {
+				d.tokens.AddMatch(uint32(t.length()), t.offset())
+			}
+		}
+		d.w.writeBlock(&d.tokens, false, in[s:e])
+		if d.err = d.w.err; d.err != nil {
+			return
+		}
+	}
+	d.tokens.Reset()
+
+	// Keep the last window as history.
+	keep := d.windowEnd
+	if keep > windowSize {
+		keep = windowSize
+	}
+	copy(d.window, d.window[d.windowEnd-keep:d.windowEnd])
+	d.windowEnd = keep
+	o.hist = keep
+}
+
+// fillWindow adds the dictionary as history.
+func (o *optimalState) fillWindow(d *compressor, b []byte) {
+	if len(b) > windowSize {
+		b = b[len(b)-windowSize:]
+	}
+	d.windowEnd = copy(d.window, b)
+	o.hist = d.windowEnd
+}
+
+func (o *optimalState) reset() {
+	o.hist = 0
+}
+
+// hash3 returns the hash of the first 3 bytes of b.
+func hash3(b []byte) uint32 {
+	u := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+	return (u * prime3bytes) >> (32 - optHashBits)
+}
+
+// findMatches finds matches for all positions of buf after hist.
+// For each length the match with the smallest distance is kept.
+func (o *optimalState) findMatches(buf []byte, hist int) {
+	for i := range o.head {
+		o.head[i] = -1
+	}
+	o.matches = o.matches[:0]
+	o.matchIdx = o.matchIdx[:0]
+	prev := o.prev[:len(buf)]
+	for p := range buf {
+		if p >= hist {
+			o.matchIdx = append(o.matchIdx, int32(len(o.matches)))
+		}
+		if p+baseMatchLength > len(buf) {
+			continue
+		}
+		h := hash3(buf[p:])
+		if p >= hist {
+			o.matchesAt(buf, p, o.head[h])
+		}
+		prev[p] = o.head[h]
+		o.head[h] = int32(p)
+	}
+	o.matchIdx = append(o.matchIdx, int32(len(o.matches)))
+}
+
+// matchesAt adds matches at position p, starting with candidate j.
+func (o *optimalState) matchesAt(buf []byte, p int, j int32) {
+	limit := len(buf) - p
+	if limit > maxMatchLength {
+		limit = maxMatchLength
+	}
+	a := buf[p : p+limit]
+	best := baseMatchLength - 1
+	for tries := optChainLimit; j >= 0 && tries > 0; tries-- {
+		dist := p - int(j)
+		if dist > maxMatchOffset {
+			break
+		}
+		if buf[int(j)+best] == a[best] {
+			if n := matchLen(a, buf[j:]); n > best {
+				o.matches = append(o.matches, optMatch{length: uint16(n), dist: uint16(dist)})
+				best = n
+				if n == limit {
+					break
+				}
+			}
+		}
+		j = o.prev[j]
+	}
+}
+
+// parse returns the cheapest parse of in[s:e] found in o.iterations iterations.
+// If init is supplied it is used for the initial cost model,
+// and returned if no better parse is found.
+// Otherwise the fixed Huffman codes are used for the initial cost model.
+func (o *optimalState) parse(in []byte, s, e int, init []token) []token {
+	best := init
+	bestBits := math.MaxInt32
+	if init != nil {
+		bestBits = o.blockBits(init, in[s:e])
+		o.setCosts(init)
+	} else {
+		o.setFixedCosts()
+	}
+	lastBits := -1
+	for i := 0; i < o.iterations; i++ {
+		o.cur = o.shortestPath(o.cur[:0], in, s, e)
+		n := o.blockBits(o.cur, in[s:e])
+		if n < bestBits {
+			bestBits = n
+			o.best = append(o.best[:0], o.cur...)
+			best = o.best
+		}
+		if n == lastBits {
+			// Converged.
+			break
+		}
+		lastBits = n
+		o.setCosts(o.cur)
+	}
+	return best
+}
+
+// shortestPath appends the cheapest parse of in[s:e] using the current cost model to dst.
+func (o *optimalState) shortestPath(dst []token, in []byte, s, e int) []token {
+	n := e - s
+	cost := o.cost[:n+1]
+	from := o.from[:n+1]
+	cost[0] = 0
+	for i := 1; i <= n; i++ {
+		cost[i] = math.MaxUint32
+	}
+	for i := 0; i < n; i++ {
+		c := cost[i]
+		p := s + i
+		if lc := c + o.litCost[in[p]]; lc < cost[i+1] {
+			cost[i+1] = lc
+			from[i+1] = optMatch{length: 1}
+		}
+		maxLen := n - i
+		prevLen := baseMatchLength - 1
+		for _, m := range o.matches[o.matchIdx[p]:o.matchIdx[p+1]] {
+			if prevLen >= maxLen {
+				break
+			}
+			end := int(m.length)
+			if end > maxLen {
+				end = maxLen
+			}
+			oc := offsetCode(uint32(m.dist) - baseMatchOffset)
+			dc := c + o.offCost[oc] + uint32(offsetExtraBits[oc])
+			for l := prevLen + 1; l <= end; l++ {
+				if lc := dc + o.lenCost[l]; lc < cost[i+l] {
+					cost[i+l] = lc
+					from[i+l] = optMatch{length: uint16(l), dist: m.dist}
+				}
+			}
+			prevLen = int(m.length)
+		}
+	}
+
+	// Trace back the path and emit tokens in order.
+	start := len(dst)
+	for i := n; i > 0; {
+		f := from[i]
+		i -= int(f.length)
+		if f.length == 1 {
+			dst = append(dst, token(in[s+i]))
+		} else {
+			dst = append(dst, token(matchType|uint32(f.length-baseMatchLength)<<lengthShift|uint32(f.dist-baseMatchOffset)))
+		}
+	}
+	for i, j := start, len(dst)-1; i < j; i, j = i+1, j-1 {
+		dst[i], dst[j] = dst[j], dst[i]
+	}
+	return dst
+}
+
+// setFixedCosts sets the cost model to the fixed Huffman codes.
+func (o *optimalState) setFixedCosts() {
+	for i, c := range fixedLiteralEncoding.codes[:literalCount] {
+		o.litCost[i] = uint32(c.len)
+	}
+	for i, c := range fixedOffsetEncoding.codes[:offsetCodeCount] {
+		o.offCost[i] = uint32(c.len)
+	}
+	o.setLengthCosts()
+}
+
+// setCosts sets the cost model to the Huffman codes generated for toks.
+// Unused symbols are given a cost slightly above the longest
+// code a symbol used once would get.
+func (o *optimalState) setCosts(toks []token) {
+	var litFreq [literalCount]uint16
+	var offFreq [offsetCodeCount]uint16
+	for _, t := range toks {
+		if t < matchType {
+			litFreq[t.literal()]++
+			continue
+		}
+		litFreq[lengthCodesStart+lengthCode(t.length())]++
+		offFreq[offsetCode(t.offset())]++
+	}
+	litFreq[endBlockMarker] = 1
+	o.litEnc.generate(litFreq[:], 15)
+	o.offEnc.generate(offFreq[:], 15)
+
+	unused := uint32(bits.Len(uint(len(toks)))) + 1
+	if unused > 15 {
+		unused = 15
+	}
+	for i, f := range litFreq[:] {
+		if f == 0 {
+			o.litCost[i] = unused
+		} else {
+			o.litCost[i] = uint32(o.litEnc.codes[i].len)
+		}
+	}
+	for i, f := range offFreq[:] {
+		if f == 0 {
+			o.offCost[i] = unused
+		} else {
+			o.offCost[i] = uint32(o.offEnc.codes[i].len)
+		}
+	}
+	o.setLengthCosts()
+}
+
+// setLengthCosts calculates the cost of each match length from the literal costs.
+func (o *optimalState) setLengthCosts() {
+	for l := baseMatchLength; l <= maxMatchLength; l++ {
+		lc := lengthCode(uint8(l - baseMatchLength))
+		o.lenCost[l] = o.litCost[lengthCodesStart+lc] + uint32(lengthExtraBits[lc])
+	}
+}
+
+// blockBits returns the size in bits of toks written as a single block,
+// using the smallest of fixed, dynamic and stored encoding.
+// input must be the input represented by toks.
+func (o *optimalState) blockBits(toks []token, input []byte) int {
+	t := &o.tok
+	t.Reset()
+	for _, tok := range toks {
+		if tok < matchType {
+			t.AddLiteral(tok.literal())
+		} else {
+			t.AddMatch(uint32(tok.length()), tok.offset())
+		}
+	}
+	t.AddEOB()
+	w := o.est
+	numLiterals, numOffsets := w.indexTokens(t, false)
+	w.generate(t)
+	extraBits := w.extraBitSize()
+	size := w.fixedSize(extraBits)
+	w.generateCodegen(numLiterals, numOffsets, w.literalEncoding, w.offsetEncoding)
+	w.codegenEncoding.generate(w.codegenFreq[:], 7)
+	if dyn, _ := w.dynamicSize(w.literalEncoding, w.offsetEncoding, extraBits); dyn < size {
+		size = dyn
+	}
+	if stored, ok := w.storedSize(input); ok && stored < size {
+		size = stored
+	}
+	return size
+}
+
+// split returns the blocks toks should be written as.
+// Each block is returned as the input range followed by the token range.
+func (o *optimalState) split(toks []token, in []byte) [][4]int {
+	o.pos = o.pos[:0]
+	off := 0
+	for _, t := range toks {
+		o.pos = append(o.pos, off)
+		if t < matchType {
+			off++
+		} else {
+			off += int(t.length()) + baseMatchLength
+		}
+	}
+	o.pos = append(o.pos, off)
+	o.splits = append(o.splits[:0], 0, len(toks))
+	o.splitRange(toks, in, 0, len(toks))
+	sort.Ints(o.splits)
+
+	blocks := make([][4]int, 0, len(o.splits)-1)
+	for i := 1; i < len(o.splits); i++ {
+		a, b := o.splits[i-1], o.splits[i]
+		blocks = append(blocks, [4]int{o.pos[a], o.pos[b], a, b})
+	}
+	return blocks
+}
+
+// splitRange searches for the point where splitting toks[a:b] into two blocks
+// gives the smallest combined size.
+// If splitting reduces the size the point is added and both halves are split further.
+func (o *optimalState) splitRange(toks []token, in []byte, a, b int) {
+	if len(o.splits) > optMaxBlocks || b-a < optMinSplit {
+		return
+	}
+	size := func(a, b int) int {
+		return o.blockBits(toks[a:b], in[o.pos[a]:o.pos[b]])
+	}
+	best, bestSize := -1, size(a, b)
+	lo, hi := a+1, b
+	for {
+		step := (hi - lo) / (optSplitSamples + 1)
+		if step < 1 {
+			step = 1
+		}
+		found := false
+		for p := lo + step; p < hi; p += step {
+			if n := size(a, p) + size(p, b); n < bestSize {
+				best, bestSize = p, n
+				found = true
+			}
+		}
+		if !found || step == 1 {
+			break
+		}
+		// Narrow the search around the best point.
+		lo, hi = best-step, best+step
+		if lo < a+1 {
+			lo = a + 1
+		}
+		if hi > b {
+			hi = b
+		}
+	}
+	if best < 0 {
+		return
+	}
+	o.splits = append(o.splits, best)
+	o.splitRange(toks, in, a, best)
+	o.splitRange(toks, in, best, b)
+}
@@ -0,0 +1,212 @@
+package flate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// BlockType selects the type of deflate blocks written by a Writer.
+type BlockType int
+
+const (
+	// BlockAuto selects the smallest block type for each block.
+	// This is the default.
+	BlockAuto BlockType = iota
+
+	// BlockStored writes all data as stored blocks without compression.
+	// This is the same as using NoCompression.
+	BlockStored
+
+	// BlockFixed writes all blocks with the fixed Huffman codes.
+	BlockFixed
+
+	// BlockDynamic writes all blocks with dynamic Huffman codes.
+	BlockDynamic
+)
+
+// Strategy selects how matches are found, similar to the zlib strategies.
+type Strategy int
+
+const (
+	// StrategyDefault uses the matching of the compression level.
+	StrategyDefault Strategy = iota
+
+	// StrategyFiltered only uses matches of 6 bytes or more.
+	// This is similar to Z_FILTERED in zlib and is intended for data
+	// produced by a filter or predictor with small values and a somewhat
+	// random distribution, where short matches are better coded as literals.
+	StrategyFiltered
+
+	// StrategyRLE only uses matches with a distance of one,
+	// which will compress runs of the same byte.
+	// This is similar to Z_RLE in zlib and is intended for PNG image data.
+	StrategyRLE
+
+	// StrategyHuffmanOnly disables matching.
+	// This is the same as using the HuffmanOnly level.
+	StrategyHuffmanOnly
+)
+
+const (
+	// MinWindowBits is the smallest window size that can be selected, in bits.
+	MinWindowBits = 9
+	// MaxWindowBits is the largest window size that can be selected, in bits.
+	MaxWindowBits = logWindowSize
+
+	// filteredMinMatch is the shortest match used by StrategyFiltered.
+	filteredMinMatch = 6
+)
+
+// Option is an option for creating a Writer with NewWriterOpts.
+type Option func(*writerOptions) error
+
+// writerOptions retains accumulated state of multiple options.
+type writerOptions struct {
+	level       int
+	windowBits  int
+	blockTokens int
+	blockType   BlockType
+	strategy    Strategy
+	tables      *Tables
+}
+
+// WithLevel sets the compression level, as described in NewWriter.
+// The default is DefaultCompression.
+func WithLevel(level int) Option {
+	return func(o *writerOptions) error {
+		if level < HuffmanOnly || level > BestCompression {
+			return fmt.Errorf("flate: invalid compression level %d: want value in range [-2, 9]", level)
+		}
+		o.level = level
+		return nil
+	}
+}
+
+// WithWindowBits limits the distance of matches to 1<<bits bytes.
+// This allows the output to be decompressed by decoders with a smaller window.
+// bits must be between MinWindowBits and MaxWindowBits. The default is MaxWindowBits.
+//
+// When a window smaller than the default is selected,
+// levels 1 to 6 use lazy matching and will be slower.
+func WithWindowBits(bits int) Option {
+	return func(o *writerOptions) error {
+		if bits < MinWindowBits || bits > MaxWindowBits {
+			return fmt.Errorf("flate: invalid window bits %d: want value in range [%d, %d]", bits, MinWindowBits, MaxWindowBits)
+		}
+		o.windowBits = bits
+		return nil
+	}
+}
+
+// WithBlockTokens sets the maximum number of tokens in each block.
+// Each token is either a literal or a match.
+// For levels 7 to 9 the default is 16384, for other levels
+// blocks are up to 65535 input bytes.
+// n must be between 256 and 65534.
+func WithBlockTokens(n int) Option {
+	return func(o *writerOptions) error {
+		if n < 256 || n >= maxStoreBlockSize {
+			return fmt.Errorf("flate: invalid block tokens %d: want value in range [256, %d]", n, maxStoreBlockSize-1)
+		}
+		o.blockTokens = n
+		return nil
+	}
+}
+
+// WithBlockType forces the type of the blocks written.
+// Empty blocks written by Flush and Close are not affected.
+// The default is BlockAuto.
+func WithBlockType(t BlockType) Option {
+	return func(o *writerOptions) error {
+		if t < BlockAuto || t > BlockDynamic {
+			return errors.New("flate: unknown block type")
+		}
+		o.blockType = t
+		return nil
+	}
+}
+
+// WithStrategy sets the matching strategy.
+// StrategyFiltered makes levels 1 to 6 use lazy matching.
+// The default is StrategyDefault.
+func WithStrategy(s Strategy) Option {
+	return func(o *writerOptions) error {
+		if s < StrategyDefault || s > StrategyHuffmanOnly {
+			return errors.New("flate: unknown strategy")
+		}
+		o.strategy = s
+		return nil
+	}
+}
+
+// WithTables encodes all blocks with the Huffman tables in t,
+// except blocks that are smaller stored.
+// This cannot be combined with BlockStored or BlockFixed
+// and has no effect with NoCompression.
+func WithTables(t *Tables) Option {
+	return func(o *writerOptions) error {
+		if t == nil {
+			return errors.New("flate: nil Tables")
+		}
+		o.tables = t
+		return nil
+	}
+}
+
+// NewWriterOpts returns a new Writer with the supplied options.
+// With no options the Writer is the same as one returned by
+// NewWriter with DefaultCompression.
+func NewWriterOpts(w io.Writer, opts ...Option) (*Writer, error) {
+	o := writerOptions{
+		level:      DefaultCompression,
+		windowBits: MaxWindowBits,
+	}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if o.tables != nil && (o.blockType == BlockStored || o.blockType == BlockFixed) {
+		return nil, errors.New("flate: tables cannot be used with stored or fixed blocks")
+	}
+	var dw Writer
+	if err := dw.d.initOpts(w, o); err != nil {
+		return nil, err
+	}
+	return &dw, nil
+}
+
+// WindowBits returns the window size of the Writer in bits.
+// Matches are never more than 1<<WindowBits() bytes back.
+func (w *Writer) WindowBits() int {
+	return bits.Len(uint(w.d.maxDist)) - 1
+}
+
+// rleEnc is a fastEnc that only emits matches with a distance of one.
+type rleEnc struct{}
+
+func (e *rleEnc) Encode(dst *tokens, src []byte) {
+	nextEmit := 0
+	for i := 1; i < len(src); {
+		n := matchLen(src[i:], src[i-1:])
+		if n < baseMatchLength {
+			i++
+			continue
+		}
+		emitLiteral(dst, src[nextEmit:i])
+		dst.AddMatchLong(int32(n), 0)
+		i += n
+		nextEmit = i
+	}
+	if nextEmit < len(src) {
+		// If nothing was added, don't encode literals.
+		if dst.n == 0 {
+			return
+		}
+		emitLiteral(dst, src[nextEmit:])
+	}
+}
+
+func (e *rleEnc) Reset() {}
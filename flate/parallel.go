@@ -0,0 +1,303 @@
+package flate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const (
+	// defaultParallelBlockSize is the block size used by NewParallelWriter
+	// when no block size is given.
+	defaultParallelBlockSize = 1 << 20
+)
+
+var (
+	errWriterClosed = errors.New("flate: writer is closed")
+	errNoWriter     = errors.New("flate: no writer set")
+)
+
+// WriteResetter is implemented by *Writer and *ParallelWriter.
+type WriteResetter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// ParallelWriter compresses data written to it on multiple goroutines
+// and writes a single DEFLATE stream to an underlying writer.
+//
+// Input is split into blocks that are compressed independently.
+// Each block uses the preceding 32KB of input as a dictionary,
+// so matches can span block boundaries.
+// Blocks are ended with a sync flush and written in order,
+// so the output can be decompressed by any DEFLATE decoder.
+type ParallelWriter struct {
+	level       int
+	blockSize   int
+	concurrency int
+	dict        []byte
+
+	errMu    sync.Mutex
+	errState error
+
+	// buf contains history for the current block
+	// followed by the uncompressed block data.
+	buf     []byte
+	histLen int
+
+	output   chan chan *bytes.Buffer
+	buffers  sync.Pool // Input buffers.
+	outBufs  sync.Pool // Compressed output.
+	writers  sync.Pool // Compressors.
+	writerWg sync.WaitGroup
+}
+
+// NewParallelWriter returns a new ParallelWriter compressing data at the given level.
+// Levels are the same as for NewWriter.
+//
+// Input is compressed in blocks of blockSize bytes, with up to concurrency
+// blocks being compressed at once before Write will block.
+// If blockSize is <= 0 a block size of 1MB is used.
+// If concurrency is <= 0 runtime.GOMAXPROCS(0) is used.
+//
+// Compression will be slightly worse than NewWriter, since each block
+// boundary adds a sync marker and matches cannot reach further back
+// than the start of the previous 32KB.
+//
+// It is the caller's responsibility to call Close on the writer when done.
+func NewParallelWriter(w io.Writer, level, blockSize, concurrency int) (*ParallelWriter, error) {
+	if level < HuffmanOnly || level > BestCompression {
+		return nil, fmt.Errorf("flate: invalid compression level %d: want value in range [-2, 9]", level)
+	}
+	if blockSize <= 0 {
+		blockSize = defaultParallelBlockSize
+	}
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	pw := ParallelWriter{
+		level:       level,
+		blockSize:   blockSize,
+		concurrency: concurrency,
+	}
+	pw.buffers.New = func() interface{} {
+		return make([]byte, 0, windowSize+blockSize)
+	}
+	pw.outBufs.New = func() interface{} {
+		return &bytes.Buffer{}
+	}
+	pw.writers.New = func() interface{} {
+		fw, err := NewWriter(nil, level)
+		if err != nil {
+			panic(fmt.Sprintf("flate: unexpected error %v", err))
+		}
+		return fw
+	}
+	pw.Reset(w)
+	return &pw, nil
+}
+
+// err returns the previously set error.
+// If no error has been set it is set to err if not nil.
+func (w *ParallelWriter) err(err error) error {
+	w.errMu.Lock()
+	errSet := w.errState
+	if errSet == nil && err != nil {
+		w.errState = err
+		errSet = err
+	}
+	w.errMu.Unlock()
+	return errSet
+}
+
+// Reset discards the writer's state and makes it equivalent to
+// the result of NewParallelWriter called with dst and w's parameters.
+// Any dictionary set with ResetDict is kept.
+// Any data not flushed is discarded.
+func (w *ParallelWriter) Reset(dst io.Writer) {
+	// Stop previous writer, if any.
+	if w.output != nil {
+		close(w.output)
+		w.writerWg.Wait()
+		w.output = nil
+	}
+	w.errState = nil
+	hist := w.dict
+	if len(hist) > windowSize {
+		hist = hist[len(hist)-windowSize:]
+	}
+	if w.buf == nil {
+		w.buf = w.buffers.Get().([]byte)
+	}
+	w.buf = append(w.buf[:0], hist...)
+	w.histLen = len(hist)
+
+	// If we didn't get a writer, stop here.
+	if dst == nil {
+		return
+	}
+
+	toWrite := make(chan chan *bytes.Buffer, w.concurrency)
+	w.output = toWrite
+	w.writerWg.Add(1)
+
+	// Start a writer goroutine that will write all output in order.
+	go func() {
+		defer w.writerWg.Done()
+
+		// Get a queued write.
+		for write := range toWrite {
+			// Wait for the data to be available.
+			out := <-write
+			if out != nil {
+				if w.err(nil) == nil {
+					n, err := dst.Write(out.Bytes())
+					if err == nil && n != out.Len() {
+						err = io.ErrShortWrite
+					}
+					_ = w.err(err)
+				}
+				w.outBufs.Put(out)
+			}
+			// close the incoming write request.
+			// This can be used for synchronizing flushes.
+			close(write)
+		}
+	}()
+}
+
+// ResetDict discards the writer's state and makes it equivalent to
+// the result of NewParallelWriter called with dst and w's parameters,
+// but sets a specific dictionary.
+// The compressed data can only be decompressed by a Reader
+// initialized with the same dictionary.
+func (w *ParallelWriter) ResetDict(dst io.Writer, dict []byte) {
+	w.dict = dict
+	w.Reset(dst)
+}
+
+// Write writes data to w, which will eventually write the
+// compressed form of data to its underlying writer.
+func (w *ParallelWriter) Write(p []byte) (n int, err error) {
+	if err := w.err(nil); err != nil {
+		return 0, err
+	}
+	if w.output == nil {
+		return 0, w.err(errNoWriter)
+	}
+	for len(p) > 0 {
+		room := w.histLen + w.blockSize - len(w.buf)
+		if room > len(p) {
+			room = len(p)
+		}
+		w.buf = append(w.buf, p[:room]...)
+		n += room
+		p = p[room:]
+		if len(w.buf) == w.histLen+w.blockSize {
+			w.queue(false)
+			if err := w.err(nil); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// queue will compress the current block on a separate goroutine
+// and queue the output.
+// If final is false the block is ended with a sync flush and a new block
+// is started with the last 32KB of the current block as history.
+// If final is true the block ends the stream.
+func (w *ParallelWriter) queue(final bool) {
+	in, histLen := w.buf, w.histLen
+	if final {
+		w.buf, w.histLen = nil, 0
+	} else {
+		hist := in
+		if len(hist) > windowSize {
+			hist = hist[len(hist)-windowSize:]
+		}
+		w.buf = append(w.buffers.Get().([]byte)[:0], hist...)
+		w.histLen = len(hist)
+	}
+
+	output := make(chan *bytes.Buffer)
+	// Queue output now, so we keep order.
+	w.output <- output
+	go func() {
+		out := w.outBufs.Get().(*bytes.Buffer)
+		out.Reset()
+		fw := w.writers.Get().(*Writer)
+		fw.ResetDict(out, in[:histLen])
+		// Writing to a bytes.Buffer cannot fail.
+		fw.Write(in[histLen:])
+		if final {
+			fw.Close()
+		} else {
+			fw.Flush()
+		}
+		w.writers.Put(fw)
+		w.buffers.Put(in[:0])
+		output <- out
+	}()
+}
+
+// Flush compresses any pending data and writes all compressed
+// data to the underlying writer.
+// Flush does not return until the data has been written.
+// Calling Flush when there is no pending data still causes the writer
+// to emit a sync marker of at least 4 bytes.
+// If the underlying writer returns an error, Flush returns that error.
+//
+// In the terminology of the zlib library, Flush is equivalent to Z_SYNC_FLUSH.
+func (w *ParallelWriter) Flush() error {
+	if err := w.err(nil); err != nil {
+		return err
+	}
+	if w.output == nil {
+		return w.err(errNoWriter)
+	}
+	w.queue(false)
+	return w.wait()
+}
+
+// wait blocks until all queued output has been written.
+func (w *ParallelWriter) wait() error {
+	// Send empty buffer
+	res := make(chan *bytes.Buffer)
+	w.output <- res
+	// Block until this has been picked up.
+	res <- nil
+	// When it is closed, we have flushed.
+	<-res
+	return w.err(nil)
+}
+
+// Close compresses any pending data, ends the stream
+// and waits for all output to be written.
+// Close does not close the underlying writer.
+// Calling Close multiple times is ok.
+func (w *ParallelWriter) Close() error {
+	err := w.err(nil)
+	if err == errWriterClosed {
+		return nil
+	}
+	if err == nil && w.output == nil {
+		err = w.err(errNoWriter)
+	}
+	if err == nil {
+		w.queue(true)
+		err = w.wait()
+	}
+	if w.output != nil {
+		close(w.output)
+		w.writerWg.Wait()
+		w.output = nil
+	}
+	_ = w.err(errWriterClosed)
+	return err
+}
@@ -65,12 +65,20 @@ var bitWriterPool = sync.Pool{
 // Longer dictionaries will be truncated and will still produce valid output.
 // Sending nil dictionary is perfectly fine.
 func StatelessDeflate(out io.Writer, in []byte, eof bool, dict []byte) error {
+	return statelessDeflate(out, in, eof, dict, nil)
+}
+
+// statelessDeflate implements StatelessDeflate,
+// encoding blocks with t if it is non-nil.
+func statelessDeflate(out io.Writer, in []byte, eof bool, dict []byte, t *Tables) error {
 	var dst tokens
 	bw := bitWriterPool.Get().(*huffmanBitWriter)
 	bw.reset(out)
+	bw.tables = t
 	defer func() {
 		// don't keep a reference to our output
 		bw.reset(nil)
+		bw.tables = nil
 		bitWriterPool.Put(bw)
 	}()
 	if eof && len(in) == 0 {
@@ -105,7 +113,9 @@ func StatelessDeflate(out io.Writer, in []byte, eof bool, dict []byte) error {
 		statelessEnc(&dst, todo, int16(len(dict)))
 		isEof := eof && len(in) == 0
 
-		if dst.n == 0 {
+		if t != nil && bw.writeBlockTables(&dst, isEof, uncompressed) {
+			// Encoded with tables.
+		} else if dst.n == 0 {
 			bw.writeStoredHeader(len(uncompressed), isEof)
 			if bw.err != nil {
 				return bw.err
@@ -0,0 +1,148 @@
+package flate
+
+import (
+	"errors"
+	"io"
+)
+
+// Tables contains dynamic Huffman tables trained on sample data.
+//
+// Blocks encoded with Tables all use the same Huffman tables,
+// so no histogram is made and no tables are generated per block,
+// and the block header is written from a cached encoding.
+// Each block uses the smallest of the tables, the fixed Huffman codes
+// and a stored block, which is determined from the token counts.
+// This is faster than regular dynamic blocks for small payloads
+// that are similar to the samples.
+//
+// All match lengths and offsets have codes, but only literals found in
+// the samples do. Blocks with other literals are encoded as if no tables
+// were given.
+// Output can be decompressed by any deflate decoder.
+// A Tables may be used by several writers concurrently.
+type Tables struct {
+	lit *huffmanEncoder
+	off *huffmanEncoder
+
+	// The number of literal/length and offset codes in the header.
+	numLiterals, numOffsets int
+}
+
+// TrainTables returns Huffman tables trained on the supplied samples.
+// The samples are compressed as with StatelessDeflate, and the
+// frequencies of the symbols are used to generate the tables.
+// The samples should be representative of the data that will be compressed.
+func TrainTables(samples [][]byte) (*Tables, error) {
+	var lit [literalCount]uint64
+	var off [offsetCodeCount]uint64
+	var dst tokens
+	var total int
+	for _, in := range samples {
+		for len(in) > 0 {
+			todo := in
+			if len(todo) > maxStatelessBlock {
+				todo = todo[:maxStatelessBlock]
+			}
+			in = in[len(todo):]
+			total += len(todo)
+
+			dst.Reset()
+			statelessEnc(&dst, todo, 0)
+			if dst.n == 0 {
+				for _, b := range todo {
+					lit[b]++
+				}
+			} else {
+				for i, n := range dst.litHist[:] {
+					lit[i] += uint64(n)
+				}
+				for i, n := range dst.extraHist[:literalCount-256] {
+					lit[256+i] += uint64(n)
+				}
+				for i, n := range dst.offHist[:offsetCodeCount] {
+					off[i] += uint64(n)
+				}
+			}
+			lit[endBlockMarker]++
+		}
+	}
+	if total == 0 {
+		return nil, errors.New("flate: no sample data")
+	}
+	// Matches found by the compression levels may use any length and offset.
+	for i := endBlockMarker + 1; i < literalCount; i++ {
+		if lit[i] == 0 {
+			lit[i] = 1
+		}
+	}
+	for i := range off[:] {
+		if off[i] == 0 {
+			off[i] = 1
+		}
+	}
+	t := &Tables{
+		lit: newHuffmanEncoder(literalCount),
+		off: newHuffmanEncoder(offsetCodeCount),
+	}
+	var freq [literalCount]uint16
+	t.lit.generate(scaleFreq(freq[:], lit[:]), 15)
+	t.off.generate(scaleFreq(freq[:offsetCodeCount], off[:]), 15)
+	for t.numLiterals = literalCount; t.lit.codes[t.numLiterals-1].len == 0; t.numLiterals-- {
+	}
+	for t.numOffsets = offsetCodeCount; t.off.codes[t.numOffsets-1].len == 0; t.numOffsets-- {
+	}
+	return t, nil
+}
+
+// canEncode returns whether all symbols with non-zero frequencies
+// in the literal, length and offset histograms have a code.
+// lengths is indexed from the end of block marker.
+func (t *Tables) canEncode(lits, lengths, offs []uint16) bool {
+	for i, n := range lits {
+		if n != 0 && t.lit.codes[i].len == 0 {
+			return false
+		}
+	}
+	for i, n := range lengths {
+		if n != 0 && t.lit.codes[endBlockMarker+i].len == 0 {
+			return false
+		}
+	}
+	for i, n := range offs {
+		if n != 0 && t.off.codes[i].len == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// scaleFreq scales the frequencies in src to fit dst.
+// Symbols that are present keep a frequency of at least 1.
+func scaleFreq(dst []uint16, src []uint64) []uint16 {
+	var max uint64
+	for _, n := range src {
+		if n > max {
+			max = n
+		}
+	}
+	var shift uint
+	for max>>shift >= 0xffff {
+		shift++
+	}
+	for i, n := range src {
+		dst[i] = uint16(n >> shift)
+		if n != 0 && dst[i] == 0 {
+			dst[i] = 1
+		}
+	}
+	return dst
+}
+
+// StatelessDeflateTables is like StatelessDeflate,
+// but encodes blocks with the Huffman tables in t.
+func StatelessDeflateTables(out io.Writer, in []byte, eof bool, dict []byte, t *Tables) error {
+	if t == nil {
+		return errors.New("flate: nil Tables")
+	}
+	return statelessDeflate(out, in, eof, dict, t)
+}
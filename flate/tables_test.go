@@ -0,0 +1,140 @@
+package flate
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// tablesPayload returns a small JSON document similar to others with other seeds.
+func tablesPayload(seed int) []byte {
+	rng := rand.New(rand.NewSource(int64(seed)))
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `{"id":%d,"name":"user-%d","email":"user%d@example.com","active":%v,"roles":[`, seed, rng.Intn(10000), rng.Intn(10000), rng.Intn(2) == 0)
+	for i := 0; i < 1+rng.Intn(4); i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `"role-%d"`, rng.Intn(20))
+	}
+	fmt.Fprintf(&b, `],"score":%.3f,"created":"2020-%02d-%02dT%02d:%02d:00Z"}`, rng.Float64()*100, 1+rng.Intn(12), 1+rng.Intn(28), rng.Intn(24), rng.Intn(60))
+	return b.Bytes()
+}
+
+func TestTables(t *testing.T) {
+	if _, err := TrainTables(nil); err == nil {
+		t.Fatal("want error with no samples")
+	}
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		samples = append(samples, tablesPayload(i))
+	}
+	tables, err := TrainTables(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := [][]byte{nil, []byte("a"), random, bytes.Repeat([]byte("abc"), 50000)}
+	var tablesSize, plainSize int
+	for i := 1000; i < 1100; i++ {
+		inputs = append(inputs, tablesPayload(i))
+	}
+	for i, in := range inputs {
+		var buf bytes.Buffer
+		if err := StatelessDeflateTables(&buf, in, true, nil, tables); err != nil {
+			t.Fatal(err)
+		}
+		size := buf.Len()
+		got, err := ioutil.ReadAll(NewReader(&buf))
+		if err != nil {
+			t.Fatalf("input %d: %v", i, err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Fatalf("input %d: output mismatch", i)
+		}
+		if i >= 4 {
+			buf.Reset()
+			StatelessDeflate(&buf, in, true, nil)
+			tablesSize += size
+			plainSize += buf.Len()
+		}
+	}
+	t.Logf("payloads: %d bytes with tables, %d bytes without", tablesSize, plainSize)
+	// The trained header is larger than one made for each payload,
+	// but should not add much.
+	if tablesSize > plainSize+plainSize/4 {
+		t.Errorf("tables size too large: %d > %d", tablesSize, plainSize+plainSize/4)
+	}
+
+	// Random data is stored.
+	var buf bytes.Buffer
+	StatelessDeflateTables(&buf, random, true, nil, tables)
+	if buf.Len() > len(random)+10 {
+		t.Errorf("random data: got %d bytes, want <= %d", buf.Len(), len(random)+10)
+	}
+
+	// Several calls with a dictionary.
+	buf.Reset()
+	var want []byte
+	for i, in := range inputs[4:10] {
+		if err := StatelessDeflateTables(&buf, in, i == 5, want, tables); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, in...)
+	}
+	got, err := ioutil.ReadAll(NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("dictionary: output mismatch")
+	}
+}
+
+func TestWriterTables(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		samples = append(samples, tablesPayload(i))
+	}
+	tables, err := TrainTables(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWriterOpts(nil, WithTables(nil)); err == nil {
+		t.Error("want error for nil tables")
+	}
+	if _, err := NewWriterOpts(nil, WithTables(tables), WithBlockType(BlockFixed)); err == nil {
+		t.Error("want error for fixed blocks")
+	}
+	input := bytes.Join(samples, nil)
+	for level := HuffmanOnly; level <= BestCompression; level++ {
+		var buf bytes.Buffer
+		w, err := NewWriterOpts(&buf, WithLevel(level), WithTables(tables))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			buf.Reset()
+			w.Write(input[:len(input)/2])
+			w.Flush()
+			w.Write(input[len(input)/2:])
+			w.Close()
+			got, err := ioutil.ReadAll(NewReader(&buf))
+			if err != nil {
+				t.Fatalf("level %d: %v", level, err)
+			}
+			if !bytes.Equal(got, input) {
+				t.Fatalf("level %d: output mismatch", level)
+			}
+			// The cached header is kept on Reset.
+			if level != NoCompression && w.d.w.tablesHeader.t != tables {
+				t.Fatalf("level %d: header not cached", level)
+			}
+			w.Reset(&buf)
+		}
+	}
+}
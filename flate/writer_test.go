@@ -12,6 +12,7 @@ import (
 	"io/ioutil"
 	"math"
 	"math/rand"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -449,3 +450,280 @@ func copyBuffer(dst io.Writer, src io.Reader, buf []byte) (written int64, err er
 	}
 	return written, err
 }
+
+func TestParallelWriter(t *testing.T) {
+	var buf bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "asdasfasf%d%dfghfgujyut%dyutyu\n", i, i, i)
+	}
+	rng := rand.New(rand.NewSource(1))
+	rnd := make([]byte, 100000)
+	rng.Read(rnd)
+	buf.Write(rnd)
+	in := buf.Bytes()
+	dict := in[len(in)/2 : len(in)/2+10000]
+
+	for _, l := range []int{-2, -1, 0, 1, 5, 6, 7, 9} {
+		for _, bs := range []int{0, 5000, 50000} {
+			for _, useDict := range []bool{false, true} {
+				t.Run(fmt.Sprintf("level-%d-bs-%d-dict-%v", l, bs, useDict), func(t *testing.T) {
+					var dst bytes.Buffer
+					w, err := NewParallelWriter(&dst, l, bs, 4)
+					if err != nil {
+						t.Fatal(err)
+					}
+					var d []byte
+					if useDict {
+						d = dict
+						w.ResetDict(&dst, d)
+					}
+					for i := 0; i < 2; i++ {
+						dst.Reset()
+						// Write in uneven pieces with a flush in the middle.
+						for j, p := 0, in; len(p) > 0; j++ {
+							n := 12345 * (j + 1)
+							if n > len(p) {
+								n = len(p)
+							}
+							if _, err := w.Write(p[:n]); err != nil {
+								t.Fatal(err)
+							}
+							p = p[n:]
+							if j == 3 {
+								if err := w.Flush(); err != nil {
+									t.Fatal(err)
+								}
+							}
+						}
+						if err := w.Close(); err != nil {
+							t.Fatal(err)
+						}
+						if err := w.Close(); err != nil {
+							t.Fatal(err)
+						}
+						if _, err := w.Write([]byte{1}); err == nil {
+							t.Fatal("want error writing after Close")
+						}
+						r := NewReaderDict(&dst, d)
+						got, err := ioutil.ReadAll(r)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !bytes.Equal(got, in) {
+							t.Fatal("output mismatch")
+						}
+						w.Reset(&dst)
+					}
+				})
+			}
+		}
+	}
+
+	// Empty stream.
+	var dst bytes.Buffer
+	w, err := NewParallelWriter(&dst, 5, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(NewReader(&dst))
+	if err != nil || len(got) != 0 {
+		t.Fatalf("got %d bytes, err %v", len(got), err)
+	}
+
+	for _, level := range []int{-3, 10} {
+		if _, err := NewParallelWriter(&dst, level, 0, 0); err == nil {
+			t.Fatalf("want error for invalid level %d", level)
+		}
+	}
+
+	// Errors from the underlying writer are returned.
+	w, _ = NewParallelWriter(&errorWriter{N: 1}, 5, 1000, 2)
+	w.Write(in)
+	if err := w.Close(); err == nil {
+		t.Fatal("want error from underlying writer")
+	}
+}
+
+func TestWriterOptimal(t *testing.T) {
+	files := []string{"../testdata/e.txt", "../testdata/Mark.Twain-Tom.Sawyer.txt", "../testdata/pngdata.bin", "../testdata/sharnd.out"}
+	for _, fn := range files {
+		in, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(in) > 200000 {
+			in = in[:200000]
+		}
+		t.Run(filepath.Base(fn), func(t *testing.T) {
+			var ref bytes.Buffer
+			w9, _ := NewWriter(&ref, BestCompression)
+			w9.Write(in)
+			w9.Close()
+
+			var dst bytes.Buffer
+			w := NewWriterOptimal(&dst, 5)
+			if _, err := w.Write(in); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			size := dst.Len()
+			got, err := ioutil.ReadAll(NewReader(&dst))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, in) {
+				t.Fatal("output mismatch")
+			}
+			t.Logf("level 9: %d, optimal: %d", ref.Len(), size)
+			if size > ref.Len() {
+				t.Errorf("optimal output (%d) larger than level 9 (%d)", size, ref.Len())
+			}
+		})
+	}
+
+	// Flush, dictionary and Reset.
+	in, err := ioutil.ReadFile("../testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in = in[:100000]
+	dict := in[:20000]
+	in = in[20000:]
+	var dst bytes.Buffer
+	w := NewWriterOptimal(&dst, 2)
+	w.ResetDict(&dst, dict)
+	for i := 0; i < 2; i++ {
+		w.Write(in[:1000])
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		w.Write(in[1000:])
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(NewReaderDict(&dst, dict))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Fatal("output mismatch")
+		}
+		dst.Reset()
+		w.Reset(&dst)
+	}
+}
+
+func TestWriterOpts(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// Random data repeated at a distance of 1000 bytes,
+	// followed by runs and text.
+	rnd := make([]byte, 1000)
+	rng.Read(rnd)
+	var buf bytes.Buffer
+	buf.Write(rnd)
+	buf.Write(rnd)
+	for i := 0; i < 100; i++ {
+		buf.Write(bytes.Repeat([]byte{byte(i)}, rng.Intn(200)))
+		fmt.Fprintf(&buf, "line %d of the text\n", i)
+	}
+	in := buf.Bytes()
+
+	for _, level := range []int{-2, 0, 1, 5, 6, 7, 9} {
+		for _, wb := range []int{9, 12, 15} {
+			for _, bt := range []BlockType{BlockAuto, BlockStored, BlockFixed, BlockDynamic} {
+				for _, st := range []Strategy{StrategyDefault, StrategyFiltered, StrategyRLE, StrategyHuffmanOnly} {
+					name := fmt.Sprintf("level-%d-wb-%d-bt-%d-st-%d", level, wb, bt, st)
+					t.Run(name, func(t *testing.T) {
+						var dst bytes.Buffer
+						w, err := NewWriterOpts(&dst, WithLevel(level), WithWindowBits(wb), WithBlockType(bt), WithStrategy(st), WithBlockTokens(1000))
+						if err != nil {
+							t.Fatal(err)
+						}
+						if _, err := w.Write(in); err != nil {
+							t.Fatal(err)
+						}
+						if err := w.Close(); err != nil {
+							t.Fatal(err)
+						}
+						compressed := dst.Bytes()
+						var types []int
+						r := NewReaderCallback(bytes.NewReader(compressed), func(b BlockInfo) {
+							typ := int(compressed[b.BitOffset>>3]) | int(compressed[b.BitOffset>>3+1])<<8
+							types = append(types, (typ>>uint(b.BitOffset&7+1))&3)
+						})
+						got, err := ioutil.ReadAll(r)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !bytes.Equal(got, in) {
+							t.Fatal("output mismatch")
+						}
+						// The last block is the empty final block.
+						types = types[:len(types)-1]
+						for i, typ := range types {
+							switch bt {
+							case BlockStored:
+								if typ != 0 {
+									t.Fatalf("block %d: type %d, want stored", i, typ)
+								}
+							case BlockFixed:
+								if typ != 1 && level != 0 {
+									t.Fatalf("block %d: type %d, want fixed", i, typ)
+								}
+							case BlockDynamic:
+								if typ != 2 && level != 0 {
+									t.Fatalf("block %d: type %d, want dynamic", i, typ)
+								}
+							}
+						}
+					})
+				}
+			}
+		}
+	}
+
+	// Matches are limited by the window.
+	for _, level := range []int{1, 5, 6, 7, 9} {
+		var small, full bytes.Buffer
+		for _, dst := range []*bytes.Buffer{&small, &full} {
+			wb := 15
+			if dst == &small {
+				wb = 9
+			}
+			w, err := NewWriterOpts(dst, WithLevel(level), WithWindowBits(wb))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(append(rnd, rnd...))
+			w.Close()
+		}
+		if small.Len() < 2000 || full.Len() > 1200 {
+			t.Errorf("level %d: got %d bytes with 9 bit window, %d bytes with 15 bit window", level, small.Len(), full.Len())
+		}
+	}
+
+	// RLE
+	runs := bytes.Repeat([]byte("aaaaaaaaaaaaaaaabbbbbbbbbbbbbbbbbbbbbbbbbbccccccccccccccccccccccccccccc"), 100)
+	var dst bytes.Buffer
+	w, err := NewWriterOpts(&dst, WithStrategy(StrategyRLE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(runs)
+	w.Close()
+	if dst.Len() > len(runs)/10 {
+		t.Errorf("RLE: got %d bytes from %d", dst.Len(), len(runs))
+	}
+
+	// Invalid options.
+	for i, opt := range []Option{WithLevel(10), WithWindowBits(8), WithWindowBits(16), WithBlockTokens(100), WithBlockTokens(maxStoreBlockSize), WithBlockType(BlockDynamic + 1), WithStrategy(-1)} {
+		if _, err := NewWriterOpts(ioutil.Discard, opt); err == nil {
+			t.Errorf("option %d: want error", i)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+// Package gzhttp provides HTTP middleware that compresses responses with gzip,
+// and a client transport that transparently decompresses them.
+package gzhttp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress"
+	"github.com/klauspost/compress/gzip"
+)
+
+const (
+	// DefaultMinSize is the default minimum size of responses to compress.
+	DefaultMinSize = 1024
+
+	// minEstimate is the compress.Estimate of the start of a response
+	// below which it is considered already compressed.
+	minEstimate = 0.1
+
+	// minEstimateLen is the shortest input compress.Estimate can evaluate.
+	minEstimateLen = 16
+
+	acceptEncoding  = "Accept-Encoding"
+	contentEncoding = "Content-Encoding"
+	contentLength   = "Content-Length"
+	contentType     = "Content-Type"
+)
+
+type config struct {
+	minSize  int
+	level    int
+	poolSize int
+}
+
+// Option sets an option for NewWrapper.
+type Option func(c *config) error
+
+// MinSize sets the minimum size of responses to compress.
+// Smaller responses are sent uncompressed.
+// The default is DefaultMinSize.
+func MinSize(size int) Option {
+	return func(c *config) error {
+		if size < 0 {
+			return fmt.Errorf("gzhttp: invalid minimum size: %d", size)
+		}
+		c.minSize = size
+		return nil
+	}
+}
+
+// CompressionLevel sets the gzip compression level.
+// The default is gzip.StatelessCompression, which keeps no memory
+// between writes to the response.
+func CompressionLevel(level int) Option {
+	return func(c *config) error {
+		if level < gzip.StatelessCompression || level > gzip.BestCompression {
+			return fmt.Errorf("gzhttp: invalid compression level: %d", level)
+		}
+		c.level = level
+		return nil
+	}
+}
+
+// PoolSize sets the maximum number of idle gzip Writers kept for reuse.
+// The default is runtime.GOMAXPROCS(0).
+func PoolSize(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return fmt.Errorf("gzhttp: invalid pool size: %d", n)
+		}
+		c.poolSize = n
+		return nil
+	}
+}
+
+// NewWrapper returns a function that wraps http.Handlers,
+// so responses are compressed with gzip when the client accepts it.
+//
+// A response is compressed once MinSize bytes are written,
+// unless the handler set a Content-Encoding, a Content-Length below MinSize,
+// or the content appears to be compressed already.
+// Compressed responses have their Content-Length and Accept-Ranges headers
+// removed, and a strong ETag is made weak.
+// Vary: Accept-Encoding is added to all responses.
+// Requests with a Range header are not compressed.
+//
+// Flushing the response before MinSize bytes are written starts
+// compression of the data written so far.
+// The wrapped http.ResponseWriter implements http.Flusher and http.Hijacker,
+// which are passed through to the underlying http.ResponseWriter.
+func NewWrapper(opts ...Option) (func(http.Handler) http.Handler, error) {
+	c := config{
+		minSize: DefaultMinSize,
+		level:   gzip.StatelessCompression,
+	}
+	for _, o := range opts {
+		if err := o(&c); err != nil {
+			return nil, err
+		}
+	}
+	pool, err := gzip.NewWriterPool(c.level, c.poolSize)
+	if err != nil {
+		return nil, err
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addVary(w.Header())
+			if !acceptsGzip(r) || r.Header.Get("Range") != "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			gw := &responseWriter{ResponseWriter: w, pool: pool, minSize: c.minSize}
+			defer gw.close()
+			h.ServeHTTP(gw, r)
+		})
+	}, nil
+}
+
+// GzipHandler wraps h, so responses are compressed with the default options.
+// See NewWrapper.
+func GzipHandler(h http.Handler) http.Handler {
+	wrap, _ := NewWrapper()
+	return wrap(h)
+}
+
+// addVary adds Accept-Encoding to the Vary header, unless present.
+func addVary(h http.Header) {
+	for _, v := range h["Vary"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, acceptEncoding) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", acceptEncoding)
+}
+
+// acceptsGzip returns whether the Accept-Encoding header of r allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	star := false
+	for _, v := range r.Header[acceptEncoding] {
+		for _, f := range strings.Split(v, ",") {
+			coding, q := parseCoding(f)
+			switch {
+			case strings.EqualFold(coding, "gzip"), strings.EqualFold(coding, "x-gzip"):
+				return q > 0
+			case coding == "*":
+				star = q > 0
+			}
+		}
+	}
+	return star
+}
+
+// parseCoding returns the content coding and quality value of an
+// Accept-Encoding element, such as "gzip;q=0.5".
+func parseCoding(s string) (coding string, q float64) {
+	params := strings.Split(s, ";")
+	coding = strings.TrimSpace(params[0])
+	q = 1
+	for _, p := range params[1:] {
+		p = strings.TrimSpace(p)
+		if !strings.HasPrefix(p, "q=") {
+			continue
+		}
+		v, err := strconv.ParseFloat(p[2:], 64)
+		if err != nil {
+			return coding, 0
+		}
+		q = v
+	}
+	return coding, q
+}
+
+// responseWriter compresses the response written to it, if it is large
+// enough and compressible. The response is buffered until minSize bytes
+// are written, the response is flushed or the handler returns.
+type responseWriter struct {
+	http.ResponseWriter
+	pool    *gzip.WriterPool
+	minSize int
+
+	buf      []byte       // Start of the response, until decided.
+	code     int          // Status code, if WriteHeader was called before deciding.
+	decided  bool         // Whether to compress has been decided.
+	gz       *gzip.Writer // Compressor, if compressing.
+	hijacked bool
+}
+
+// WriteHeader sends the response header with the status code,
+// once it is known whether the response is compressed.
+func (w *responseWriter) WriteHeader(code int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.code != 0 {
+		// Superfluous call, ignored like net/http does.
+		return
+	}
+	w.code = code
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		// No body.
+		w.start(false)
+	}
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		if !w.mayCompress() {
+			if err := w.start(false); err != nil {
+				return 0, err
+			}
+			return w.ResponseWriter.Write(b)
+		}
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= w.minSize {
+			if err := w.start(w.compressible()); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client.
+func (w *responseWriter) Flush() {
+	if !w.decided {
+		w.start(w.mayCompress() && w.compressible())
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("gzhttp: ResponseWriter does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// mayCompress returns whether the headers allow compressing the response.
+func (w *responseWriter) mayCompress() bool {
+	h := w.Header()
+	if h.Get(contentEncoding) != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get(contentLength)); err == nil && n < w.minSize {
+		return false
+	}
+	return true
+}
+
+// compressible returns whether the buffered start of the response
+// appears to be compressible.
+func (w *responseWriter) compressible() bool {
+	return len(w.buf) < minEstimateLen || compress.Estimate(w.buf) >= minEstimate
+}
+
+// start writes the response header and the buffered data,
+// compressed if gz is set.
+func (w *responseWriter) start(gz bool) error {
+	w.decided = true
+	// Added again, in case the handler replaced the header.
+	addVary(w.Header())
+	if gz {
+		h := w.Header()
+		if h.Get(contentType) == "" && len(w.buf) > 0 {
+			// Detect it before compression, as net/http would.
+			h.Set(contentType, http.DetectContentType(w.buf))
+		}
+		h.Del(contentLength)
+		h.Del("Accept-Ranges")
+		h.Set(contentEncoding, "gzip")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			// The compressed representation is not byte-for-byte identical.
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+	if w.code != 0 {
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+	if gz {
+		w.gz = w.pool.Get(w.ResponseWriter)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close writes what remains of the response when the handler returns.
+func (w *responseWriter) close() error {
+	if w.hijacked {
+		return nil
+	}
+	if !w.decided {
+		// Less than minSize bytes were written.
+		return w.start(false)
+	}
+	if w.gz == nil {
+		return nil
+	}
+	err := w.gz.Close()
+	w.pool.Put(w.gz)
+	w.gz = nil
+	return err
+}
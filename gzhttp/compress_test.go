@@ -0,0 +1,225 @@
+package gzhttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+var testBody = bytes.Repeat([]byte("<p>The quick brown fox jumped over the lazy dog.</p>\n"), 100)
+
+// serve returns the response from h to a GET request with the given Accept-Encoding.
+func serve(h http.Handler, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	if accept != "" {
+		req.Header.Set(acceptEncoding, accept)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func gunzip(t *testing.T, b []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestGzipHandler(t *testing.T) {
+	random := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(random)
+	tests := []struct {
+		name     string
+		accept   string
+		body     []byte
+		header   map[string]string
+		compress bool
+	}{
+		{name: "gzip", accept: "gzip, deflate", body: testBody, compress: true},
+		{name: "star", accept: "*", body: testBody, compress: true},
+		{name: "quality", accept: "br;q=1.0, gzip;q=0.5", body: testBody, compress: true},
+		{name: "refused", accept: "gzip;q=0, *", body: testBody},
+		{name: "no accept", body: testBody},
+		{name: "small", accept: "gzip", body: testBody[:DefaultMinSize-1]},
+		{name: "random", accept: "gzip", body: random},
+		{name: "encoded", accept: "gzip", body: testBody, header: map[string]string{contentEncoding: "br"}},
+		{name: "short length", accept: "gzip", body: testBody[:100], header: map[string]string{contentLength: "100"}},
+		{name: "etag", accept: "gzip", body: testBody, header: map[string]string{"ETag": `"abc"`}, compress: true},
+	}
+	for _, test := range tests {
+		h := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, v := range test.header {
+				w.Header().Set(k, v)
+			}
+			// Write in small pieces.
+			for b := test.body; len(b) > 0; {
+				n := 100
+				if n > len(b) {
+					n = len(b)
+				}
+				w.Write(b[:n])
+				b = b[n:]
+			}
+		}))
+		rec := serve(h, test.accept)
+		res := rec.Result()
+		if got := res.Header.Get("Vary"); got != acceptEncoding {
+			t.Errorf("%s: Vary = %q, want %q", test.name, got, acceptEncoding)
+		}
+		body := rec.Body.Bytes()
+		if test.compress {
+			if got := res.Header.Get(contentEncoding); got != "gzip" {
+				t.Fatalf("%s: Content-Encoding = %q, want gzip", test.name, got)
+			}
+			if got := res.Header.Get(contentType); !strings.HasPrefix(got, "text/html") {
+				t.Errorf("%s: Content-Type = %q, want text/html", test.name, got)
+			}
+			body = gunzip(t, body)
+		} else if got := res.Header.Get(contentEncoding); got != test.header[contentEncoding] {
+			t.Fatalf("%s: Content-Encoding = %q, want %q", test.name, got, test.header[contentEncoding])
+		}
+		if !bytes.Equal(body, test.body) {
+			t.Errorf("%s: body mismatch", test.name)
+		}
+		if etag := test.header["ETag"]; etag != "" {
+			if got := res.Header.Get("ETag"); got != "W/"+etag {
+				t.Errorf("%s: ETag = %q, want %q", test.name, got, "W/"+etag)
+			}
+		}
+	}
+}
+
+func TestNewWrapper(t *testing.T) {
+	for _, opt := range []Option{MinSize(-1), CompressionLevel(10), PoolSize(0)} {
+		if _, err := NewWrapper(opt); err == nil {
+			t.Error("want error for invalid option")
+		}
+	}
+	wrap, err := NewWrapper(MinSize(10), CompressionLevel(gzip.BestCompression), PoolSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Origin")
+		w.Header().Set(contentType, "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello, hello, hello"))
+	}))
+	for i := 0; i < 3; i++ {
+		rec := serve(h, "gzip")
+		res := rec.Result()
+		if res.StatusCode != http.StatusCreated {
+			t.Errorf("status = %d, want %d", res.StatusCode, http.StatusCreated)
+		}
+		if got := res.Header["Vary"]; len(got) != 2 || got[1] != acceptEncoding {
+			t.Errorf("Vary = %q", got)
+		}
+		if got := res.Header.Get(contentEncoding); got != "gzip" {
+			t.Fatalf("Content-Encoding = %q, want gzip", got)
+		}
+		if got := string(gunzip(t, rec.Body.Bytes())); got != "hello, hello, hello" {
+			t.Errorf("body = %q", got)
+		}
+	}
+
+	// Responses without a body.
+	h = wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	rec := serve(h, "gzip")
+	if rec.Code != http.StatusNotModified || rec.Header().Get(contentEncoding) != "" || rec.Body.Len() != 0 {
+		t.Errorf("not modified: status %d, Content-Encoding %q, %d bytes", rec.Code, rec.Header().Get(contentEncoding), rec.Body.Len())
+	}
+}
+
+func TestFlush(t *testing.T) {
+	h := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentType, "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: second\n\n"))
+	}))
+	rec := serve(h, "gzip")
+	if !rec.Flushed {
+		t.Error("not flushed")
+	}
+	if got := rec.Header().Get(contentEncoding); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := string(gunzip(t, rec.Body.Bytes())); got != "data: first\n\ndata: second\n\n" {
+		t.Errorf("body = %q", got)
+	}
+}
+
+func TestHijack(t *testing.T) {
+	srv := httptest.NewServer(GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		rw.Flush()
+	})))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set(acceptEncoding, "gzip")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if string(body) != "hijacked" {
+		t.Errorf("body = %q, want %q", body, "hijacked")
+	}
+
+	// Not supported by the recorder.
+	h := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			t.Error("want error from Hijack")
+		}
+	}))
+	serve(h, "gzip")
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                   false,
+		"gzip":               true,
+		"GZIP":               true,
+		"x-gzip":             true,
+		"deflate, gzip":      true,
+		"gzip;q=0":           false,
+		"gzip; q=0.001":      true,
+		"gzip;q=bad":         false,
+		"*":                  true,
+		"*;q=0":              false,
+		"gzip;q=0, *;q=1":    false,
+		"identity, *;q=0.1":  true,
+		"br, deflate":        false,
+		"gzip;level=1;q=0.8": true,
+	}
+	for accept, want := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(acceptEncoding, accept)
+		if got := acceptsGzip(req); got != want {
+			t.Errorf("%q: got %v, want %v", accept, got, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package gzhttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// Transport returns an http.RoundTripper that asks for gzip compressed
+// responses and decompresses them transparently, using parent to make the
+// requests. If parent is nil, http.DefaultTransport is used.
+//
+// Requests that already have an Accept-Encoding or a Range header are
+// sent unchanged, and their responses are not decompressed.
+// As with the default transport, decompressed responses have Uncompressed
+// set, and their Content-Encoding and Content-Length headers removed.
+func Transport(parent http.RoundTripper) http.RoundTripper {
+	if parent == nil {
+		parent = http.DefaultTransport
+	}
+	return &transport{parent: parent}
+}
+
+type transport struct {
+	parent http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(acceptEncoding) != "" || req.Header.Get("Range") != "" {
+		return t.parent.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set(acceptEncoding, "gzip")
+	resp, err := t.parent.RoundTrip(req)
+	if err != nil || !strings.EqualFold(resp.Header.Get(contentEncoding), "gzip") {
+		return resp, err
+	}
+	resp.Body = &gzipBody{body: resp.Body}
+	resp.Header.Del(contentEncoding)
+	resp.Header.Del(contentLength)
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+var (
+	// readerPool keeps a bounded number of idle gzip Readers.
+	readerPool = gzip.NewReaderPool(0)
+
+	errBodyClosed = errors.New("gzhttp: read on closed response body")
+)
+
+// gzipBody decompresses a response body.
+// The header is read on the first Read, so creating it does not block.
+type gzipBody struct {
+	body io.ReadCloser
+	zr   *gzip.Reader
+	err  error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.zr == nil {
+		if b.zr, b.err = readerPool.Get(b.body); b.err != nil {
+			return 0, b.err
+		}
+	}
+	n, err := b.zr.Read(p)
+	b.err = err
+	return n, err
+}
+
+func (b *gzipBody) Close() error {
+	if b.zr != nil {
+		readerPool.Put(b.zr)
+		b.zr = nil
+	}
+	b.err = errBodyClosed
+	return b.body.Close()
+}
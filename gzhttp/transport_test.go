@@ -0,0 +1,66 @@
+package gzhttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testBody)
+	})))
+	defer srv.Close()
+	client := &http.Client{Transport: Transport(nil)}
+
+	for i := 0; i < 3; i++ {
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, testBody) {
+			t.Fatal("body mismatch")
+		}
+		if !res.Uncompressed || res.Header.Get(contentEncoding) != "" || res.ContentLength != -1 {
+			t.Errorf("response not marked uncompressed: %v, %q, %d", res.Uncompressed, res.Header.Get(contentEncoding), res.ContentLength)
+		}
+		if _, err := res.Body.Read(make([]byte, 1)); err == nil {
+			t.Error("want error reading closed body")
+		}
+	}
+
+	// An explicit Accept-Encoding is left to the caller.
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set(acceptEncoding, "gzip")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get(contentEncoding) != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", res.Header.Get(contentEncoding))
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if !bytes.Equal(gunzip(t, body), testBody) {
+		t.Error("body mismatch")
+	}
+
+	// Responses without a body.
+	req, _ = http.NewRequest("HEAD", srv.URL, nil)
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || len(body) != 0 {
+		t.Errorf("HEAD: got %d bytes, %v", len(body), err)
+	}
+}
@@ -0,0 +1,578 @@
+package gzip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// BGZF (Blocked GNU Zip Format) is a gzip variant where the stream consists of
+// independent gzip members of at most 64KB each.
+// Each member has an extra subfield ('B', 'C') holding the compressed size
+// of the member, which makes it possible to seek without decompressing.
+// See the SAM/BAM format specification for details.
+const (
+	// bgzfMaxBlockSize is the maximum size of a BGZF block, including header and trailer.
+	bgzfMaxBlockSize = 1 << 16
+	// bgzfMaxData is the maximum number of uncompressed bytes in a block.
+	// This is the same as htslib and ensures stored blocks will always fit.
+	bgzfMaxData = 0xff00
+	// bgzfHeaderLen is the size of the header written by BGZFWriter.
+	bgzfHeaderLen = 18
+	// bgzfTrailerLen is the size of the CRC and size trailer.
+	bgzfTrailerLen = 8
+)
+
+// bgzfEOF is the empty block that terminates a BGZF file.
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0xff, 0x06, 0x00, 0x42, 0x43, 0x02, 0x00,
+	0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+}
+
+var (
+	// ErrBGZFNoIndex is returned when seeking to an uncompressed offset without an index.
+	ErrBGZFNoIndex = errors.New("gzip: bgzf index required to seek")
+	// errBGZFHeader is returned when a block does not have a BGZF header.
+	errBGZFHeader = errors.New("gzip: missing bgzf block size")
+	// errBGZFBlockSize is returned when a block decompresses to more than 64KB.
+	errBGZFBlockSize = errors.New("gzip: bgzf block too large")
+)
+
+// BGZFOffset is a BGZF virtual file offset.
+// The upper 48 bits contain the offset of a block in the compressed file,
+// the lower 16 bits the offset within the uncompressed data of the block.
+type BGZFOffset uint64
+
+// NewBGZFOffset returns a virtual offset from a compressed block offset and
+// an offset within the uncompressed block.
+func NewBGZFOffset(compressed int64, uncompressed int) BGZFOffset {
+	return BGZFOffset(compressed<<16) | BGZFOffset(uncompressed&0xffff)
+}
+
+// Compressed returns the offset of the block in the compressed file.
+func (o BGZFOffset) Compressed() int64 {
+	return int64(o >> 16)
+}
+
+// Uncompressed returns the offset within the uncompressed data of the block.
+func (o BGZFOffset) Uncompressed() int {
+	return int(o & 0xffff)
+}
+
+// String returns the offset as "compressed:uncompressed".
+func (o BGZFOffset) String() string {
+	return fmt.Sprintf("%d:%d", o.Compressed(), o.Uncompressed())
+}
+
+// BGZFIndexEntry is the start of a block in a BGZF index.
+type BGZFIndexEntry struct {
+	Compressed   uint64 // Offset of the block in the compressed file.
+	Uncompressed uint64 // Offset of the block in the uncompressed data.
+}
+
+// BGZFIndex contains the start of blocks of a BGZF file,
+// sorted by offset.
+// The first block, starting at 0, is not included.
+// This matches the contents of .gzi files.
+type BGZFIndex []BGZFIndexEntry
+
+// ReadBGZFIndex reads an index in .gzi format.
+func ReadBGZFIndex(r io.Reader) (BGZFIndex, error) {
+	var tmp [16]byte
+	if _, err := io.ReadFull(r, tmp[:8]); err != nil {
+		return nil, noEOF(err)
+	}
+	n := le.Uint64(tmp[:8])
+	// Sanity check, each entry refers to a block with at least one byte of data.
+	if n > 1<<40 {
+		return nil, errors.New("gzip: invalid bgzf index")
+	}
+	// n is not trusted, so the index grows as entries are read.
+	c := n
+	if c > 1<<12 {
+		c = 1 << 12
+	}
+	idx := make(BGZFIndex, 0, int(c))
+	for i := uint64(0); i < n; i++ {
+		if _, err := io.ReadFull(r, tmp[:]); err != nil {
+			return nil, noEOF(err)
+		}
+		e := BGZFIndexEntry{
+			Compressed:   le.Uint64(tmp[:8]),
+			Uncompressed: le.Uint64(tmp[8:]),
+		}
+		if len(idx) > 0 && (e.Compressed <= idx[len(idx)-1].Compressed || e.Uncompressed < idx[len(idx)-1].Uncompressed) {
+			return nil, errors.New("gzip: bgzf index not sorted")
+		}
+		idx = append(idx, e)
+	}
+	return idx, nil
+}
+
+// WriteTo writes the index in .gzi format.
+func (idx BGZFIndex) WriteTo(w io.Writer) (int64, error) {
+	b := make([]byte, 8, 8+len(idx)*16)
+	le.PutUint64(b, uint64(len(idx)))
+	var tmp [16]byte
+	for _, e := range idx {
+		le.PutUint64(tmp[:8], e.Compressed)
+		le.PutUint64(tmp[8:], e.Uncompressed)
+		b = append(b, tmp[:]...)
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// find returns the entry for the block containing the uncompressed offset.
+// If the offset is in the first block the zero entry is returned.
+func (idx BGZFIndex) find(offset uint64) BGZFIndexEntry {
+	i := sort.Search(len(idx), func(i int) bool {
+		return idx[i].Uncompressed > offset
+	})
+	if i == 0 {
+		return BGZFIndexEntry{}
+	}
+	return idx[i-1]
+}
+
+// BuildBGZFIndex creates an index by reading block headers from r.
+// Only the block headers and trailers are read, no data is decompressed.
+// If r implements io.Seeker the compressed data is skipped.
+func BuildBGZFIndex(r io.Reader) (BGZFIndex, error) {
+	var idx BGZFIndex
+	var buf [bgzfMaxBlockSize]byte
+	var cOff, uOff uint64
+	s, canSeek := r.(io.Seeker)
+	for {
+		hdrLen, bsize, err := readBGZFHeader(r, buf[:])
+		if err == io.EOF {
+			return idx, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		skip := bsize - hdrLen - bgzfTrailerLen
+		if canSeek {
+			_, err = s.Seek(int64(skip), io.SeekCurrent)
+		} else {
+			_, err = io.ReadFull(r, buf[:skip])
+		}
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if _, err := io.ReadFull(r, buf[:bgzfTrailerLen]); err != nil {
+			return nil, noEOF(err)
+		}
+		size := le.Uint32(buf[4:8])
+		if size > 0 {
+			if cOff > 0 {
+				idx = append(idx, BGZFIndexEntry{Compressed: cOff, Uncompressed: uOff})
+			}
+			uOff += uint64(size)
+		}
+		cOff += uint64(bsize)
+	}
+}
+
+// readBGZFHeader reads a gzip header with a BGZF extra field into buf.
+// The header length and the total block size are returned.
+// io.EOF is returned if there is no more input.
+func readBGZFHeader(r io.Reader, buf []byte) (hdrLen, bsize int, err error) {
+	if _, err = io.ReadFull(r, buf[:12]); err != nil {
+		return 0, 0, err
+	}
+	if buf[0] != gzipID1 || buf[1] != gzipID2 || buf[2] != gzipDeflate {
+		return 0, 0, ErrHeader
+	}
+	// BGZF headers only contain the extra field.
+	if buf[3]&flagExtra == 0 || buf[3]&(flagName|flagComment|flagHdrCrc) != 0 {
+		return 0, 0, errBGZFHeader
+	}
+	xlen := int(le.Uint16(buf[10:12]))
+	hdrLen = 12 + xlen
+	if _, err = io.ReadFull(r, buf[12:hdrLen]); err != nil {
+		return 0, 0, noEOF(err)
+	}
+	// Find BC subfield.
+	extra := buf[12:hdrLen]
+	for len(extra) >= 4 {
+		slen := int(le.Uint16(extra[2:4]))
+		if len(extra) < 4+slen {
+			break
+		}
+		if extra[0] == 'B' && extra[1] == 'C' && slen == 2 {
+			bsize = int(le.Uint16(extra[4:6])) + 1
+			if bsize < hdrLen+bgzfTrailerLen {
+				return 0, 0, ErrHeader
+			}
+			return hdrLen, bsize, nil
+		}
+		extra = extra[4+slen:]
+	}
+	return 0, 0, errBGZFHeader
+}
+
+// BGZFWriter writes BGZF files.
+// Input is written as independent gzip members with up to 65280 bytes
+// of uncompressed data each.
+type BGZFWriter struct {
+	w          io.Writer
+	level      int
+	compressor *flate.Writer
+	buf        []byte
+	out        bytes.Buffer
+	offset     int64
+	uOffset    uint64
+	idx        BGZFIndex
+	err        error
+}
+
+// NewBGZFWriter returns a new BGZFWriter writing to w at the given compression level.
+// The level can be any level accepted by NewWriterLevel, except StatelessCompression.
+//
+// It is the caller's responsibility to call Close when done,
+// which writes the final end-of-file block.
+func NewBGZFWriter(w io.Writer, level int) (*BGZFWriter, error) {
+	fw, err := flate.NewWriter(nil, level)
+	if err != nil {
+		return nil, fmt.Errorf("gzip: invalid compression level: %d", level)
+	}
+	return &BGZFWriter{
+		w:          w,
+		level:      level,
+		compressor: fw,
+		buf:        make([]byte, 0, bgzfMaxData),
+	}, nil
+}
+
+// Reset discards the writer's state and makes it equivalent to the
+// result of NewBGZFWriter, but writing to w instead.
+func (z *BGZFWriter) Reset(w io.Writer) {
+	z.w = w
+	z.buf = z.buf[:0]
+	z.offset = 0
+	z.uOffset = 0
+	z.idx = nil
+	z.err = nil
+}
+
+// Write writes p to the BGZF stream.
+func (z *BGZFWriter) Write(p []byte) (n int, err error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	for len(p) > 0 {
+		n2 := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+n2]
+		n += n2
+		p = p[n2:]
+		if len(z.buf) == cap(z.buf) {
+			if err := z.writeBlock(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// writeBlock compresses and writes the current buffer as a block.
+func (z *BGZFWriter) writeBlock() error {
+	if len(z.buf) == 0 {
+		return nil
+	}
+	z.out.Reset()
+	var hdr [bgzfHeaderLen]byte
+	copy(hdr[:], bgzfEOF[:bgzfHeaderLen])
+	z.out.Write(hdr[:])
+	z.compressor.Reset(&z.out)
+	z.compressor.Write(z.buf)
+	z.compressor.Close()
+	if z.out.Len()+bgzfTrailerLen > bgzfMaxBlockSize {
+		// Store the data uncompressed. Since the data is at most
+		// bgzfMaxData bytes this always fits within a single block.
+		z.out.Truncate(bgzfHeaderLen)
+		z.out.Write([]byte{1, byte(len(z.buf)), byte(len(z.buf) >> 8), ^byte(len(z.buf)), ^byte(len(z.buf) >> 8)})
+		z.out.Write(z.buf)
+	}
+	var trailer [bgzfTrailerLen]byte
+	le.PutUint32(trailer[:4], crc32.ChecksumIEEE(z.buf))
+	le.PutUint32(trailer[4:], uint32(len(z.buf)))
+	z.out.Write(trailer[:])
+
+	b := z.out.Bytes()
+	le.PutUint16(b[16:18], uint16(len(b)-1))
+	if z.offset > 0 {
+		z.idx = append(z.idx, BGZFIndexEntry{Compressed: uint64(z.offset), Uncompressed: z.uOffset})
+	}
+	n, err := z.w.Write(b)
+	if err == nil && n != len(b) {
+		err = io.ErrShortWrite
+	}
+	z.offset += int64(n)
+	z.uOffset += uint64(len(z.buf))
+	z.buf = z.buf[:0]
+	z.err = err
+	return err
+}
+
+// Flush ends the current block and writes it to the underlying writer.
+// Data written after Flush will start in a new block.
+func (z *BGZFWriter) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	return z.writeBlock()
+}
+
+// Close writes any pending data and the end-of-file block.
+// It does not close the underlying writer.
+func (z *BGZFWriter) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if err := z.writeBlock(); err != nil {
+		return err
+	}
+	n, err := z.w.Write(bgzfEOF)
+	z.offset += int64(n)
+	z.err = err
+	if err == nil {
+		z.err = errors.New("gzip: bgzf writer closed")
+	}
+	return err
+}
+
+// VirtualOffset returns the virtual offset of the next byte written.
+func (z *BGZFWriter) VirtualOffset() BGZFOffset {
+	return NewBGZFOffset(z.offset, len(z.buf))
+}
+
+// Index returns the index of all blocks written so far.
+// Call after Close to get the index of the complete file.
+func (z *BGZFWriter) Index() BGZFIndex {
+	return z.idx
+}
+
+// BGZFReader reads BGZF files.
+// It can also read regular gzip files if no seeking is required.
+type BGZFReader struct {
+	r            io.Reader
+	decompressor io.ReadCloser
+	idx          BGZFIndex
+
+	in      []byte
+	data    []byte
+	br      bytes.Reader
+	offset  int64 // Compressed offset of current block.
+	next    int64 // Compressed offset of next block.
+	uStart  uint64
+	dataOff int // Read offset in data.
+	err     error
+}
+
+// NewBGZFReader returns a reader reading BGZF blocks from r.
+// To seek r must implement io.Seeker.
+func NewBGZFReader(r io.Reader) (*BGZFReader, error) {
+	z := &BGZFReader{
+		in: make([]byte, bgzfMaxBlockSize),
+		// Allow one extra byte to detect blocks that are too large.
+		data: make([]byte, 0, bgzfMaxBlockSize+1),
+	}
+	z.decompressor = flate.NewReader(&z.br)
+	if err := z.Reset(r); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Reset discards the reader's state and makes it equivalent to the
+// result of NewBGZFReader, but reading from r instead.
+// Any index is removed.
+func (z *BGZFReader) Reset(r io.Reader) error {
+	z.r = r
+	z.idx = nil
+	z.data = z.data[:0]
+	z.offset, z.next, z.uStart, z.dataOff = 0, 0, 0, 0
+	z.err = nil
+	if s, ok := r.(io.Seeker); ok {
+		off, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		z.offset, z.next = off, off
+	}
+	return nil
+}
+
+// SetIndex sets the index used for seeking to uncompressed offsets.
+// The index is retained until Reset is called.
+func (z *BGZFReader) SetIndex(idx BGZFIndex) {
+	z.idx = idx
+}
+
+// readBlock reads the block at z.next.
+// Empty blocks are skipped.
+func (z *BGZFReader) readBlock() error {
+	for {
+		z.offset = z.next
+		z.uStart += uint64(len(z.data))
+		z.data, z.dataOff = z.data[:0], 0
+		hdrLen, bsize, err := readBGZFHeader(z.r, z.in)
+		if err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(z.r, z.in[hdrLen:bsize]); err != nil {
+			return noEOF(err)
+		}
+		z.next += int64(bsize)
+		z.br.Reset(z.in[hdrLen : bsize-bgzfTrailerLen])
+		z.decompressor.(flate.Resetter).Reset(&z.br, nil)
+		for {
+			n, err := z.decompressor.Read(z.data[len(z.data):cap(z.data)])
+			z.data = z.data[:len(z.data)+n]
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if len(z.data) == cap(z.data) {
+				return errBGZFBlockSize
+			}
+		}
+		trailer := z.in[bsize-bgzfTrailerLen : bsize]
+		if le.Uint32(trailer[:4]) != crc32.ChecksumIEEE(z.data) || le.Uint32(trailer[4:]) != uint32(len(z.data)) {
+			return ErrChecksum
+		}
+		if len(z.data) > 0 {
+			return nil
+		}
+	}
+}
+
+// Read reads uncompressed data.
+func (z *BGZFReader) Read(p []byte) (n int, err error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	for z.dataOff >= len(z.data) {
+		if err := z.readBlock(); err != nil {
+			z.err = err
+			return 0, err
+		}
+	}
+	n = copy(p, z.data[z.dataOff:])
+	z.dataOff += n
+	return n, nil
+}
+
+// VirtualOffset returns the virtual offset of the next byte to be read.
+func (z *BGZFReader) VirtualOffset() BGZFOffset {
+	if z.dataOff >= len(z.data) {
+		return NewBGZFOffset(z.next, 0)
+	}
+	return NewBGZFOffset(z.offset, z.dataOff)
+}
+
+// SeekVirtual seeks to the virtual offset off.
+// The underlying reader must implement io.Seeker.
+func (z *BGZFReader) SeekVirtual(off BGZFOffset) error {
+	s, ok := z.r.(io.Seeker)
+	if !ok {
+		return errors.New("gzip: bgzf reader cannot seek")
+	}
+	if off.Compressed() != z.offset || len(z.data) == 0 {
+		if _, err := s.Seek(off.Compressed(), io.SeekStart); err != nil {
+			return err
+		}
+		// The uncompressed position is unknown, unless we have an index.
+		z.uStart = 0
+		if e := z.idx.findCompressed(uint64(off.Compressed())); e != nil {
+			z.uStart = e.Uncompressed
+		}
+		z.next = off.Compressed()
+		z.data = z.data[:0]
+		z.err = nil
+		if err := z.readBlock(); err != nil {
+			z.err = err
+			if err != io.EOF || off.Uncompressed() > 0 {
+				return noEOF(err)
+			}
+			return nil
+		}
+	}
+	if off.Uncompressed() > len(z.data) {
+		return errors.New("gzip: bgzf offset outside block")
+	}
+	z.dataOff = off.Uncompressed()
+	z.err = nil
+	return nil
+}
+
+// findCompressed returns the index entry starting at compressed offset off.
+// The zero entry is returned if off is 0.
+// If no entry is found nil is returned.
+func (idx BGZFIndex) findCompressed(off uint64) *BGZFIndexEntry {
+	if off == 0 {
+		return &BGZFIndexEntry{}
+	}
+	i := sort.Search(len(idx), func(i int) bool {
+		return idx[i].Compressed >= off
+	})
+	if i < len(idx) && idx[i].Compressed == off {
+		return &idx[i]
+	}
+	return nil
+}
+
+// Seek implements io.Seeker for uncompressed offsets.
+// An index must be set with SetIndex, and the underlying reader must implement io.Seeker.
+// io.SeekEnd is not supported.
+func (z *BGZFReader) Seek(offset int64, whence int) (int64, error) {
+	if z.idx == nil {
+		return 0, ErrBGZFNoIndex
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += int64(z.uStart) + int64(z.dataOff)
+	default:
+		return 0, errors.New("gzip: bgzf unsupported whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("gzip: bgzf negative offset")
+	}
+	e := z.idx.find(uint64(offset))
+	if err := z.SeekVirtual(NewBGZFOffset(int64(e.Compressed), 0)); err != nil {
+		return 0, err
+	}
+	z.uStart = e.Uncompressed
+	// Skip forward to the offset.
+	skip := uint64(offset) - e.Uncompressed
+	for skip > 0 {
+		left := uint64(len(z.data) - z.dataOff)
+		if skip <= left {
+			z.dataOff += int(skip)
+			break
+		}
+		skip -= left
+		if err := z.readBlock(); err != nil {
+			z.err = err
+			return 0, noEOF(err)
+		}
+	}
+	return offset, nil
+}
+
+// Close closes the reader. It does not close the underlying reader.
+func (z *BGZFReader) Close() error {
+	z.err = errReaderClosed
+	return z.decompressor.Close()
+}
@@ -0,0 +1,196 @@
+package gzip
+
+import (
+	"bytes"
+	oldgz "compress/gzip"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestBGZF(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// Mix random and compressible data.
+	var in []byte
+	for len(in) < 1<<20 {
+		n := rng.Intn(100000)
+		if rng.Intn(2) == 0 {
+			b := make([]byte, n)
+			rng.Read(b)
+			in = append(in, b...)
+		} else {
+			in = append(in, bytes.Repeat([]byte("ACGTTGCA"), n/8)...)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, err := NewBGZFWriter(&buf, DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Record virtual offsets at random positions.
+	type pos struct {
+		off BGZFOffset
+		u   int
+	}
+	var positions []pos
+	for rem := in; len(rem) > 0; {
+		n := rng.Intn(20000)
+		if n > len(rem) {
+			n = len(rem)
+		}
+		positions = append(positions, pos{off: w.VirtualOffset(), u: len(in) - len(rem)})
+		if _, err := w.Write(rem[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rem = rem[n:]
+		if rng.Intn(20) == 0 {
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte{1}); err == nil {
+		t.Fatal("want error writing after Close")
+	}
+	compressed := buf.Bytes()
+	if !bytes.HasSuffix(compressed, bgzfEOF) {
+		t.Fatal("missing EOF block")
+	}
+
+	// Decode as regular gzip.
+	gr, err := oldgz.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("gzip output mismatch")
+	}
+
+	// Decode sequentially.
+	r, err := NewBGZFReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("bgzf output mismatch")
+	}
+
+	// Index must match the index built from the file.
+	idx := w.Index()
+	built, err := BuildBGZFIndex(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(idx, built) {
+		t.Fatalf("index mismatch, writer: %d entries, built: %d entries", len(idx), len(built))
+	}
+	var ib bytes.Buffer
+	if _, err := idx.WriteTo(&ib); err != nil {
+		t.Fatal(err)
+	}
+	if ib.Len() != 8+16*len(idx) {
+		t.Fatalf("index size %d, want %d", ib.Len(), 8+16*len(idx))
+	}
+	read, err := ReadBGZFIndex(&ib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(idx, read) {
+		t.Fatal("index roundtrip mismatch")
+	}
+
+	// A huge entry count must not be allocated up front.
+	ib.Reset()
+	idx.WriteTo(&ib)
+	huge := ib.Bytes()
+	le.PutUint64(huge, 1<<36)
+	if _, err := ReadBGZFIndex(bytes.NewReader(huge)); err != io.ErrUnexpectedEOF {
+		t.Fatalf("huge count: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// Seek to virtual offsets.
+	for i := range positions {
+		p := positions[rng.Intn(len(positions))]
+		if err := r.SeekVirtual(p.off); err != nil {
+			t.Fatalf("seek %d to %v: %v", i, p.off, err)
+		}
+		want := in[p.u:]
+		if len(want) > 1000 {
+			want = want[:1000]
+		}
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatalf("seek to %v: %v", p.off, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("seek to %v: data mismatch", p.off)
+		}
+	}
+
+	// Seek to uncompressed offsets.
+	if _, err := r.Seek(0, io.SeekStart); err != ErrBGZFNoIndex {
+		t.Fatalf("want ErrBGZFNoIndex, got %v", err)
+	}
+	r.SetIndex(idx)
+	for i := 0; i < 100; i++ {
+		off := rng.Intn(len(in) + 1)
+		if _, err := r.Seek(int64(off), io.SeekStart); err != nil {
+			t.Fatalf("seek to %d: %v", off, err)
+		}
+		want := in[off:]
+		if len(want) > 100 {
+			want = want[:100]
+		}
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatalf("seek to %d: %v", off, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("seek to %d: data mismatch", off)
+		}
+		if pos, err := r.Seek(0, io.SeekCurrent); err != nil || pos != int64(off+len(want)) {
+			t.Fatalf("current position: got %d, %v want %d", pos, err, off+len(want))
+		}
+	}
+}
+
+func TestBGZFReaderErrors(t *testing.T) {
+	// Regular gzip data has no block size.
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write([]byte("hello"))
+	w.Close()
+	r, err := NewBGZFReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != errBGZFHeader {
+		t.Fatalf("got %v, want %v", err, errBGZFHeader)
+	}
+
+	// Corrupt checksum.
+	buf.Reset()
+	bw, _ := NewBGZFWriter(&buf, BestSpeed)
+	bw.Write([]byte("hello world"))
+	bw.Close()
+	b := buf.Bytes()
+	b[len(b)-len(bgzfEOF)-5] ^= 1
+	r.Reset(bytes.NewReader(b))
+	if _, err := ioutil.ReadAll(r); err != ErrChecksum {
+		t.Fatalf("got %v, want %v", err, ErrChecksum)
+	}
+}
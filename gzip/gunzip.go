@@ -33,6 +33,8 @@ var (
 	ErrChecksum = errors.New("gzip: invalid checksum")
 	// ErrHeader is returned when reading GZIP data that has an invalid header.
 	ErrHeader = errors.New("gzip: invalid header")
+
+	errReaderClosed = errors.New("gzip: reader is closed")
 )
 
 var le = binary.LittleEndian
@@ -56,6 +58,76 @@ type Header struct {
 	ModTime time.Time // modification time
 	Name    string    // file name
 	OS      byte      // operating system type
+
+	// HeaderCRC is set if the header is protected by a CRC-16 (FHCRC).
+	// When reading, the CRC has been verified.
+	// When writing, the CRC will be added to the header.
+	HeaderCRC bool
+}
+
+// ExtraField is a subfield of the extra data in a gzip header,
+// as specified in RFC 1952, section 2.3.1.1.
+type ExtraField struct {
+	ID   [2]byte // Subfield ID, SI1 and SI2.
+	Data []byte  // Subfield data.
+}
+
+// ExtraFields returns the subfields of the extra data in the header.
+// The returned data references h.Extra.
+// ErrHeader is returned if the extra data is not a valid sequence of subfields.
+func (h *Header) ExtraFields() ([]ExtraField, error) {
+	var fields []ExtraField
+	extra := h.Extra
+	for len(extra) > 0 {
+		if len(extra) < 4 {
+			return nil, ErrHeader
+		}
+		n := int(le.Uint16(extra[2:4]))
+		if len(extra) < 4+n {
+			return nil, ErrHeader
+		}
+		fields = append(fields, ExtraField{
+			ID:   [2]byte{extra[0], extra[1]},
+			Data: extra[4 : 4+n : 4+n],
+		})
+		extra = extra[4+n:]
+	}
+	return fields, nil
+}
+
+// SetExtraFields replaces the extra data in the header with the given subfields.
+// An error is returned if a subfield uses the reserved ID with a zero
+// second byte, or the combined size exceeds the 64KB limit of the extra data.
+func (h *Header) SetExtraFields(fields ...ExtraField) error {
+	var extra []byte
+	for _, f := range fields {
+		if f.ID[1] == 0 {
+			return errors.New("gzip: reserved extra field ID")
+		}
+		if len(f.Data) > 0xffff {
+			return errors.New("gzip: extra field data is too large")
+		}
+		extra = append(extra, f.ID[0], f.ID[1], byte(len(f.Data)), byte(len(f.Data)>>8))
+		extra = append(extra, f.Data...)
+	}
+	if len(extra) > 0xffff {
+		return errors.New("gzip: extra data is too large")
+	}
+	h.Extra = extra
+	return nil
+}
+
+// Member contains information about a member of a gzip stream.
+type Member struct {
+	Header
+
+	// Offset is the offset of the member header in the compressed input.
+	// Offsets are only tracked if the callback is set before Reset,
+	// otherwise it is -1.
+	Offset int64
+
+	// UncompressedOffset is the offset of the member data in the uncompressed output.
+	UncompressedOffset int64
 }
 
 // A Reader is an io.Reader that can be read to retrieve
@@ -81,6 +153,21 @@ type Reader struct {
 	buf          [512]byte
 	err          error
 	multistream  bool
+
+	// ra is set for Readers created with NewReaderConcurrent.
+	ra *readAhead
+
+	// memberFn is called for each member header.
+	memberFn func(Member)
+	// cr counts input if memberFn was set when Reset was called.
+	cr *countReader
+	// out is the number of uncompressed bytes in previous members.
+	out int64
+
+	// recoverFn is set by SetRecovery.
+	recoverFn func(flate.RecoveryGap)
+	// gap is set if data was lost in the current member.
+	gap bool
 }
 
 // NewReader creates a new Reader reading the given reader.
@@ -102,15 +189,26 @@ func NewReader(r io.Reader) (*Reader, error) {
 // result of its original state from NewReader, but reading from r instead.
 // This permits reusing a Reader rather than allocating a new one.
 func (z *Reader) Reset(r io.Reader) error {
+	if z.ra != nil {
+		z.ra.stop()
+		z.ra.reset()
+	}
 	*z = Reader{
 		decompressor: z.decompressor,
 		multistream:  true,
+		ra:           z.ra,
+		memberFn:     z.memberFn,
+		recoverFn:    z.recoverFn,
 	}
 	if rr, ok := r.(flate.Reader); ok {
 		z.r = rr
 	} else {
 		z.r = bufio.NewReader(r)
 	}
+	if z.memberFn != nil {
+		z.cr = &countReader{r: z.r}
+		z.r = z.cr
+	}
 	z.Header, z.err = z.readHeader()
 	return z.err
 }
@@ -135,6 +233,20 @@ func (z *Reader) Multistream(ok bool) {
 	z.multistream = ok
 }
 
+// SetMemberCallback sets a function that is called with the header of
+// every member of the stream, including the first.
+// Set the callback before calling Reset to also get the first member
+// and the compressed offsets of each member.
+// Offsets are tracked by counting the input,
+// which disables the fast path for some input types.
+//
+// For Readers created with NewReaderConcurrent the callback
+// is called on a background goroutine.
+// The callback is retained when the Reader is Reset.
+func (z *Reader) SetMemberCallback(fn func(Member)) {
+	z.memberFn = fn
+}
+
 // readString reads a NUL-terminated string from z.r.
 // It treats the bytes read as being encoded as ISO 8859-1 (Latin-1) and
 // will output a string encoded using UTF-8.
@@ -173,6 +285,10 @@ func (z *Reader) readString() (string, error) {
 // readHeader reads the GZIP header according to section 2.3.1.
 // This method does not set z.err.
 func (z *Reader) readHeader() (hdr Header, err error) {
+	offset := int64(-1)
+	if z.cr != nil {
+		offset = z.cr.n - buffered(z.r)
+	}
 	if _, err = io.ReadFull(z.r, z.buf[:10]); err != nil {
 		// RFC 1952, section 2.2, says the following:
 		//	A gzip file consists of a series of "members" (compressed data sets).
@@ -228,13 +344,13 @@ func (z *Reader) readHeader() (hdr Header, err error) {
 		if digest != uint16(z.digest) {
 			return hdr, ErrHeader
 		}
+		hdr.HeaderCRC = true
 	}
 
 	z.digest = 0
-	if z.decompressor == nil {
-		z.decompressor = flate.NewReader(z.r)
-	} else {
-		z.decompressor.(flate.Resetter).Reset(z.r, nil)
+	z.resetDecompressor()
+	if z.memberFn != nil {
+		z.memberFn(Member{Header: hdr, Offset: offset, UncompressedOffset: z.out})
 	}
 	return hdr, nil
 }
@@ -244,23 +360,28 @@ func (z *Reader) Read(p []byte) (n int, err error) {
 	if z.err != nil {
 		return 0, z.err
 	}
+	if z.ra != nil {
+		return z.readConcurrent(p)
+	}
 
 	n, z.err = z.decompressor.Read(p)
 	z.digest = crc32.Update(z.digest, crc32.IEEETable, p[:n])
 	z.size += uint32(n)
+	z.out += int64(n)
 	if z.err != io.EOF {
 		// In the normal case we return here.
 		return n, z.err
 	}
 
 	// Finished file; check checksum and size.
+	z.unreadAhead()
 	if _, err := io.ReadFull(z.r, z.buf[:8]); err != nil {
 		z.err = noEOF(err)
 		return n, z.err
 	}
 	digest := le.Uint32(z.buf[:4])
 	size := le.Uint32(z.buf[4:8])
-	if digest != z.digest || size != z.size {
+	if (digest != z.digest || size != z.size) && !z.gap {
 		z.err = ErrChecksum
 		return n, z.err
 	}
@@ -285,6 +406,9 @@ func (z *Reader) Read(p []byte) (n int, err error) {
 
 // Support the io.WriteTo interface for io.Copy and friends.
 func (z *Reader) WriteTo(w io.Writer) (int64, error) {
+	if z.ra != nil {
+		return z.writeToConcurrent(w)
+	}
 	total := int64(0)
 	crcWriter := crc32.NewIEEE()
 	for {
@@ -300,12 +424,14 @@ func (z *Reader) WriteTo(w io.Writer) (int64, error) {
 		n, err := z.decompressor.(io.WriterTo).WriteTo(mw)
 		total += n
 		z.size += uint32(n)
+		z.out += int64(n)
 		if err != nil {
 			z.err = err
 			return total, z.err
 		}
 
 		// Finished file; check checksum + size.
+		z.unreadAhead()
 		if _, err := io.ReadFull(z.r, z.buf[0:8]); err != nil {
 			if err == io.EOF {
 				err = io.ErrUnexpectedEOF
@@ -316,7 +442,7 @@ func (z *Reader) WriteTo(w io.Writer) (int64, error) {
 		z.digest = crcWriter.Sum32()
 		digest := le.Uint32(z.buf[:4])
 		size := le.Uint32(z.buf[4:8])
-		if digest != z.digest || size != z.size {
+		if (digest != z.digest || size != z.size) && !z.gap {
 			z.err = ErrChecksum
 			return total, z.err
 		}
@@ -341,4 +467,15 @@ func (z *Reader) WriteTo(w io.Writer) (int64, error) {
 // Close closes the Reader. It does not close the underlying io.Reader.
 // In order for the GZIP checksum to be verified, the reader must be
 // fully consumed until the io.EOF.
-func (z *Reader) Close() error { return z.decompressor.Close() }
+func (z *Reader) Close() error {
+	if z.ra != nil {
+		z.ra.stop()
+		if z.err == nil {
+			z.err = errReaderClosed
+		}
+		if z.decompressor == nil {
+			return nil
+		}
+	}
+	return z.decompressor.Close()
+}
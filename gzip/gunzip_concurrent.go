@@ -0,0 +1,311 @@
+package gzip
+
+import (
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const (
+	defaultReadAheadBlockSize = 1 << 20
+	defaultReadAheadBlocks    = 4
+)
+
+// readAhead contains the state of a concurrent Reader.
+type readAhead struct {
+	blockSize int
+	blocks    int
+	workers   int
+
+	started bool
+	out     chan raBlock
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+	bufs    sync.Pool
+
+	// Set when members are decoded in parallel.
+	in      *raInput
+	members sync.Pool
+
+	// Current block being read.
+	cur    []byte
+	curBuf []byte
+}
+
+// raBlock is a decoded block or a member trailer.
+type raBlock struct {
+	b   []byte
+	err error
+
+	// If trailer is set, digest and size contain the values
+	// stored at the end of a member.
+	// If gap is set data was lost and they are not checked.
+	trailer bool
+	gap     bool
+	digest  uint32
+	size    uint32
+
+	// For members decoded in parallel, the first block contains
+	// the member header and the trailer contains the input offset
+	// after the member. The checksum has already been verified.
+	hdr *Header
+	end int64
+}
+
+// NewReaderConcurrent creates a new Reader reading the given reader,
+// which decompresses on background goroutines.
+//
+// Decompression is done in blocks of blockSize bytes, and up to blocks
+// decompressed blocks are kept ahead of the caller.
+// CRC calculation is done on a separate goroutine,
+// so reading, decompression and checksum verification overlap.
+// If blockSize <= 0 a block size of 1MB is used.
+// If blocks <= 0 4 blocks are used.
+//
+// When reading multistream files, members are decompressed in parallel
+// on up to GOMAXPROCS goroutines, each also verifying the checksum of its member.
+// Since member boundaries are only known after decompressing a member,
+// decompression is started at every possible member header found in the input
+// read ahead, and output is only used when the previous member ends there.
+// Output is returned in order.
+// Up to GOMAXPROCS times blockSize bytes of input is read ahead.
+// If Multistream is disabled, recovery is enabled or GOMAXPROCS is 1,
+// members are decompressed one at the time.
+//
+// Multistream and SetRecovery must be called before the first Read.
+//
+// It is the caller's responsibility to call Close on the Reader when done,
+// to stop the background goroutines.
+// Close waits for the goroutines to exit, including a Read on r in progress.
+//
+// The Reader.Header fields will be valid in the Reader returned.
+func NewReaderConcurrent(r io.Reader, blockSize, blocks int) (*Reader, error) {
+	if blockSize <= 0 {
+		blockSize = defaultReadAheadBlockSize
+	}
+	if blocks <= 0 {
+		blocks = defaultReadAheadBlocks
+	}
+	z := new(Reader)
+	z.ra = &readAhead{
+		blockSize: blockSize,
+		blocks:    blocks,
+		workers:   runtime.GOMAXPROCS(0),
+	}
+	z.ra.bufs.New = func() interface{} {
+		return make([]byte, blockSize)
+	}
+	if err := z.Reset(r); err != nil {
+		z.Close()
+		return nil, err
+	}
+	return z, nil
+}
+
+// stop signals background goroutines to stop and waits for them to exit.
+func (ra *readAhead) stop() {
+	if !ra.started {
+		return
+	}
+	if ra.closeCh != nil {
+		close(ra.closeCh)
+		ra.closeCh = nil
+	}
+	if ra.in != nil {
+		ra.in.close()
+	}
+	ra.wg.Wait()
+	ra.started = false
+}
+
+// reset prepares the read-ahead for a new stream.
+// Any running goroutines must be stopped.
+func (ra *readAhead) reset() {
+	ra.started = false
+	ra.out = nil
+	ra.in = nil
+	ra.cur, ra.curBuf = nil, nil
+}
+
+// startReadAhead starts the background goroutines.
+func (z *Reader) startReadAhead() {
+	ra := z.ra
+	ra.started = true
+	ra.closeCh = make(chan struct{})
+	ra.out = make(chan raBlock, ra.blocks)
+	if z.multistream && z.recoverFn == nil && ra.workers > 1 {
+		base := int64(-1)
+		if z.cr != nil {
+			base = z.cr.n
+		}
+		ahead := ra.blockSize
+		if ahead < raChunkSize {
+			ahead = raChunkSize
+		}
+		ra.in = newRAInput(int64(ra.workers) * int64(ahead))
+		ra.wg.Add(1)
+		go z.decodeMembers(base, ra.closeCh)
+		return
+	}
+	decoded := make(chan raBlock, ra.blocks)
+	ra.wg.Add(2)
+	go z.decodeAhead(decoded, ra.closeCh)
+	go ra.checkCRC(decoded, ra.out, ra.closeCh)
+}
+
+// decodeAhead decompresses blocks and sends them to out.
+// Member trailers are sent as separate blocks.
+// When an error occurs it is sent and the function returns.
+func (z *Reader) decodeAhead(out chan<- raBlock, closeCh <-chan struct{}) {
+	ra := z.ra
+	defer ra.wg.Done()
+	send := func(b raBlock) bool {
+		select {
+		case out <- b:
+			return true
+		case <-closeCh:
+			return false
+		}
+	}
+	var trailer [8]byte
+	for {
+		buf := ra.bufs.Get().([]byte)[:ra.blockSize]
+		var n int
+		var err error
+		for n < len(buf) && err == nil {
+			var n2 int
+			n2, err = z.decompressor.Read(buf[n:])
+			n += n2
+		}
+		z.out += int64(n)
+		if n > 0 {
+			if !send(raBlock{b: buf[:n]}) {
+				return
+			}
+		} else {
+			ra.bufs.Put(buf)
+		}
+		if err == nil {
+			continue
+		}
+		if err != io.EOF {
+			send(raBlock{err: err})
+			return
+		}
+
+		// Finished member; read checksum and size.
+		z.unreadAhead()
+		if _, err := io.ReadFull(z.r, trailer[:]); err != nil {
+			send(raBlock{err: noEOF(err)})
+			return
+		}
+		if !send(raBlock{trailer: true, gap: z.gap, digest: le.Uint32(trailer[:4]), size: le.Uint32(trailer[4:8])}) {
+			return
+		}
+		if !z.multistream {
+			send(raBlock{err: io.EOF})
+			return
+		}
+		if _, err := z.readHeader(); err != nil {
+			send(raBlock{err: err})
+			return
+		}
+	}
+}
+
+// checkCRC calculates checksums of blocks from in and forwards them to out.
+// Trailers are checked and not forwarded.
+func (ra *readAhead) checkCRC(in <-chan raBlock, out chan<- raBlock, closeCh <-chan struct{}) {
+	defer ra.wg.Done()
+	var digest, size uint32
+	for {
+		var b raBlock
+		select {
+		case b = <-in:
+		case <-closeCh:
+			return
+		}
+		if b.trailer {
+			if (b.digest != digest || b.size != size) && !b.gap {
+				b = raBlock{err: ErrChecksum}
+			} else {
+				digest, size = 0, 0
+				continue
+			}
+		}
+		if len(b.b) > 0 {
+			digest = crc32.Update(digest, crc32.IEEETable, b.b)
+			size += uint32(len(b.b))
+		}
+		select {
+		case out <- b:
+		case <-closeCh:
+			return
+		}
+		if b.err != nil {
+			return
+		}
+	}
+}
+
+// nextBlock will make the next decoded block current.
+// Returns an error if no more blocks are available.
+func (z *Reader) nextBlock() error {
+	if z.err != nil {
+		return z.err
+	}
+	ra := z.ra
+	if !ra.started {
+		z.startReadAhead()
+	}
+	if ra.curBuf != nil {
+		ra.bufs.Put(ra.curBuf)
+		ra.cur, ra.curBuf = nil, nil
+	}
+	b := <-ra.out
+	if b.err != nil {
+		z.err = b.err
+		return b.err
+	}
+	ra.cur, ra.curBuf = b.b, b.b[:cap(b.b)]
+	return nil
+}
+
+// readConcurrent reads from the background decoder.
+func (z *Reader) readConcurrent(p []byte) (n int, err error) {
+	ra := z.ra
+	for len(ra.cur) == 0 {
+		if err := z.nextBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, ra.cur)
+	ra.cur = ra.cur[n:]
+	return n, nil
+}
+
+// writeToConcurrent writes all remaining data from the background decoder to w.
+func (z *Reader) writeToConcurrent(w io.Writer) (n int64, err error) {
+	ra := z.ra
+	for {
+		if len(ra.cur) > 0 {
+			n2, err := w.Write(ra.cur)
+			n += int64(n2)
+			if err == nil && n2 != len(ra.cur) {
+				err = io.ErrShortWrite
+			}
+			ra.cur = ra.cur[n2:]
+			if err != nil {
+				z.err = err
+				return n, err
+			}
+		}
+		if err := z.nextBlock(); err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package gzip
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"sync"
+)
+
+const (
+	// raChunkSize is the size of the chunks input is read into when
+	// members are decoded in parallel.
+	raChunkSize = 256 << 10
+	// raBufSize is the buffer size of each member decoder.
+	raBufSize = 32 << 10
+)
+
+// errMemberCanceled is returned by raSource when decoding of a member is canceled.
+var errMemberCanceled = errors.New("gzip: member decoding canceled")
+
+// raInput is input shared by member decoders running in parallel.
+//
+// Input is kept from the lowest offset any decoder or candidate may need.
+// Offsets are relative to the start of the deflate data of the first member.
+type raInput struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	chunks [][]byte // Input read, chunks[0] starts at offset start.
+	free   [][]byte // Chunks that can be reused.
+	start  int64    // Offset of chunks[0].
+	end    int64    // Offset after the input read.
+	err    error    // Error from reading input, io.EOF at the end of input.
+	closed bool
+	done   chan struct{} // Closed by close.
+
+	maxAhead int64                  // Input to read ahead of cur.
+	waiting  int                    // Number of sources waiting for input.
+	cur      *raSource              // Source of the member being output.
+	srcs     map[*raSource]struct{} // Sources that are not closed.
+
+	// cands contains offsets of possible member headers, in order.
+	// notify is signaled when cands are added.
+	cands  []int64
+	notify chan struct{}
+}
+
+func newRAInput(maxAhead int64) *raInput {
+	in := &raInput{
+		maxAhead: maxAhead,
+		srcs:     make(map[*raSource]struct{}),
+		done:     make(chan struct{}),
+		notify:   make(chan struct{}, 1),
+	}
+	in.cond.L = &in.mu
+	return in
+}
+
+// close stops reading input and cancels all sources.
+func (in *raInput) close() {
+	in.mu.Lock()
+	if !in.closed {
+		in.closed = true
+		close(in.done)
+	}
+	in.cond.Broadcast()
+	in.mu.Unlock()
+}
+
+// wantInput returns whether more input should be read.
+// in.mu must be held.
+func (in *raInput) wantInput() bool {
+	if in.err != nil {
+		return false
+	}
+	return in.waiting > 0 || (in.cur != nil && in.end < in.cur.pos+in.maxAhead)
+}
+
+// fill reads r until the end of input or until in is closed.
+// Offsets of possible member headers are added to in.cands.
+func (in *raInput) fill(r io.Reader) {
+	var prev []byte
+	var found []int64
+	for {
+		in.mu.Lock()
+		for !in.closed && !in.wantInput() {
+			in.cond.Wait()
+		}
+		if in.closed {
+			in.mu.Unlock()
+			return
+		}
+		if len(in.chunks) == 0 || len(in.chunks[len(in.chunks)-1]) == raChunkSize {
+			var c []byte
+			if len(in.free) > 0 {
+				c = in.free[len(in.free)-1]
+				in.free = in.free[:len(in.free)-1]
+			} else {
+				c = make([]byte, 0, raChunkSize)
+			}
+			in.chunks = append(in.chunks, c)
+		}
+		last := in.chunks[len(in.chunks)-1]
+		offset := in.end
+		in.mu.Unlock()
+
+		// Only this goroutine writes after the end of the last chunk.
+		n, err := r.Read(last[len(last):cap(last)])
+		found = found[:0]
+		prev = findHeaders(prev, last[len(last):len(last)+n], offset, func(off int64) {
+			found = append(found, off)
+		})
+
+		in.mu.Lock()
+		in.chunks[len(in.chunks)-1] = last[:len(last)+n]
+		in.end += int64(n)
+		if err != nil {
+			in.err = err
+		}
+		for _, off := range found {
+			if off > 0 {
+				in.cands = append(in.cands, off)
+			}
+		}
+		in.trim()
+		in.cond.Broadcast()
+		in.mu.Unlock()
+		if len(found) > 0 {
+			select {
+			case in.notify <- struct{}{}:
+			default:
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// passed returns the offset before which no member can start,
+// since the current member has consumed the input.
+// in.mu must be held.
+func (in *raInput) passed() int64 {
+	if in.cur == nil {
+		return 0
+	}
+	// The decoder may have buffered up to raBufSize bytes.
+	p := in.cur.pos - raBufSize
+	if p <= in.cur.start {
+		return in.cur.start + 1
+	}
+	return p
+}
+
+// trim drops candidates inside the current member and
+// chunks that are no longer needed.
+// in.mu must be held.
+func (in *raInput) trim() {
+	passed := in.passed()
+	i := 0
+	for i < len(in.cands) && in.cands[i] < passed {
+		i++
+	}
+	in.cands = in.cands[i:]
+
+	floor := int64(math.MaxInt64)
+	if len(in.cands) > 0 {
+		floor = in.cands[0]
+	}
+	for s := range in.srcs {
+		p := s.pos - raBufSize
+		if p < s.start {
+			p = s.start
+		}
+		if p < floor {
+			floor = p
+		}
+	}
+	for len(in.chunks) > 1 && in.start+int64(len(in.chunks[0])) <= floor {
+		in.start += int64(len(in.chunks[0]))
+		in.free = append(in.free, in.chunks[0][:0])
+		in.chunks[0] = nil
+		in.chunks = in.chunks[1:]
+	}
+}
+
+// newSource returns a source reading from offset start.
+// in.mu must be held.
+func (in *raInput) newSource(start int64) *raSource {
+	s := &raSource{in: in, start: start, pos: start}
+	in.srcs[s] = struct{}{}
+	return s
+}
+
+// candidate returns a source for the first candidate, if any.
+func (in *raInput) candidate() *raSource {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	passed := in.passed()
+	for len(in.cands) > 0 {
+		off := in.cands[0]
+		in.cands = in.cands[1:]
+		if off >= passed {
+			return in.newSource(off)
+		}
+	}
+	return nil
+}
+
+// setCurrent sets the source of the member being output.
+// Candidates before the start of the member are dropped.
+func (in *raInput) setCurrent(s *raSource) {
+	in.mu.Lock()
+	in.cur = s
+	in.trim()
+	in.cond.Broadcast()
+	in.mu.Unlock()
+}
+
+// findHeaders calls fn with the offset of each possible member header in b.
+// prev must contain up to 3 bytes preceding b, which is at offset.
+// The bytes to use as prev with the following input are returned.
+func findHeaders(prev, b []byte, offset int64, fn func(off int64)) []byte {
+	isHeader := func(h []byte) bool {
+		return h[0] == gzipID1 && h[1] == gzipID2 && h[2] == gzipDeflate && h[3]&0xe0 == 0
+	}
+	// Headers starting in prev.
+	w := append(prev, b[:minInt(len(b), 3)]...)
+	for i := 0; i < len(prev) && i+4 <= len(w); i++ {
+		if isHeader(w[i:]) {
+			fn(offset - int64(len(prev)) + int64(i))
+		}
+	}
+	for i := 0; i+4 <= len(b); i++ {
+		j := bytes.IndexByte(b[i:len(b)-3], gzipID1)
+		if j < 0 {
+			break
+		}
+		i += j
+		if isHeader(b[i:]) {
+			fn(offset + int64(i))
+		}
+	}
+	if len(b) >= 3 {
+		return append(w[:0], b[len(b)-3:]...)
+	}
+	if len(w) > 3 {
+		w = append(w[:0], w[len(w)-3:]...)
+	}
+	return w
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// raSource reads input of a single member from a raInput.
+type raSource struct {
+	in       *raInput
+	start    int64 // Offset of the member.
+	pos      int64 // Offset of the next byte to read.
+	canceled bool
+}
+
+// Read implements io.Reader.
+// It blocks until input is available.
+func (s *raSource) Read(p []byte) (int, error) {
+	in := s.in
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for s.pos == in.end && in.err == nil && !in.closed && !s.canceled {
+		in.waiting++
+		in.cond.Broadcast()
+		in.cond.Wait()
+		in.waiting--
+	}
+	if in.closed || s.canceled {
+		return 0, errMemberCanceled
+	}
+	if s.pos == in.end {
+		return 0, in.err
+	}
+	off := s.pos - in.start
+	n := 0
+	for _, c := range in.chunks {
+		if off >= int64(len(c)) {
+			off -= int64(len(c))
+			continue
+		}
+		n = copy(p, c[off:])
+		break
+	}
+	s.pos += int64(n)
+	if s == in.cur {
+		// Allow more input to be read ahead.
+		in.cond.Broadcast()
+	}
+	return n, nil
+}
+
+// close cancels reads and releases the input held for the source.
+func (s *raSource) close() {
+	in := s.in
+	in.mu.Lock()
+	s.canceled = true
+	delete(in.srcs, s)
+	in.cond.Broadcast()
+	in.mu.Unlock()
+}
+
+// raMember is a member decoded on its own goroutine.
+type raMember struct {
+	src  *raSource
+	out  chan raBlock
+	quit chan struct{}
+}
+
+// cancel stops decoding of the member.
+func (m *raMember) cancel() {
+	close(m.quit)
+	m.src.close()
+}
+
+// memberDecoder decodes single members.
+type memberDecoder struct {
+	br *bufio.Reader
+	z  Reader
+}
+
+// startMember starts decoding the member read by src.
+// If header is false, the member header has already been read.
+func (ra *readAhead) startMember(src *raSource, header bool, closeCh <-chan struct{}) *raMember {
+	m := &raMember{
+		src:  src,
+		out:  make(chan raBlock, ra.blocks),
+		quit: make(chan struct{}),
+	}
+	ra.wg.Add(1)
+	go ra.decodeMember(m, header, closeCh)
+	return m
+}
+
+// decodeMember decodes a member and sends the blocks to m.out.
+// If header is set, the first block contains the member header.
+// The last block is a trailer containing the offset after the member,
+// or an error.
+func (ra *readAhead) decodeMember(m *raMember, header bool, closeCh <-chan struct{}) {
+	defer ra.wg.Done()
+	send := func(b raBlock) bool {
+		select {
+		case m.out <- b:
+			return true
+		case <-m.quit:
+		case <-closeCh:
+		}
+		return false
+	}
+	md, _ := ra.members.Get().(*memberDecoder)
+	if md == nil {
+		md = &memberDecoder{br: bufio.NewReaderSize(m.src, raBufSize)}
+	} else {
+		md.br.Reset(m.src)
+	}
+	defer ra.members.Put(md)
+	md.z = Reader{r: md.br, decompressor: md.z.decompressor}
+	z := &md.z
+	if header {
+		hdr, err := z.readHeader()
+		if err != nil {
+			send(raBlock{err: err})
+			return
+		}
+		if !send(raBlock{hdr: &hdr}) {
+			return
+		}
+	} else {
+		z.resetDecompressor()
+	}
+	for {
+		buf := ra.bufs.Get().([]byte)[:ra.blockSize]
+		var n int
+		var err error
+		for n < len(buf) && err == nil {
+			var n2 int
+			n2, err = z.Read(buf[n:])
+			n += n2
+		}
+		if n > 0 {
+			if !send(raBlock{b: buf[:n]}) {
+				return
+			}
+		} else {
+			ra.bufs.Put(buf)
+		}
+		if err == io.EOF {
+			// The checksum has been verified by z.
+			send(raBlock{trailer: true, end: m.src.pos - int64(md.br.Buffered())})
+			return
+		}
+		if err != nil {
+			send(raBlock{err: err})
+			return
+		}
+	}
+}
+
+// decodeMembers decodes members on up to ra.workers goroutines
+// and sends the decoded blocks to ra.out in order.
+//
+// Since the end of a member is only known when it has been decoded,
+// decoding starts at every possible member header found in the input.
+// A decoder is only used when the previous member ends where it started;
+// otherwise it is canceled.
+func (z *Reader) decodeMembers(base int64, closeCh <-chan struct{}) {
+	ra := z.ra
+	in := ra.in
+	defer ra.wg.Done()
+
+	var cur *raMember
+	var live []*raMember
+	defer func() {
+		cur.cancel()
+		for _, m := range live {
+			m.cancel()
+		}
+		in.close()
+	}()
+	send := func(b raBlock) bool {
+		select {
+		case ra.out <- b:
+			return true
+		case <-closeCh:
+			return false
+		}
+	}
+
+	in.mu.Lock()
+	src := in.newSource(0)
+	in.mu.Unlock()
+	cur = ra.startMember(src, false, closeCh)
+	in.setCurrent(cur.src)
+	ra.wg.Add(1)
+	go func() {
+		defer ra.wg.Done()
+		in.fill(z.r)
+	}()
+
+	for {
+		for len(live) < ra.workers-1 {
+			src := in.candidate()
+			if src == nil {
+				break
+			}
+			live = append(live, ra.startMember(src, true, closeCh))
+		}
+
+		var b raBlock
+		select {
+		case b = <-cur.out:
+		case <-in.notify:
+			continue
+		case <-closeCh:
+			return
+		}
+		switch {
+		case b.hdr != nil:
+			if z.memberFn != nil {
+				offset := int64(-1)
+				if base >= 0 {
+					offset = base + cur.src.start
+				}
+				z.memberFn(Member{Header: *b.hdr, Offset: offset, UncompressedOffset: z.out})
+			}
+			continue
+		case !b.trailer:
+			z.out += int64(len(b.b))
+			if !send(b) || b.err != nil {
+				return
+			}
+			// Cancel decoders that started inside the current member.
+			in.mu.Lock()
+			passed := in.passed()
+			in.mu.Unlock()
+			for len(live) > 0 && live[0].src.start < passed {
+				live[0].cancel()
+				live = live[1:]
+			}
+			continue
+		}
+
+		// The member has ended; continue with the member starting at b.end.
+		var next *raMember
+		keep := live[:0]
+		for _, m := range live {
+			switch {
+			case m.src.start == b.end:
+				next = m
+			case m.src.start < b.end:
+				m.cancel()
+			default:
+				keep = append(keep, m)
+			}
+		}
+		live = keep
+		if next == nil {
+			in.mu.Lock()
+			src := in.newSource(b.end)
+			in.mu.Unlock()
+			next = ra.startMember(src, true, closeCh)
+		}
+		cur.cancel()
+		cur = next
+		in.setCurrent(cur.src)
+	}
+}
@@ -0,0 +1,116 @@
+package gzip
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// SetRecovery enables recovery from corrupt compressed data.
+// When the deflate data of a member is corrupt, decompression continues
+// at the next plausible block, as described for flate.RecoveryReader,
+// and fn is called with each gap in the output.
+// The offsets of the gaps are relative to the start of the deflate data of the member.
+// The checksum and size of members with gaps are not verified.
+// Corrupt headers and trailers are not recovered.
+//
+// Since recovery reads ahead, the position of the underlying reader
+// after a stream with gaps is not exact when Multistream is disabled.
+//
+// SetRecovery must be called before the first Read.
+// Calling SetRecovery with nil disables recovery.
+// For Readers created with NewReaderConcurrent the callback
+// is called on a background goroutine.
+// The setting is retained when the Reader is Reset.
+func (z *Reader) SetRecovery(fn func(flate.RecoveryGap)) {
+	z.recoverFn = fn
+	if z.decompressor != nil && z.r != nil {
+		z.resetDecompressor()
+	}
+}
+
+// recovered is called by the decompressor with each gap.
+func (z *Reader) recovered(gap flate.RecoveryGap) {
+	z.gap = true
+	if z.recoverFn != nil {
+		z.recoverFn(gap)
+	}
+}
+
+// resetDecompressor resets or creates the decompressor to read z.r.
+func (z *Reader) resetDecompressor() {
+	z.gap = false
+	switch d := z.decompressor.(type) {
+	case *flate.RecoveryReader:
+		if z.recoverFn != nil {
+			d.Reset(z.r, nil)
+			return
+		}
+	case flate.Resetter:
+		if z.recoverFn == nil {
+			d.Reset(z.r, nil)
+			return
+		}
+	}
+	if z.recoverFn != nil {
+		z.decompressor = flate.NewRecoveryReader(z.r, z.recovered)
+	} else {
+		z.decompressor = flate.NewReader(z.r)
+	}
+}
+
+// unreadAhead returns input read ahead by a recovering decompressor
+// to z.r, so the trailer and following members can be read.
+func (z *Reader) unreadAhead() {
+	d, ok := z.decompressor.(*flate.RecoveryReader)
+	if !ok {
+		return
+	}
+	for {
+		p, ok := z.r.(*prefixReader)
+		if !ok || len(p.buf) > 0 {
+			break
+		}
+		z.r = p.r
+	}
+	if b := d.ReadAhead(); len(b) > 0 {
+		z.r = &prefixReader{buf: append([]byte(nil), b...), r: z.r}
+	}
+}
+
+// prefixReader returns buf before reading from r.
+type prefixReader struct {
+	buf []byte
+	r   flate.Reader
+}
+
+func (p *prefixReader) Read(b []byte) (int, error) {
+	if len(p.buf) == 0 {
+		return p.r.Read(b)
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+func (p *prefixReader) ReadByte() (byte, error) {
+	if len(p.buf) == 0 {
+		return p.r.ReadByte()
+	}
+	b := p.buf[0]
+	p.buf = p.buf[1:]
+	return b, nil
+}
+
+// buffered returns the number of bytes buffered by prefixReaders in r.
+func buffered(r io.Reader) int64 {
+	var n int64
+	for {
+		p, ok := r.(*prefixReader)
+		if !ok {
+			return n
+		}
+		n += int64(len(p.buf))
+		r = p.r
+	}
+}
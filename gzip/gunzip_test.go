@@ -5,14 +5,18 @@
 package gzip
 
 import (
+	"bufio"
 	"bytes"
 	oldgz "compress/gzip"
 	"crypto/rand"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/klauspost/compress/flate"
@@ -714,3 +718,480 @@ func TestTruncatedGunzip(t *testing.T) {
 		}
 	}
 }
+
+func TestDecompressorConcurrent(t *testing.T) {
+	b := new(bytes.Buffer)
+	for _, tt := range gunzipTests {
+		in := bytes.NewReader(tt.gzip)
+		gzip, err := NewReaderConcurrent(in, 5, 2)
+		if err != nil {
+			t.Errorf("%s: NewReaderConcurrent: %s", tt.name, err)
+			continue
+		}
+		if tt.name != gzip.Name {
+			t.Errorf("%s: got name %s", tt.name, gzip.Name)
+		}
+		for _, useWriteTo := range []bool{false, true} {
+			b.Reset()
+			var n int64
+			if useWriteTo {
+				n, err = gzip.WriteTo(b)
+			} else {
+				// Prevent io.Copy from using WriteTo.
+				n, err = io.Copy(b, struct{ io.Reader }{gzip})
+			}
+			if err != tt.err {
+				t.Errorf("%s: copy: %v want %v", tt.name, err, tt.err)
+			}
+			s := b.String()
+			if s != tt.raw {
+				t.Errorf("%s: got %d-byte %q want %d-byte %q", tt.name, n, s, len(tt.raw), tt.raw)
+			}
+			// Test Reader Reset.
+			if err := gzip.Reset(bytes.NewReader(tt.gzip)); err != nil {
+				t.Errorf("%s: Reset: %s", tt.name, err)
+				break
+			}
+		}
+		gzip.Close()
+	}
+}
+
+func TestReaderConcurrentMultistream(t *testing.T) {
+	input := make([]byte, 100000)
+	if _, err := rand.Read(input); err != nil {
+		t.Fatal(err)
+	}
+	// Create 10 members of mixed compressible and random data.
+	var compressed, want bytes.Buffer
+	for i := 0; i < 10; i++ {
+		w := NewWriter(&compressed)
+		w.Write(input[:i*1000])
+		w.Write(bytes.Repeat([]byte("hello gzip"), i*1000))
+		w.Close()
+		want.Write(input[:i*1000])
+		want.Write(bytes.Repeat([]byte("hello gzip"), i*1000))
+	}
+	r, err := NewReaderConcurrent(bytes.NewReader(compressed.Bytes()), 10000, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Fatal("output mismatch")
+	}
+
+	// Single member.
+	br := bytes.NewReader(compressed.Bytes())
+	if err := r.Reset(br); err != nil {
+		t.Fatal(err)
+	}
+	r.Multistream(false)
+	got, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("got %d bytes from first member, want 0", len(got))
+	}
+
+	// Corrupt the checksum of the last member.
+	b := append([]byte{}, compressed.Bytes()...)
+	b[len(b)-5] ^= 0xff
+	if err := r.Reset(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(r); err != ErrChecksum {
+		t.Fatalf("got error %v, want %v", err, ErrChecksum)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 10)); err == nil {
+		t.Fatal("want error reading after Close")
+	}
+}
+
+func TestReaderConcurrentMembers(t *testing.T) {
+	random := make([]byte, 800<<10)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	// A gzip stream stored uncompressed in a member looks like
+	// another member to the parallel decoder.
+	var nested bytes.Buffer
+	w := NewWriter(&nested)
+	w.Write(bytes.Repeat([]byte("nested member "), 1000))
+	w.Close()
+
+	var compressed, want bytes.Buffer
+	for i := 0; i < 40; i++ {
+		var data []byte
+		level := DefaultCompression
+		switch i % 4 {
+		case 0:
+			data = random[:i*1000]
+		case 1:
+			data = bytes.Repeat([]byte("parallel members "), i*100)
+		case 2:
+			data = bytes.Repeat(nested.Bytes(), 1+i%3)
+			level = NoCompression
+		case 3:
+			data = random[:(i%8)*100<<10]
+		}
+		w, _ := NewWriterLevel(&compressed, level)
+		w.Write(data)
+		w.Close()
+		want.Write(data)
+	}
+
+	read := func(t *testing.T, r io.Reader, blockSize int, writeTo bool) ([]byte, error) {
+		t.Helper()
+		zr, err := NewReaderConcurrent(r, blockSize, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		// Decode in parallel regardless of GOMAXPROCS.
+		zr.ra.workers = 4
+		var got bytes.Buffer
+		if writeTo {
+			_, err = zr.WriteTo(&got)
+		} else {
+			_, err = io.Copy(&got, struct{ io.Reader }{zr})
+		}
+		return got.Bytes(), err
+	}
+	for _, blockSize := range []int{1000, 64 << 10, 0} {
+		for _, writeTo := range []bool{false, true} {
+			got, err := read(t, bytes.NewReader(compressed.Bytes()), blockSize, writeTo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want.Bytes()) {
+				t.Fatalf("block size %d: output mismatch", blockSize)
+			}
+		}
+	}
+
+	// Headers split between reads.
+	got, err := read(t, iotest.HalfReader(bytes.NewReader(compressed.Bytes()[:200<<10])), 0, true)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if !bytes.HasPrefix(want.Bytes(), got) {
+		t.Fatal("output mismatch")
+	}
+	tiny := compressed.Bytes()[:nested.Len()*4]
+	var tinyWant bytes.Buffer
+	if _, err := io.Copy(&tinyWant, mustReader(t, tiny)); err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+	got, err = read(t, iotest.OneByteReader(bytes.NewReader(tiny)), 100, false)
+	if err != io.ErrUnexpectedEOF || !bytes.Equal(got, tinyWant.Bytes()) {
+		t.Fatalf("one byte reads: got %d bytes, %v", len(got), err)
+	}
+
+	// Errors in later members are returned after the output before them.
+	// Find the end of the 10th member.
+	r := bytes.NewReader(compressed.Bytes())
+	var zr Reader
+	for i := 0; i < 10; i++ {
+		zr.Reset(r)
+		zr.Multistream(false)
+		io.Copy(ioutil.Discard, &zr)
+	}
+	end := compressed.Len() - r.Len()
+	b := append([]byte{}, compressed.Bytes()...)
+	b[end-5] ^= 0xff
+	if _, err := read(t, bytes.NewReader(b), 0, true); err != ErrChecksum {
+		t.Fatalf("got error %v, want %v", err, ErrChecksum)
+	}
+	b = append(append([]byte{}, compressed.Bytes()[:end]...), "garbage after member"...)
+	if _, err := read(t, bytes.NewReader(b), 0, true); err != ErrHeader {
+		t.Fatalf("got error %v, want %v", err, ErrHeader)
+	}
+}
+
+func TestReaderConcurrentClose(t *testing.T) {
+	data := make([]byte, 8<<20)
+	if _, err := rand.Read(data[:1<<20]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1 << 20; i < len(data); i++ {
+		data[i] = data[i%(1<<20)] ^ byte(i>>20)
+	}
+	var compressed bytes.Buffer
+	for i := 0; i < 4; i++ {
+		w := NewWriter(&compressed)
+		w.Write(data[i*len(data)/4 : (i+1)*len(data)/4])
+		w.Close()
+	}
+	for _, workers := range []int{1, 4} {
+		zr, err := NewReaderConcurrent(bytes.NewReader(compressed.Bytes()), 64<<10, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr.ra.workers = workers
+		got := make([]byte, 1000)
+		if _, err := io.ReadFull(zr, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data[:1000]) {
+			t.Fatal("output mismatch")
+		}
+		// Close while decoding is in progress.
+		if err := zr.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := zr.Read(got); err == nil {
+			t.Fatal("want error reading after Close")
+		}
+	}
+}
+
+func mustReader(t *testing.T, b []byte) *Reader {
+	t.Helper()
+	zr, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestFindHeaders(t *testing.T) {
+	data := []byte("xx\x1f\x8b\x08\x00yy\x1f\x8b\x08\xe0\x1f\x8b\x08\x04")
+	want := []int64{2, 12}
+	for split := 1; split <= len(data); split++ {
+		var got []int64
+		var prev []byte
+		for i := 0; i < len(data); i += split {
+			end := i + split
+			if end > len(data) {
+				end = len(data)
+			}
+			prev = findHeaders(prev, data[i:end], int64(i), func(off int64) {
+				got = append(got, off)
+			})
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("split %d: got %v, want %v", split, got, want)
+		}
+	}
+}
+
+func TestMemberCallback(t *testing.T) {
+	var compressed, want bytes.Buffer
+	var wantMembers []Member
+	for i := 0; i < 5; i++ {
+		w := NewWriter(&compressed)
+		w.Name = fmt.Sprintf("member-%d", i)
+		w.HeaderCRC = i%2 == 0
+		w.SetExtraFields(ExtraField{ID: [2]byte{'P', 'v'}, Data: []byte{byte(i)}})
+		wantMembers = append(wantMembers, Member{
+			Header:             Header{Name: w.Name, HeaderCRC: w.HeaderCRC},
+			Offset:             int64(compressed.Len()),
+			UncompressedOffset: int64(want.Len()),
+		})
+		data := bytes.Repeat([]byte("member data "), i*1000)
+		w.Write(data)
+		w.Close()
+		want.Write(data)
+	}
+
+	check := func(t *testing.T, got []Member) {
+		t.Helper()
+		if len(got) != len(wantMembers) {
+			t.Fatalf("got %d members, want %d", len(got), len(wantMembers))
+		}
+		for i, m := range got {
+			w := wantMembers[i]
+			if m.Name != w.Name || m.HeaderCRC != w.HeaderCRC || m.Offset != w.Offset || m.UncompressedOffset != w.UncompressedOffset {
+				t.Fatalf("member %d: got %q crc:%v at %d/%d, want %q crc:%v at %d/%d", i,
+					m.Name, m.HeaderCRC, m.Offset, m.UncompressedOffset,
+					w.Name, w.HeaderCRC, w.Offset, w.UncompressedOffset)
+			}
+			f, err := m.ExtraFields()
+			if err != nil || len(f) != 1 || f[0].Data[0] != byte(i) {
+				t.Fatalf("member %d: got extra fields %v, %v", i, f, err)
+			}
+		}
+	}
+
+	t.Run("sequential", func(t *testing.T) {
+		var got []Member
+		var r Reader
+		r.SetMemberCallback(func(m Member) {
+			got = append(got, m)
+		})
+		if err := r.Reset(bytes.NewReader(compressed.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, want.Bytes()) {
+			t.Fatal("output mismatch")
+		}
+		check(t, got)
+
+		// Reset retains the callback.
+		got = got[:0]
+		if err := r.Reset(bytes.NewReader(compressed.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.WriteTo(ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+		check(t, got)
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		r, err := NewReaderConcurrent(bytes.NewReader(compressed.Bytes()), 1000, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		var mu sync.Mutex
+		var got []Member
+		r.SetMemberCallback(func(m Member) {
+			mu.Lock()
+			got = append(got, m)
+			mu.Unlock()
+		})
+		if err := r.Reset(bytes.NewReader(compressed.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, want.Bytes()) {
+			t.Fatal("output mismatch")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		check(t, got)
+	})
+}
+
+func TestRecovery(t *testing.T) {
+	var compressed bytes.Buffer
+	var segs [][]byte
+	var memberOffsets []int64
+	var corruptAt int
+	for i := 0; i < 3; i++ {
+		memberOffsets = append(memberOffsets, int64(compressed.Len()))
+		w, _ := NewWriterLevel(&compressed, StatelessCompression)
+		for j := 0; j < 10; j++ {
+			var seg bytes.Buffer
+			for k := 0; k < 200; k++ {
+				fmt.Fprintf(&seg, "member %d, segment %d, line %d\n", i, j, k)
+			}
+			if i == 1 && j == 5 {
+				corruptAt = compressed.Len() + 100
+			}
+			w.Write(seg.Bytes())
+			segs = append(segs, seg.Bytes())
+		}
+		w.Close()
+	}
+	// Not all corruption is detected by the decoder.
+	// Find corruption in the member that causes a CorruptInputError.
+	var corrupt []byte
+	for ; ; corruptAt++ {
+		if corruptAt >= int(memberOffsets[2]) {
+			t.Fatal("no detected corruption found")
+		}
+		corrupt = append(corrupt[:0], compressed.Bytes()...)
+		for i := corruptAt; i < corruptAt+8; i++ {
+			corrupt[i] = 0xff
+		}
+		zr, err := NewReader(bytes.NewReader(corrupt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ioutil.ReadAll(zr)
+		if _, ok := err.(flate.CorruptInputError); ok {
+			break
+		}
+	}
+	head := bytes.Join(segs[:15], nil)
+	tail := bytes.Join(segs[17:], nil)
+
+	// members is nil if offsets are not checked.
+	check := func(t *testing.T, r *Reader, gaps *int, members *[]Member) {
+		t.Helper()
+		var out bytes.Buffer
+		// Uses WriteTo.
+		if _, err := io.Copy(&out, r); err != nil {
+			t.Fatal(err)
+		}
+		got := out.Bytes()
+		if *gaps != 1 {
+			t.Errorf("got %d gaps, want 1", *gaps)
+		}
+		if !bytes.HasPrefix(got, head) || !bytes.HasSuffix(got, tail) {
+			t.Error("data not recovered")
+		}
+		if members == nil {
+			return
+		}
+		if len(*members) != 3 {
+			t.Fatalf("got %d members, want 3", len(*members))
+		}
+		for i, m := range *members {
+			if m.Offset != memberOffsets[i] {
+				t.Errorf("member %d: offset %d, want %d", i, m.Offset, memberOffsets[i])
+			}
+		}
+	}
+
+	t.Run("sequential", func(t *testing.T) {
+		var gaps int
+		var members []Member
+		var r Reader
+		r.SetMemberCallback(func(m Member) { members = append(members, m) })
+		r.SetRecovery(func(g flate.RecoveryGap) { gaps++ })
+		if err := r.Reset(bytes.NewReader(corrupt)); err != nil {
+			t.Fatal(err)
+		}
+		check(t, &r, &gaps, &members)
+
+		// Read, without member offsets.
+		r.SetMemberCallback(nil)
+		if err := r.Reset(bufio.NewReader(bytes.NewReader(corrupt))); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(got, head) || !bytes.HasSuffix(got, tail) {
+			t.Error("data not recovered")
+		}
+
+		// Recovery can be disabled again.
+		r.SetRecovery(nil)
+		if err := r.Reset(bytes.NewReader(corrupt)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(&r); err == nil {
+			t.Fatal("corruption not detected")
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		var gaps int
+		r, err := NewReaderConcurrent(bytes.NewReader(corrupt), 1000, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		r.SetRecovery(func(g flate.RecoveryGap) { gaps++ })
+		check(t, r, &gaps, nil)
+	})
+}
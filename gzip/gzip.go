@@ -38,12 +38,17 @@ type Writer struct {
 	w           io.Writer
 	level       int
 	err         error
-	compressor  *flate.Writer
+	compressor  flate.WriteResetter
 	digest      uint32 // CRC-32, IEEE polynomial (section 8)
 	size        uint32 // Uncompressed size (section 2.3.1)
 	wroteHeader bool
 	closed      bool
 	buf         [10]byte
+	hdrDigest   uint32 // CRC-32 of header, if HeaderCRC is set.
+
+	// Parallel compression parameters, see SetConcurrency.
+	blockSize   int
+	concurrency int
 }
 
 // NewWriter returns a new Writer.
@@ -86,12 +91,43 @@ func (z *Writer) init(w io.Writer, level int) {
 		Header: Header{
 			OS: 255, // unknown
 		},
-		w:          w,
-		level:      level,
-		compressor: compressor,
+		w:           w,
+		level:       level,
+		compressor:  compressor,
+		blockSize:   z.blockSize,
+		concurrency: z.concurrency,
 	}
 }
 
+// SetConcurrency enables compressing the stream on multiple goroutines.
+// Input is split into blocks of blockSize bytes and up to blocks
+// blocks are compressed at once before Write will block.
+// The output is still a single regular gzip member.
+// If blockSize is <= 0 a block size of 1MB is used.
+// If blocks is < 0 runtime.GOMAXPROCS(0) is used.
+// Calling SetConcurrency with blocks == 0 disables concurrent compression,
+// which is the default.
+//
+// SetConcurrency must be called before the first call to Write, Flush, or Close.
+// The setting is kept when the Writer is Reset.
+func (z *Writer) SetConcurrency(blockSize, blocks int) error {
+	if z.wroteHeader {
+		return errors.New("gzip: SetConcurrency called after writing")
+	}
+	if z.level == StatelessCompression && blocks != 0 {
+		return errors.New("gzip: SetConcurrency cannot be used with StatelessCompression")
+	}
+	z.blockSize = blockSize
+	z.concurrency = blocks
+	// A new compressor is created on the first write.
+	if pw, ok := z.compressor.(*flate.ParallelWriter); ok {
+		// Stop background writer.
+		pw.Reset(nil)
+	}
+	z.compressor = nil
+	return nil
+}
+
 // Reset discards the Writer z's state and makes it equivalent to the
 // result of its original state from NewWriter or NewWriterLevel, but
 // writing to w instead. This permits reusing a Writer rather than
@@ -106,11 +142,17 @@ func (z *Writer) writeBytes(b []byte) error {
 		return errors.New("gzip.Write: Extra data is too large")
 	}
 	le.PutUint16(z.buf[:2], uint16(len(b)))
-	_, err := z.w.Write(z.buf[:2])
+	err := z.writeHeaderBytes(z.buf[:2])
 	if err != nil {
 		return err
 	}
-	_, err = z.w.Write(b)
+	return z.writeHeaderBytes(b)
+}
+
+// writeHeaderBytes writes b to z.w and adds it to the header checksum.
+func (z *Writer) writeHeaderBytes(b []byte) error {
+	z.hdrDigest = crc32.Update(z.hdrDigest, crc32.IEEETable, b)
+	_, err := z.w.Write(b)
 	return err
 }
 
@@ -132,8 +174,11 @@ func (z *Writer) writeString(s string) (err error) {
 		for _, v := range s {
 			b = append(b, byte(v))
 		}
-		_, err = z.w.Write(b)
+		err = z.writeHeaderBytes(b)
 	} else {
+		if z.HeaderCRC {
+			z.hdrDigest = crc32.Update(z.hdrDigest, crc32.IEEETable, []byte(s))
+		}
 		_, err = io.WriteString(z.w, s)
 	}
 	if err != nil {
@@ -141,8 +186,7 @@ func (z *Writer) writeString(s string) (err error) {
 	}
 	// GZIP strings are NUL-terminated.
 	z.buf[0] = 0
-	_, err = z.w.Write(z.buf[:1])
-	return err
+	return z.writeHeaderBytes(z.buf[:1])
 }
 
 // Write writes a compressed form of p to the underlying io.Writer. The
@@ -168,6 +212,9 @@ func (z *Writer) Write(p []byte) (int, error) {
 		if z.Comment != "" {
 			z.buf[3] |= 0x10
 		}
+		if z.HeaderCRC {
+			z.buf[3] |= 0x02
+		}
 		le.PutUint32(z.buf[4:8], uint32(z.ModTime.Unix()))
 		if z.level == BestCompression {
 			z.buf[8] = 2
@@ -177,6 +224,7 @@ func (z *Writer) Write(p []byte) (int, error) {
 			z.buf[8] = 0
 		}
 		z.buf[9] = z.OS
+		z.hdrDigest = crc32.ChecksumIEEE(z.buf[:10])
 		n, z.err = z.w.Write(z.buf[:10])
 		if z.err != nil {
 			return n, z.err
@@ -199,9 +247,21 @@ func (z *Writer) Write(p []byte) (int, error) {
 				return n, z.err
 			}
 		}
+		if z.HeaderCRC {
+			// The CRC-16 is the two least significant bytes of the CRC-32.
+			le.PutUint16(z.buf[:2], uint16(z.hdrDigest))
+			_, z.err = z.w.Write(z.buf[:2])
+			if z.err != nil {
+				return n, z.err
+			}
+		}
 
 		if z.compressor == nil && z.level != StatelessCompression {
-			z.compressor, _ = flate.NewWriter(z.w, z.level)
+			if z.concurrency != 0 {
+				z.compressor, _ = flate.NewParallelWriter(z.w, z.level, z.blockSize, z.concurrency)
+			} else {
+				z.compressor, _ = flate.NewWriter(z.w, z.level)
+			}
 		}
 	}
 	z.size += uint32(len(p))
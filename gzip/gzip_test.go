@@ -519,3 +519,134 @@ func benchmarkOldGzipN(b *testing.B, level int) {
 		}
 	}
 }
+
+func TestWriterConcurrency(t *testing.T) {
+	rand.Seed(1337)
+	in := make([]byte, 1<<20)
+	for idx := range in {
+		in[idx] = byte(65 + rand.Intn(8))
+	}
+	var buf bytes.Buffer
+	w, err := NewWriterLevel(&buf, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetConcurrency(100000, 4); err != nil {
+		t.Fatal(err)
+	}
+	w.Name = "concurrent"
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		if _, err := w.Write(in[:len(in)/2]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetConcurrency(0, 0); err == nil {
+			t.Fatal("want error calling SetConcurrency after Write")
+		}
+		if _, err := w.Write(in[len(in)/2:]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify with the standard library decoder.
+		r, err := oldgz.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Fatal("decoded content does not match")
+		}
+		w.Reset(&buf)
+	}
+
+	w, _ = NewWriterLevel(&buf, StatelessCompression)
+	if err := w.SetConcurrency(0, 4); err == nil {
+		t.Fatal("want error with StatelessCompression")
+	}
+}
+
+func TestHeaderCRCExtraFields(t *testing.T) {
+	fields := []ExtraField{
+		{ID: [2]byte{'A', 'p'}, Data: []byte("apollo")},
+		{ID: [2]byte{'B', 'C'}, Data: []byte{0x12, 0x34}},
+		{ID: [2]byte{'E', 'm'}},
+	}
+	var h Header
+	if err := h.SetExtraFields(ExtraField{ID: [2]byte{'A', 0}}); err == nil {
+		t.Fatal("want error for reserved ID")
+	}
+	if err := h.SetExtraFields(ExtraField{ID: [2]byte{'A', 'B'}, Data: make([]byte, 0x10000)}); err == nil {
+		t.Fatal("want error for large field")
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.Name = "näme"
+	w.Comment = "comment"
+	w.HeaderCRC = true
+	if err := w.SetExtraFields(fields...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	compressed := buf.Bytes()
+
+	// The standard library verifies the header CRC.
+	or, err := oldgz.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(or); err != nil || string(b) != "payload" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+
+	r, err := NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.HeaderCRC {
+		t.Fatal("HeaderCRC not set")
+	}
+	if r.Name != w.Name || r.Comment != w.Comment {
+		t.Fatalf("got name %q, comment %q", r.Name, r.Comment)
+	}
+	got, err := r.ExtraFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(fields) {
+		t.Fatalf("got %d fields, want %d", len(got), len(fields))
+	}
+	for i, f := range got {
+		if f.ID != fields[i].ID || !bytes.Equal(f.Data, fields[i].Data) {
+			t.Fatalf("field %d: got %v, want %v", i, f, fields[i])
+		}
+	}
+
+	// Corrupt the header CRC.
+	b := append([]byte{}, compressed...)
+	crcPos := 10 + 2 + len(r.Extra) + len("näme") + 1 + len("comment") + 1
+	b[crcPos] ^= 1
+	if _, err := NewReader(bytes.NewReader(b)); err != ErrHeader {
+		t.Fatalf("got %v, want %v", err, ErrHeader)
+	}
+
+	// Truncated extra data.
+	r.Extra = r.Extra[:len(r.Extra)-1]
+	if _, err := r.ExtraFields(); err != ErrHeader {
+		t.Fatalf("got %v, want %v", err, ErrHeader)
+	}
+}
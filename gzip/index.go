@@ -0,0 +1,206 @@
+package gzip
+
+import (
+	"bufio"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// defaultIndexSpan is the default distance between index points.
+const defaultIndexSpan = 1 << 20
+
+// IndexPoint is a position in a gzip file where decompression can start.
+type IndexPoint struct {
+	In     int64  // Offset of the deflate block in the compressed file, in bits.
+	Out    int64  // Offset of the point in the uncompressed data.
+	Window []byte // Up to 32KB of uncompressed data preceding the point.
+}
+
+// Index contains points that allow random access to a gzip file.
+// Create it with BuildIndex.
+type Index struct {
+	Points []IndexPoint // Sorted by offset.
+	Size   int64        // Total uncompressed size.
+}
+
+// countReader counts the bytes read.
+type countReader struct {
+	r flate.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// BuildIndex decompresses all of r and records points where decompression
+// can be resumed, roughly every span uncompressed bytes.
+// Each point holds a copy of the 32KB window preceding it.
+// A point is also added at the start of every member of multistream files.
+// If span is <= 0 a span of 1MB is used.
+//
+// All checksums are verified while building the index.
+func BuildIndex(r io.Reader, span int64) (*Index, error) {
+	if span <= 0 {
+		span = defaultIndexSpan
+	}
+	cr := &countReader{r: bufio.NewReaderSize(r, 64<<10)}
+	idx := &Index{}
+
+	// Start of current member.
+	var inStart, outStart int64
+	lastOut := int64(-1)
+	z := Reader{r: cr}
+	z.decompressor = flate.NewReaderCallback(cr, func(b flate.BlockInfo) {
+		out := outStart + b.Offset
+		if b.Offset != 0 && out-lastOut < span {
+			return
+		}
+		idx.Points = append(idx.Points, IndexPoint{
+			In:     inStart*8 + b.BitOffset,
+			Out:    out,
+			Window: b.Window(nil),
+		})
+		lastOut = out
+	})
+	for member := 0; ; member++ {
+		if _, err := z.readHeader(); err != nil {
+			if err == io.EOF && member > 0 {
+				break
+			}
+			return nil, err
+		}
+		inStart = cr.n
+		crc := crc32.NewIEEE()
+		n, err := io.Copy(crc, z.decompressor)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(cr, z.buf[:8]); err != nil {
+			return nil, noEOF(err)
+		}
+		if le.Uint32(z.buf[:4]) != crc.Sum32() || le.Uint32(z.buf[4:8]) != uint32(n) {
+			return nil, ErrChecksum
+		}
+		outStart += n
+	}
+	idx.Size = outStart
+	return idx, nil
+}
+
+// find returns the last point at or before the uncompressed offset.
+// If there is no such point nil is returned.
+func (idx *Index) find(off int64) *IndexPoint {
+	i := sort.Search(len(idx.Points), func(i int) bool {
+		return idx.Points[i].Out > off
+	})
+	if i == 0 {
+		return nil
+	}
+	return &idx.Points[i-1]
+}
+
+// ReaderAt provides random access to the uncompressed data of a gzip file
+// using an Index.
+type ReaderAt struct {
+	ra  io.ReaderAt
+	idx *Index
+}
+
+// NewReaderAt returns a ReaderAt reading the gzip file in ra,
+// which must be the file that idx was built from.
+// ReadAt calls start decompressing at the nearest point in the index.
+func NewReaderAt(ra io.ReaderAt, idx *Index) *ReaderAt {
+	return &ReaderAt{ra: ra, idx: idx}
+}
+
+// Size returns the uncompressed size of the file.
+func (r *ReaderAt) Size() int64 {
+	return r.idx.Size
+}
+
+// ReadAt reads len(p) uncompressed bytes starting at offset off.
+// It is safe to call ReadAt concurrently.
+// Checksums are only verified for members that are fully read.
+func (r *ReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("gzip: negative offset")
+	}
+	if off >= r.idx.Size {
+		return 0, io.EOF
+	}
+	var rd io.Reader
+	pt := r.idx.find(off)
+	if pt == nil {
+		// Start from the beginning.
+		br := bufio.NewReader(io.NewSectionReader(r.ra, 0, math.MaxInt64))
+		z, err := NewReader(br)
+		if err != nil {
+			return 0, err
+		}
+		rd = z
+		pt = &IndexPoint{}
+	} else {
+		start := pt.In / 8
+		br := bufio.NewReader(io.NewSectionReader(r.ra, start, math.MaxInt64-start))
+		rd = &memberTail{
+			r:   br,
+			dec: flate.NewReaderResume(br, pt.In, pt.Out, pt.Window, nil),
+		}
+	}
+	if skip := off - pt.Out; skip > 0 {
+		if _, err := io.CopyN(ioutil.Discard, rd, skip); err != nil {
+			return 0, noEOF(err)
+		}
+	}
+	n, err = io.ReadFull(rd, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// memberTail reads the remainder of a member from dec,
+// followed by any members after it.
+type memberTail struct {
+	r    flate.Reader
+	dec  io.Reader
+	next *Reader
+}
+
+func (m *memberTail) Read(p []byte) (int, error) {
+	if m.next != nil {
+		return m.next.Read(p)
+	}
+	n, err := m.dec.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+	// The checksum cannot be verified for a partial member, skip trailer.
+	var trailer [8]byte
+	if _, err := io.ReadFull(m.r, trailer[:]); err != nil {
+		return n, noEOF(err)
+	}
+	next := new(Reader)
+	if err := next.Reset(m.r); err != nil {
+		return n, err
+	}
+	m.next = next
+	return n, nil
+}
@@ -0,0 +1,95 @@
+package gzip
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestIndexReaderAt(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var want []byte
+	var compressed bytes.Buffer
+	// Three members with different levels.
+	for _, level := range []int{BestSpeed, NoCompression, BestCompression} {
+		w, err := NewWriterLevel(&compressed, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := len(want)
+		for len(want)-start < 500000 {
+			want = strconv.AppendInt(want, rng.Int63n(1000), 10)
+			if rng.Intn(1000) == 0 {
+				rnd := make([]byte, 1000)
+				rng.Read(rnd)
+				want = append(want, rnd...)
+			}
+		}
+		w.Write(want[start : start+200000])
+		w.Flush()
+		w.Write(want[start+200000:])
+		w.Close()
+	}
+
+	idx, err := BuildIndex(bytes.NewReader(compressed.Bytes()), 50000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Size != int64(len(want)) {
+		t.Fatalf("got size %d, want %d", idx.Size, len(want))
+	}
+	// Points are at block boundaries, so the distance can be larger than span.
+	if len(idx.Points) < 10 {
+		t.Fatalf("got %d points", len(idx.Points))
+	}
+	ra := NewReaderAt(bytes.NewReader(compressed.Bytes()), idx)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < 50; i++ {
+				off := rng.Int63n(int64(len(want)))
+				got := make([]byte, rng.Intn(100000))
+				n, err := ra.ReadAt(got, off)
+				exp := want[off:]
+				if len(exp) > len(got) {
+					exp = exp[:len(got)]
+				}
+				if n != len(exp) || (err != nil && (err != io.EOF || n == len(got))) {
+					t.Errorf("offset %d: got %d, %v want %d", off, n, err, len(exp))
+					return
+				}
+				if !bytes.Equal(got[:n], exp) {
+					t.Errorf("offset %d: data mismatch", off)
+					return
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	if _, err := ra.ReadAt(make([]byte, 1), int64(len(want))); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+
+	// Corrupt checksum of first member.
+	b := append([]byte{}, compressed.Bytes()...)
+	cr := &countReader{r: bytes.NewReader(b)}
+	z, err := NewReader(cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.Multistream(false)
+	if _, err := io.Copy(ioutil.Discard, z); err != nil {
+		t.Fatal(err)
+	}
+	b[cr.n-8] ^= 1
+	if _, err := BuildIndex(bytes.NewReader(b), 0); err != ErrChecksum {
+		t.Fatalf("got %v, want %v", err, ErrChecksum)
+	}
+}
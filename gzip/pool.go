@@ -0,0 +1,117 @@
+package gzip
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// WriterPool is a pool of Writers with the same compression level.
+// It is safe for concurrent use.
+//
+// Unlike sync.Pool, at most a fixed number of idle Writers are kept,
+// so the memory used after a burst of concurrent requests is bounded.
+// Writers that are not needed are left to the garbage collector.
+type WriterPool struct {
+	level int
+	idle  chan *Writer
+}
+
+// NewWriterPool returns a pool of Writers compressing with the given level,
+// keeping at most max idle Writers.
+// If max <= 0, runtime.GOMAXPROCS(0) Writers are kept.
+// The level can be any level accepted by NewWriterLevel.
+func NewWriterPool(level, max int) (*WriterPool, error) {
+	if level < StatelessCompression || level > BestCompression {
+		return nil, fmt.Errorf("gzip: invalid compression level: %d", level)
+	}
+	if max <= 0 {
+		max = runtime.GOMAXPROCS(0)
+	}
+	return &WriterPool{level: level, idle: make(chan *Writer, max)}, nil
+}
+
+// Get returns a Writer from the pool, or a new Writer, writing to w.
+// The Writer is in the state of one returned by NewWriterLevel.
+func (p *WriterPool) Get(w io.Writer) *Writer {
+	select {
+	case z := <-p.idle:
+		z.Reset(w)
+		return z
+	default:
+		z, _ := NewWriterLevel(w, p.level)
+		return z
+	}
+}
+
+// Put returns z to the pool.
+// z should be closed, and must not be used after Put.
+// Writers with another compression level or concurrency are not kept.
+func (p *WriterPool) Put(z *Writer) {
+	if z == nil || z.level != p.level || z.concurrency != 0 {
+		return
+	}
+	// Release the destination.
+	z.Reset(nil)
+	select {
+	case p.idle <- z:
+	default:
+	}
+}
+
+// ReaderPool is a pool of Readers.
+// It is safe for concurrent use.
+//
+// Unlike sync.Pool, at most a fixed number of idle Readers are kept,
+// so the memory used after a burst of concurrent requests is bounded.
+// Readers that are not needed are left to the garbage collector.
+type ReaderPool struct {
+	idle chan *Reader
+}
+
+// NewReaderPool returns a pool of Readers,
+// keeping at most max idle Readers.
+// If max <= 0, runtime.GOMAXPROCS(0) Readers are kept.
+func NewReaderPool(max int) *ReaderPool {
+	if max <= 0 {
+		max = runtime.GOMAXPROCS(0)
+	}
+	return &ReaderPool{idle: make(chan *Reader, max)}
+}
+
+// Get returns a Reader from the pool, or a new Reader, reading from r.
+// As with NewReader, the header is read from r,
+// and an error is returned if it is invalid.
+func (p *ReaderPool) Get(r io.Reader) (*Reader, error) {
+	select {
+	case z := <-p.idle:
+		if err := z.Reset(r); err != nil {
+			p.Put(z)
+			return nil, err
+		}
+		return z, nil
+	default:
+		return NewReader(r)
+	}
+}
+
+// Put returns z to the pool.
+// z must not be used after Put.
+// Readers with concurrency, a member callback or recovery are not kept.
+func (p *ReaderPool) Put(z *Reader) {
+	if z == nil || z.ra != nil || z.memberFn != nil || z.recoverFn != nil {
+		return
+	}
+	// Release the source.
+	z.r, z.cr, z.err = nil, nil, io.EOF
+	if z.decompressor != nil {
+		z.decompressor.(flate.Resetter).Reset(bytes.NewReader(nil), nil)
+	}
+	select {
+	case p.idle <- z:
+	default:
+	}
+}
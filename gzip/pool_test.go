@@ -0,0 +1,131 @@
+package gzip
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/flate"
+)
+
+func TestWriterPool(t *testing.T) {
+	if _, err := NewWriterPool(10, 0); err == nil {
+		t.Error("want error for invalid level")
+	}
+	const max = 2
+	p, err := NewWriterPool(BestSpeed, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The header of the previous stream is not kept.
+	var buf bytes.Buffer
+	w := p.Get(&buf)
+	w.Name = "first.txt"
+	w.Comment = "first"
+	w.Write([]byte("first"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(w)
+	buf.Reset()
+	w2 := p.Get(&buf)
+	if w2 != w {
+		t.Fatal("writer was not reused")
+	}
+	w2.Write([]byte("second"))
+	if err := w2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "second" {
+		t.Errorf("got %q, want %q", got, "second")
+	}
+	if zr.Name != "" || zr.Comment != "" {
+		t.Errorf("header was kept: name %q, comment %q", zr.Name, zr.Comment)
+	}
+
+	// Writers with another level or concurrency are not kept.
+	other, _ := NewWriterLevel(nil, BestCompression)
+	p.Put(other)
+	conc, _ := NewWriterLevel(nil, BestSpeed)
+	if err := conc.SetConcurrency(1<<20, 2); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conc)
+	if len(p.idle) != 0 {
+		t.Errorf("%d idle writers, want 0", len(p.idle))
+	}
+
+	for i := 0; i < 2*max; i++ {
+		w, _ := NewWriterLevel(nil, BestSpeed)
+		p.Put(w)
+	}
+	if len(p.idle) != max {
+		t.Errorf("%d idle writers, want %d", len(p.idle), max)
+	}
+}
+
+func TestReaderPool(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write([]byte("payload"))
+	w.Close()
+	compressed := buf.Bytes()
+
+	const max = 2
+	p := NewReaderPool(max)
+	zr, err := p.Get(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "payload" {
+		t.Errorf("got %q, want %q", got, "payload")
+	}
+	p.Put(zr)
+	if len(p.idle) != 1 {
+		t.Fatalf("%d idle readers, want 1", len(p.idle))
+	}
+	if zr2, _ := p.Get(bytes.NewReader(compressed)); zr2 != zr {
+		t.Error("reader was not reused")
+	}
+
+	// Readers with recovery, a member callback or concurrency are not kept.
+	rec, _ := NewReader(bytes.NewReader(compressed))
+	rec.SetRecovery(func(flate.RecoveryGap) {})
+	p.Put(rec)
+	cb, _ := NewReader(bytes.NewReader(compressed))
+	cb.SetMemberCallback(func(Member) {})
+	p.Put(cb)
+	conc, err := NewReaderConcurrent(bytes.NewReader(compressed), 1<<20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conc.Close()
+	p.Put(conc)
+	if len(p.idle) != 0 {
+		t.Errorf("%d idle readers, want 0", len(p.idle))
+	}
+
+	for i := 0; i < 2*max; i++ {
+		zr, _ := NewReader(bytes.NewReader(compressed))
+		p.Put(zr)
+	}
+	if len(p.idle) != max {
+		t.Errorf("%d idle readers, want %d", len(p.idle), max)
+	}
+	if _, err := p.Get(bytes.NewReader([]byte("not compressed data"))); err == nil {
+		t.Error("want error for invalid header")
+	}
+}
@@ -0,0 +1,43 @@
+package snapenc_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/internal/snapenc"
+	"github.com/klauspost/compress/snappy"
+)
+
+func TestEncodeBlockBetter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// Random data repeated at offsets above 64KB needs 4 byte offsets.
+	random := make([]byte, 100<<10)
+	rng.Read(random)
+	for _, size := range []int{16, 100, 64 << 10, 64<<10 + 1, 1 << 20, 4 << 20} {
+		src := make([]byte, size)
+		for i := 0; i < size; i += len(random) {
+			copy(src[i:], random)
+		}
+		for i := 0; i < size/1000; i++ {
+			src[rng.Intn(size)] = byte(i)
+		}
+		dst := make([]byte, snappy.MaxEncodedLen(size))
+		d := binary.PutUvarint(dst, uint64(size))
+		n := snapenc.EncodeBlockBetter(dst[d:], src)
+		if n == 0 {
+			if size > len(random) {
+				t.Errorf("size %d: not compressed", size)
+			}
+			continue
+		}
+		got, err := snappy.Decode(nil, dst[:d+n])
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Fatalf("size %d: output mismatch", size)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"errors"
 	"flag"
@@ -10,6 +11,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
@@ -34,6 +36,12 @@ var (
 	remove    = flag.Bool("rm", false, "Delete source file(s) after successful compression")
 	quiet     = flag.Bool("q", false, "Don't write any output to terminal, except errors")
 	bench     = flag.Int("bench", 0, "Run benchmark n times. No output will be written")
+	snappy    = flag.Bool("snappy", false, "Generate Snappy compatible output stream. Output files are written as 'filename.ext.snappy'")
+	recursive = flag.Bool("r", false, "Compress files in directories recursively")
+	include   = flag.String("include", "", "Only compress files where the file name matches this pattern. Example: '*.log'")
+	exclude   = flag.String("exclude", "", "Do not compress files where the file name matches this pattern. Example: '*.tmp'")
+	recomp    = flag.Bool("recomp", false, "Detect gzip, zstd, Snappy and S2 input and decompress it before compressing. The input extension is replaced in the output file name")
+	tarOut    = flag.Bool("tar", false, "Write all input files and directories as a single tar stream to 'name.tar.s2', named after the first input")
 	help      = flag.Bool("help", false, "Display help")
 
 	cpuprofile, memprofile, traceprofile string
@@ -69,6 +77,17 @@ Use - as the only file name to read from stdin and write to stdout.
 Wildcards are accepted: testdir/*.txt will compress all files in testdir ending with .txt
 Directories can be wildcards as well. testdir/*/*.txt will match testdir/subdir/b.txt
 
+Use -r to compress all files in directories recursively. Files already ending
+with the output extension are skipped. Use -include and -exclude to filter file names.
+
+Use -recomp to convert gzip, zstd, Snappy and S2 files, for example 'file.gz' to 'file.s2'.
+Files in other formats are compressed as is.
+
+Use -tar to write all input files and directories as a single tar stream.
+The output is written to the current directory as 'name.tar.s2', where name is the
+name of the first input, or to stdout when -c is specified.
+Leading '/' and '../' are removed from the names of the entries.
+
 Options:`)
 		flag.PrintDefaults()
 	}
@@ -76,6 +95,11 @@ Options:`)
 	if !*faster {
 		opts = append(opts, s2.WriterBetterCompression())
 	}
+	ext := ".s2"
+	if *snappy {
+		opts = append(opts, s2.WriterSnappyCompat())
+		ext = ".snappy"
+	}
 	wr := s2.NewWriter(nil, opts...)
 
 	// No args, use stdin/stdout
@@ -84,21 +108,27 @@ Options:`)
 		// os.Stdin will return EOF, so we should be able to get everything.
 		signal.Notify(make(chan os.Signal), os.Interrupt)
 		wr.Reset(os.Stdout)
-		_, err = wr.ReadFrom(os.Stdin)
+		var in io.Reader = os.Stdin
+		if *recomp {
+			dec, _, closer, err := decompressor(in)
+			exitErr(err)
+			defer closer()
+			in = dec
+		}
+		_, err = wr.ReadFrom(in)
 		printErr(err)
 		printErr(wr.Close())
 		return
 	}
-	var files []string
-
-	for _, pattern := range args {
-		found, err := filepath.Glob(pattern)
-		exitErr(err)
-		if len(found) == 0 {
-			exitErr(fmt.Errorf("unable to find file %v", pattern))
-		}
-		files = append(files, found...)
+	exitErr(checkPatterns())
+	if *tarOut && *remove {
+		exitErr(errors.New("-rm cannot be used with -tar"))
+	}
+	if *tarOut && *recomp {
+		exitErr(errors.New("-recomp cannot be used with -tar"))
 	}
+	files, err := expandFiles(args, ext, *recursive || *tarOut, *tarOut)
+	exitErr(err)
 	if cpuprofile != "" {
 		f, err := os.Create(cpuprofile)
 		if err != nil {
@@ -129,6 +159,10 @@ Options:`)
 	}
 
 	*quiet = *quiet || *stdout
+	if *tarOut {
+		compressTar(wr, files, ext, int(sz))
+		return
+	}
 	allFiles := files
 	for i := 0; i < *bench; i++ {
 		files = append(files, allFiles...)
@@ -136,20 +170,31 @@ Options:`)
 	for _, filename := range files {
 		func() {
 			var closeOnce sync.Once
-			dstFilename := fmt.Sprintf("%s%s", filename, ".s2")
+			dstFilename := fmt.Sprintf("%s%s", filename, ext)
+			// Input file.
+			file, err := os.Open(filename)
+			exitErr(err)
+			defer closeOnce.Do(func() { file.Close() })
+			ra, err := readahead.NewReaderSize(file, *cpu+1, 1<<20)
+			exitErr(err)
+			defer ra.Close()
+			var src io.Reader = ra
+			if *recomp {
+				dec, inExt, closer, err := decompressor(src)
+				exitErr(err)
+				defer closer()
+				src = dec
+				dstFilename = recompName(filename, inExt, ext)
+				if dstFilename == filename && *bench == 0 && !*stdout {
+					exitErr(fmt.Errorf("output file name is the same as input: %v", filename))
+				}
+			}
 			if *bench > 0 {
 				dstFilename = "(discarded)"
 			}
 			if !*quiet {
 				fmt.Print("Compressing ", filename, " -> ", dstFilename)
 			}
-			// Input file.
-			file, err := os.Open(filename)
-			exitErr(err)
-			defer closeOnce.Do(func() { file.Close() })
-			src, err := readahead.NewReaderSize(file, *cpu+1, 1<<20)
-			exitErr(err)
-			defer src.Close()
 			finfo, err := file.Stat()
 			exitErr(err)
 			var out io.Writer
@@ -201,6 +246,213 @@ Options:`)
 	}
 }
 
+// compressTar writes files as a single compressed tar stream.
+func compressTar(wr *s2.Writer, files []string, ext string, sz int) {
+	if len(files) == 0 {
+		exitErr(errors.New("no files to add"))
+	}
+	dstFilename := tarName(files[0]) + ".tar" + ext
+	if *bench > 0 {
+		dstFilename = "(discarded)"
+	}
+	var out io.Writer
+	switch {
+	case *bench > 0:
+		out = ioutil.Discard
+	case *stdout:
+		out = os.Stdout
+	default:
+		if *safe {
+			_, err := os.Stat(dstFilename)
+			if !os.IsNotExist(err) {
+				exitErr(errors.New("destination file exists"))
+			}
+		}
+		dstFile, err := os.OpenFile(dstFilename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+		exitErr(err)
+		defer dstFile.Close()
+		bw := bufio.NewWriterSize(dstFile, sz*2)
+		defer bw.Flush()
+		out = bw
+	}
+	if !*quiet {
+		fmt.Print("Compressing ", len(files), " entries -> ", dstFilename)
+	}
+	wc := wCounter{out: out}
+	wr.Reset(&wc)
+	start := time.Now()
+	tw := tar.NewWriter(wr)
+	var input int64
+	for _, filename := range files {
+		n, err := addTar(tw, filename)
+		exitErr(err)
+		input += n
+	}
+	exitErr(tw.Close())
+	exitErr(wr.Close())
+	if !*quiet {
+		elapsed := time.Since(start)
+		mbpersec := (float64(input) / (1024 * 1024)) / (float64(elapsed) / (float64(time.Second)))
+		pct := float64(wc.n) * 100 / float64(input)
+		fmt.Printf(" %d -> %d [%.02f%%]; %.01fMB/s\n", input, wc.n, pct, mbpersec)
+	}
+}
+
+// addTar adds a single file, directory or symlink to the tar stream.
+// The number of bytes of file content is returned.
+func addTar(tw *tar.Writer, filename string) (int64, error) {
+	finfo, err := os.Lstat(filename)
+	if err != nil {
+		return 0, err
+	}
+	var link string
+	if finfo.Mode()&os.ModeSymlink != 0 {
+		link, err = os.Readlink(filename)
+		if err != nil {
+			return 0, err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(finfo, link)
+	if err != nil {
+		return 0, err
+	}
+	hdr.Name = tarEntryName(filename)
+	if hdr.Name == "" {
+		// The root of the input, for example "..".
+		return 0, nil
+	}
+	if finfo.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return 0, err
+	}
+	if !finfo.Mode().IsRegular() {
+		return 0, nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	src, err := readahead.NewReaderSize(file, *cpu+1, 1<<20)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	return io.Copy(tw, src)
+}
+
+// tarEntryName returns the name of filename in the tar stream.
+// Volume names, leading slashes and leading ".." elements are removed,
+// so entries can be extracted below the output directory.
+func tarEntryName(filename string) string {
+	name := filename[len(filepath.VolumeName(filename)):]
+	name = strings.TrimLeft(path.Clean(filepath.ToSlash(name)), "/")
+	for name == ".." || strings.HasPrefix(name, "../") {
+		name = strings.TrimPrefix(strings.TrimPrefix(name, ".."), "/")
+	}
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+// tarName returns the base name to use for a tar stream created from filename.
+func tarName(filename string) string {
+	abs, err := filepath.Abs(filename)
+	if err == nil {
+		filename = abs
+	}
+	name := filepath.Base(filename)
+	if name == string(filepath.Separator) || name == "." {
+		return "archive"
+	}
+	return name
+}
+
+// checkPatterns returns an error if the include or exclude patterns are invalid.
+func checkPatterns() error {
+	for _, pattern := range []string{*include, *exclude} {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchName returns whether the file name is selected by the include and exclude patterns.
+func matchName(filename string) bool {
+	name := filepath.Base(filename)
+	if *include != "" {
+		if ok, _ := filepath.Match(*include, name); !ok {
+			return false
+		}
+	}
+	if *exclude != "" {
+		if ok, _ := filepath.Match(*exclude, name); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// expandFiles expands the patterns to a list of files.
+// If recursive is set, directories are walked and all files inside are added,
+// except files already ending with ext.
+// If dirs is set, directories and symlinks are also returned.
+func expandFiles(patterns []string, ext string, recursive, dirs bool) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		found, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("unable to find file %v", pattern)
+		}
+		for _, name := range found {
+			finfo, err := os.Stat(name)
+			if err != nil {
+				return nil, err
+			}
+			if !finfo.IsDir() {
+				if matchName(name) {
+					files = append(files, name)
+				}
+				continue
+			}
+			if !recursive {
+				return nil, fmt.Errorf("%v is a directory. Use -r to compress directories", name)
+			}
+			err = filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				switch {
+				case info.IsDir():
+					if dirs {
+						files = append(files, path)
+					}
+				case info.Mode().IsRegular():
+					if (dirs || !strings.HasSuffix(path, ext)) && matchName(path) {
+						files = append(files, path)
+					}
+				case dirs && info.Mode()&os.ModeSymlink != 0:
+					if matchName(path) {
+						files = append(files, path)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
 func printErr(err error) {
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "\nERROR:", err.Error())
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// makeTree creates a directory with files, a subdirectory and a symlink.
+func makeTree(t *testing.T) string {
+	t.Helper()
+	tmp, err := ioutil.TempDir("", "s2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a.txt": "hello", "b.s2": "compressed", "sub/c.txt": "world!"} {
+		name = filepath.Join(tmp, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(tmp, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return tmp
+}
+
+// relNames returns the names relative to dir, sorted.
+func relNames(t *testing.T, dir string, names []string) []string {
+	t.Helper()
+	var rel []string
+	for _, name := range names {
+		r, err := filepath.Rel(dir, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel = append(rel, filepath.ToSlash(r))
+	}
+	sort.Strings(rel)
+	return rel
+}
+
+func TestExpandFiles(t *testing.T) {
+	tmp := makeTree(t)
+	defer os.RemoveAll(tmp)
+
+	if _, err := expandFiles([]string{tmp}, ".s2", false, false); err == nil {
+		t.Error("want error for directory without recursion")
+	}
+	if _, err := expandFiles([]string{filepath.Join(tmp, "missing*")}, ".s2", false, false); err == nil {
+		t.Error("want error for no matches")
+	}
+	files, err := expandFiles([]string{filepath.Join(tmp, "*.txt")}, ".s2", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(relNames(t, tmp, files), ","); got != "a.txt" {
+		t.Errorf("glob: got %s", got)
+	}
+	files, err = expandFiles([]string{tmp}, ".s2", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(relNames(t, tmp, files), ","); got != "a.txt,sub/c.txt" {
+		t.Errorf("recursive: got %s", got)
+	}
+	files, err = expandFiles([]string{tmp}, ".s2", true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(relNames(t, tmp, files), ","); got != ".,a.txt,b.s2,link,sub,sub/c.txt" {
+		t.Errorf("tar: got %s", got)
+	}
+}
+
+func TestAddTar(t *testing.T) {
+	tmp := makeTree(t)
+	defer os.RemoveAll(tmp)
+	files, err := expandFiles([]string{tmp}, ".s2", true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	var total int64
+	for _, name := range files {
+		n, err := addTar(tw, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += n
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if total != int64(len("hello")+len("compressed")+len("world!")) {
+		t.Errorf("got %d bytes of content", total)
+	}
+
+	prefix := strings.TrimLeft(filepath.ToSlash(tmp), "/") + "/"
+	want := map[string]string{
+		"a.txt":     "hello",
+		"b.s2":      "compressed",
+		"sub/c.txt": "world!",
+		"link":      "-> a.txt",
+		"":          "dir",
+		"sub/":      "dir",
+	}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		if !strings.HasPrefix(hdr.Name, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(hdr.Name, prefix)
+		var got string
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			got = "dir"
+		case tar.TypeSymlink:
+			got = "-> " + hdr.Linkname
+		default:
+			b, _ := ioutil.ReadAll(tr)
+			got = string(b)
+		}
+		if got != want[name] {
+			t.Errorf("%s: got %q, want %q", name, got, want[name])
+		}
+		delete(want, name)
+	}
+	if len(want) != 0 {
+		t.Errorf("missing entries: %v", want)
+	}
+}
+
+func TestTarName(t *testing.T) {
+	tests := map[string]string{
+		"dir":          "dir",
+		"dir/":         "dir",
+		"a/b/file.txt": "file.txt",
+		"/":            "archive",
+	}
+	for in, want := range tests {
+		if got := tarName(filepath.FromSlash(in)); got != want {
+			t.Errorf("tarName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTarEntryName(t *testing.T) {
+	tests := map[string]string{
+		"file.txt":         "file.txt",
+		"dir/file.txt":     "dir/file.txt",
+		"/abs/file.txt":    "abs/file.txt",
+		"../data/file.txt": "data/file.txt",
+		"../../data":       "data",
+		"./a/../b":         "b",
+		"..":               "",
+		".":                "",
+	}
+	for in, want := range tests {
+		if got := tarEntryName(filepath.FromSlash(in)); got != want {
+			t.Errorf("tarEntryName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// TestTarUntar creates a tar stream from a parent relative path with s2c
+// and extracts it with s2d.
+func TestTarUntar(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	tmp := makeTree(t)
+	defer os.RemoveAll(tmp)
+	bin, err := ioutil.TempDir("", "s2bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bin)
+	for _, cmd := range []string{"s2c", "s2d"} {
+		out, err := exec.Command("go", "build", "-o", filepath.Join(bin, cmd), "github.com/klauspost/compress/s2/cmd/"+cmd).CombinedOutput()
+		if err != nil {
+			t.Skipf("building %s: %v\n%s", cmd, err, out)
+		}
+	}
+	run := func(dir, name string, args ...string) {
+		t.Helper()
+		cmd := exec.Command(filepath.Join(bin, name), args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s %v: %v\n%s", name, args, err, out)
+		}
+	}
+	work := filepath.Join(tmp, "sub")
+	run(work, "s2c", "-q", "-tar", "-r", "..")
+	run(work, "s2c", "-q", "-tar", "-r", filepath.Join("..", "sub"))
+	out := filepath.Join(tmp, "out")
+	run(work, "s2d", "-q", "-untar", "-dir", out, filepath.Base(tmp)+".tar.s2")
+	run(work, "s2d", "-q", "-untar", "-dir", out, "sub.tar.s2")
+
+	for name, want := range map[string]string{"a.txt": "hello", "b.s2": "compressed", "sub/c.txt": "world!"} {
+		got, err := ioutil.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(out, "link")); err != nil || link != "a.txt" {
+		t.Errorf("link: got %q, %v", link, err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/s2/cmd/internal/readahead"
+	snappydec "github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic   = []byte{0x1f, 0x8b}
+	zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+	s2Magic     = []byte("\xff\x06\x00\x00S2sTwO")
+)
+
+// decompressor detects gzip, zstd, Snappy and S2 streams and returns a reader
+// that decompresses r. Other input is returned as is.
+// The file extension of the detected format is returned.
+// The returned close function must be called when done reading.
+func decompressor(r io.Reader) (rd io.Reader, ext string, closer func(), err error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	hdr, err := br.Peek(len(s2Magic))
+	if err != nil && err != io.EOF {
+		return nil, "", nil, err
+	}
+	var dec io.Reader
+	closer = func() {}
+	switch {
+	case bytes.HasPrefix(hdr, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		dec, ext, closer = gr, ".gz", func() { gr.Close() }
+	case bytes.HasPrefix(hdr, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		dec, ext, closer = zr, ".zst", zr.Close
+	case bytes.Equal(hdr, snappyMagic):
+		dec, ext = snappydec.NewReader(br), ".snappy"
+	case bytes.Equal(hdr, s2Magic):
+		dec, ext = s2.NewReader(br), ".s2"
+	default:
+		return br, "", closer, nil
+	}
+	// Decompress concurrently with compression.
+	decClose := closer
+	ra, err := readahead.NewReaderSize(dec, 4, 1<<20)
+	if err != nil {
+		decClose()
+		return nil, "", nil, err
+	}
+	return ra, ext, func() {
+		ra.Close()
+		decClose()
+	}, nil
+}
+
+// recompName returns the output file name for filename,
+// with the extension of the detected input format replaced.
+func recompName(filename, inExt, ext string) string {
+	if inExt != "" && strings.HasSuffix(filename, inExt) {
+		filename = strings.TrimSuffix(filename, inExt)
+	}
+	return filename + ext
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecompressor(t *testing.T) {
+	data := bytes.Repeat([]byte("recompress me please. "), 10000)
+	compress := func(fn func(w io.Writer) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := fn(&buf)
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	tests := map[string][]byte{
+		".gz": compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }),
+		".zst": compress(func(w io.Writer) io.WriteCloser {
+			zw, err := zstd.NewWriter(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return zw
+		}),
+		".snappy": compress(func(w io.Writer) io.WriteCloser { return s2.NewWriter(w, s2.WriterSnappyCompat()) }),
+		".s2":     compress(func(w io.Writer) io.WriteCloser { return s2.NewWriter(w) }),
+		"":        data,
+	}
+	for wantExt, in := range tests {
+		rd, ext, closer, err := decompressor(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rd)
+		closer()
+		if err != nil {
+			t.Fatalf("%q: %v", wantExt, err)
+		}
+		if ext != wantExt {
+			t.Errorf("got extension %q, want %q", ext, wantExt)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%q: got %d bytes, want %d", wantExt, len(got), len(data))
+		}
+	}
+
+	// Short input is passed through.
+	rd, ext, closer, err := decompressor(bytes.NewReader([]byte{0x1f}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(rd)
+	closer()
+	if ext != "" || !bytes.Equal(got, []byte{0x1f}) {
+		t.Errorf("short input: got %q, %q", ext, got)
+	}
+
+	// Corrupt headers are reported.
+	if _, _, _, err := decompressor(bytes.NewReader([]byte{0x1f, 0x8b, 0, 0})); err == nil {
+		t.Error("want error for corrupt gzip header")
+	}
+}
+
+func TestRecompName(t *testing.T) {
+	tests := []struct{ filename, inExt, ext, want string }{
+		{"file.gz", ".gz", ".s2", "file.s2"},
+		{"file.zst", ".zst", ".snappy", "file.snappy"},
+		{"file.txt", "", ".s2", "file.txt.s2"},
+		{"file.gzip", ".gz", ".s2", "file.gzip.s2"},
+	}
+	for _, test := range tests {
+		if got := recompName(test.filename, test.inExt, test.ext); got != test.want {
+			t.Errorf("recompName(%q, %q, %q) = %q, want %q", test.filename, test.inExt, test.ext, got, test.want)
+		}
+	}
+}
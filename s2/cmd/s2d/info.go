@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/s2"
+)
+
+const (
+	chunkTypeCompressedData   = 0x00
+	chunkTypeUncompressedData = 0x01
+	chunkTypePadding          = 0xfe
+	chunkTypeStreamIdentifier = 0xff
+	checksumSize              = 4
+)
+
+// chunkInfo contains information about a single chunk of a stream.
+type chunkInfo struct {
+	offset       int64 // Offset of the chunk header in the stream.
+	chunkType    uint8
+	size         int    // Size of the chunk, excluding the 4 byte header.
+	uncompressed int    // Uncompressed size of data chunks.
+	magic        string // Stream identifier body.
+}
+
+// name returns a description of the chunk type.
+func (c chunkInfo) name() string {
+	switch {
+	case c.chunkType == chunkTypeCompressedData:
+		return "compressed"
+	case c.chunkType == chunkTypeUncompressedData:
+		return "uncompressed"
+	case c.chunkType == chunkTypePadding:
+		return "padding"
+	case c.chunkType == chunkTypeStreamIdentifier:
+		switch c.magic {
+		case "S2sTwO":
+			return "stream (S2)"
+		case "sNaPpY":
+			return "stream (Snappy)"
+		}
+		return "stream (unknown)"
+	case c.chunkType >= 0x80:
+		return fmt.Sprintf("skippable 0x%02x", c.chunkType)
+	}
+	return fmt.Sprintf("reserved 0x%02x", c.chunkType)
+}
+
+// scanStream reads all chunk headers of the stream and calls fn for each chunk.
+// Blocks are not decompressed and checksums are not verified.
+func scanStream(r io.Reader, fn func(c chunkInfo) error) error {
+	var tmp [checksumSize + 10]byte
+	var offset int64
+	for {
+		_, err := io.ReadFull(r, tmp[:4])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		c := chunkInfo{
+			offset:    offset,
+			chunkType: tmp[0],
+			size:      int(tmp[1]) | int(tmp[2])<<8 | int(tmp[3])<<16,
+		}
+		read := 0
+		switch c.chunkType {
+		case chunkTypeCompressedData:
+			read = c.size
+			if read > len(tmp) {
+				read = len(tmp)
+			}
+			if read <= checksumSize {
+				return s2.ErrCorrupt
+			}
+			if _, err := io.ReadFull(r, tmp[:read]); err != nil {
+				return err
+			}
+			c.uncompressed, err = s2.DecodedLen(tmp[checksumSize:read])
+			if err != nil {
+				return err
+			}
+		case chunkTypeUncompressedData:
+			if c.size < checksumSize {
+				return s2.ErrCorrupt
+			}
+			c.uncompressed = c.size - checksumSize
+		case chunkTypeStreamIdentifier:
+			if c.size != 6 {
+				return s2.ErrCorrupt
+			}
+			read = c.size
+			if _, err := io.ReadFull(r, tmp[:read]); err != nil {
+				return err
+			}
+			c.magic = string(tmp[:read])
+		default:
+			if c.chunkType < 0x80 {
+				return s2.ErrUnsupported
+			}
+		}
+		if n := int64(c.size - read); n > 0 {
+			if _, err := io.CopyN(ioutil.Discard, r, n); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+		}
+		offset += 4 + int64(c.size)
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+}
+
+// streamSize returns the total uncompressed size of the stream.
+func streamSize(r io.Reader) (int64, error) {
+	var total int64
+	err := scanStream(r, func(c chunkInfo) error {
+		total += int64(c.uncompressed)
+		return nil
+	})
+	return total, err
+}
+
+// printInfo prints all chunks of the stream and a summary.
+func printInfo(r io.Reader) error {
+	var (
+		chunks, blocks      int
+		compressed, padding int64
+		uncompressed        int64
+	)
+	fmt.Printf("%14s %-18s %10s %12s %8s\n", "Offset", "Type", "Size", "Uncompressed", "Ratio")
+	err := scanStream(r, func(c chunkInfo) error {
+		chunks++
+		compressed += 4 + int64(c.size)
+		if c.chunkType == chunkTypePadding {
+			padding += 4 + int64(c.size)
+		}
+		if c.chunkType > chunkTypeUncompressedData {
+			fmt.Printf("%14d %-18s %10d\n", c.offset, c.name(), c.size)
+			return nil
+		}
+		blocks++
+		uncompressed += int64(c.uncompressed)
+		pct := 0.0
+		if c.uncompressed > 0 {
+			pct = float64(c.size) * 100 / float64(c.uncompressed)
+		}
+		fmt.Printf("%14d %-18s %10d %12d %7.02f%%\n", c.offset, c.name(), c.size, c.uncompressed, pct)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if chunks == 0 {
+		return errors.New("empty stream")
+	}
+	pct := 0.0
+	if uncompressed > 0 {
+		pct = float64(compressed) * 100 / float64(uncompressed)
+	}
+	fmt.Printf("Chunks: %d, blocks: %d, padding: %d bytes. %d -> %d [%.02f%%]\n", chunks, blocks, padding, compressed, uncompressed, pct)
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// makeStream returns n bytes of partly compressible data and its s2 stream
+// with a skippable chunk and padding.
+func makeStream(t *testing.T, n int) (data, stream []byte) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	data = make([]byte, n)
+	for i := range data {
+		if i%3 == 0 {
+			data[i] = byte(rng.Intn(256))
+		} else {
+			data[i] = byte(i % 7)
+		}
+	}
+	var buf bytes.Buffer
+	w := s2.NewWriter(&buf, s2.WriterBlockSize(64<<10), s2.WriterPadding(1<<10))
+	if err := w.AddSkippableBlock(0x80, []byte("skip")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return data, buf.Bytes()
+}
+
+func TestScanStream(t *testing.T) {
+	data, stream := makeStream(t, 300<<10)
+	var chunks []chunkInfo
+	err := scanStream(bytes.NewReader(stream), func(c chunkInfo) error {
+		chunks = append(chunks, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 3 || chunks[0].name() != "stream (S2)" || chunks[1].name() != "skippable 0x80" {
+		t.Fatalf("unexpected chunks: %+v", chunks)
+	}
+	var blocks, padding int
+	var offset int64
+	for _, c := range chunks {
+		if c.offset != offset {
+			t.Errorf("chunk offset %d, want %d", c.offset, offset)
+		}
+		offset += 4 + int64(c.size)
+		switch c.chunkType {
+		case chunkTypeCompressedData, chunkTypeUncompressedData:
+			blocks++
+		case chunkTypePadding:
+			padding++
+		}
+	}
+	if offset != int64(len(stream)) {
+		t.Errorf("chunks cover %d bytes, stream is %d", offset, len(stream))
+	}
+	if blocks != 5 || padding != 1 {
+		t.Errorf("got %d blocks and %d padding chunks", blocks, padding)
+	}
+
+	total, err := streamSize(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != int64(len(data)) {
+		t.Errorf("streamSize: got %d, want %d", total, len(data))
+	}
+
+	// Truncated and corrupt streams must be reported.
+	if _, err := streamSize(bytes.NewReader(stream[:len(stream)-10])); err == nil {
+		t.Error("want error for truncated stream")
+	}
+	corrupt := append([]byte{}, stream...)
+	corrupt[chunks[2].offset] = 0x02
+	if _, err := streamSize(bytes.NewReader(corrupt)); err != s2.ErrUnsupported {
+		t.Errorf("reserved chunk: got %v, want %v", err, s2.ErrUnsupported)
+	}
+}
+
+func TestRangeReader(t *testing.T) {
+	data, stream := makeStream(t, 300<<10)
+	tests := []struct {
+		name                string
+		offset, limit, tail int64
+		want                []byte
+	}{
+		{name: "all", want: data},
+		{name: "offset", offset: 100000, want: data[100000:]},
+		{name: "limit", limit: 1000, want: data[:1000]},
+		{name: "offset limit", offset: 70000, limit: 1000, want: data[70000:71000]},
+		{name: "tail", tail: 5000, want: data[len(data)-5000:]},
+		{name: "tail limit", tail: 5000, limit: 10, want: data[len(data)-5000 : len(data)-4990]},
+		{name: "long tail", tail: 1 << 30, want: data},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rd, err := rangeReader(s2.NewReader(bytes.NewReader(stream)), test.offset, test.limit, test.tail, int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(rd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("got %d bytes, want %d", len(got), len(test.want))
+			}
+		})
+	}
+	if _, err := rangeReader(s2.NewReader(bytes.NewReader(stream)), int64(len(data))+1, 0, 0, 0); err == nil {
+		t.Error("want error for offset beyond end of stream")
+	}
+}
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/klauspost/compress/s2"
 	"github.com/klauspost/compress/s2/cmd/internal/readahead"
@@ -26,6 +30,17 @@ var (
 	bench  = flag.Int("bench", 0, "Run benchmark n times. No output will be written")
 	help   = flag.Bool("help", false, "Display help")
 
+	recursive = flag.Bool("r", false, "Decompress files in directories recursively")
+	include   = flag.String("include", "", "Only decompress files where the file name matches this pattern. Example: '*.log.s2'")
+	exclude   = flag.String("exclude", "", "Do not decompress files where the file name matches this pattern. Example: '*.tmp.s2'")
+	untar     = flag.Bool("untar", false, "Extract the decompressed tar stream into the directory given by -dir")
+	dir       = flag.String("dir", ".", "Output directory when extracting with -untar")
+	offset    = flag.String("offset", "0", "Start decompressed output at this offset. Examples: 92, 64K, 256K, 1M, 4M")
+	limit     = flag.String("limit", "0", "Only output this many decompressed bytes. 0 means no limit. Examples: 92, 64K, 256K, 1M, 4M")
+	tail      = flag.String("tail", "0", "Only output the last part of the decompressed stream. Examples: 92, 64K, 256K, 1M, 4M")
+	recomp    = flag.String("recomp", "", "Recompress the decompressed output to this format: 'gzip' or 'zstd'. The extension is added to the output file name")
+	info      = flag.Bool("info", false, "List the chunks of the stream, but do not write output")
+
 	version = "(dev)"
 	date    = "(unknown)"
 )
@@ -49,30 +64,79 @@ Use - as the only file name to read from stdin and write to stdout.
 Wildcards are accepted: testdir/*.txt will compress all files in testdir ending with .txt
 Directories can be wildcards as well. testdir/*/*.txt will match testdir/subdir/b.txt
 
+Use -r to decompress all '.s2' and '.snappy' files in directories recursively.
+Use -include and -exclude to filter file names.
+
+Use -untar to extract tar streams, for example created with 's2c -tar', to the
+directory specified by -dir.
+
+Use -offset, -limit and -tail to only output a range of the decompressed stream.
+Data before the range is skipped without being written.
+
+Use -recomp to convert files to gzip or zstd, for example 'file.s2' to 'file.gz'.
+
+Use -info to list the chunks of the stream with their sizes and compression ratio.
+
 Options:`)
 		flag.PrintDefaults()
 	}
+	off, err := toSize(*offset)
+	exitErr(err)
+	lim, err := toSize(*limit)
+	exitErr(err)
+	tl, err := toSize(*tail)
+	exitErr(err)
+	ranged := off > 0 || lim > 0 || tl > 0
+	if off > 0 && tl > 0 {
+		exitErr(errors.New("-offset and -tail cannot be used together"))
+	}
+	if ranged && (*untar || *remove) {
+		exitErr(errors.New("-offset, -limit and -tail cannot be used with -untar or -rm"))
+	}
+	var recompExtension string
+	if *recomp != "" {
+		if *untar {
+			exitErr(errors.New("-recomp cannot be used with -untar"))
+		}
+		recompExtension, err = recompExt(*recomp)
+		exitErr(err)
+	}
+
 	if len(args) == 1 && args[0] == "-" {
+		if *info {
+			exitErr(printInfo(bufio.NewReaderSize(os.Stdin, 1<<20)))
+			return
+		}
+		if tl > 0 {
+			exitErr(errors.New("-tail cannot be used with stdin"))
+		}
 		r.Reset(os.Stdin)
+		if *untar && !*verify {
+			_, err := extractTar(r, *dir)
+			exitErr(err)
+			return
+		}
+		rd, err := rangeReader(r, int64(off), int64(lim), 0, 0)
+		exitErr(err)
 		if !*verify {
-			_, err := io.Copy(os.Stdout, r)
+			var out io.Writer = os.Stdout
+			if *recomp != "" {
+				enc, err := recompWriter(out, *recomp)
+				exitErr(err)
+				defer func() { exitErr(enc.Close()) }()
+				out = enc
+			}
+			_, err := io.Copy(out, rd)
 			exitErr(err)
 		} else {
-			_, err := io.Copy(ioutil.Discard, r)
+			_, err := io.Copy(ioutil.Discard, rd)
 			exitErr(err)
 		}
 		return
 	}
-	var files []string
-
-	for _, pattern := range args {
-		found, err := filepath.Glob(pattern)
-		exitErr(err)
-		if len(found) == 0 {
-			exitErr(fmt.Errorf("unable to find file %v", pattern))
-		}
-		files = append(files, found...)
-	}
+	exitErr(checkPatterns())
+	files, err := expandFiles(args, *recursive)
+	exitErr(err)
 
 	*quiet = *quiet || *stdout
 	allFiles := files
@@ -91,12 +155,25 @@ Options:`)
 			fmt.Println("Skipping", filename)
 			continue
 		}
+		dstFilename += recompExtension
 		if *bench > 0 {
 			dstFilename = "(discarded)"
 		}
 		if *verify {
 			dstFilename = "(verify)"
 		}
+		if *untar && *bench == 0 && !*verify {
+			dstFilename = *dir
+		}
+		if *info {
+			fmt.Println(filename + ":")
+			file, err := os.Open(filename)
+			exitErr(err)
+			err = printInfo(bufio.NewReaderSize(file, 1<<20))
+			file.Close()
+			exitErr(err)
+			continue
+		}
 
 		func() {
 			var closeOnce sync.Once
@@ -107,6 +184,13 @@ Options:`)
 			file, err := os.Open(filename)
 			exitErr(err)
 			defer closeOnce.Do(func() { file.Close() })
+			var total int64
+			if tl > 0 {
+				total, err = streamSize(bufio.NewReaderSize(file, 1<<20))
+				exitErr(err)
+				_, err = file.Seek(0, io.SeekStart)
+				exitErr(err)
+			}
 			rc := rCounter{in: file}
 			src, err := readahead.NewReaderSize(&rc, 2, 4<<20)
 			exitErr(err)
@@ -114,12 +198,22 @@ Options:`)
 			finfo, err := file.Stat()
 			exitErr(err)
 			mode := finfo.Mode() // use the same mode for the output file
-			if *safe {
+			if *safe && !*untar {
 				_, err := os.Stat(dstFilename)
 				if !os.IsNotExist(err) {
 					exitErr(errors.New("destination files exists"))
 				}
 			}
+			r.Reset(src)
+			start := time.Now()
+			var output int64
+			if *untar && *bench == 0 && !*verify {
+				output, err = extractTar(r, *dir)
+				exitErr(err)
+				printResult(rc.n, output, start)
+				removeFile(filename, file, &closeOnce)
+				return
+			}
 			var out io.Writer
 			switch {
 			case *bench > 0 || *verify:
@@ -134,30 +228,257 @@ Options:`)
 				defer bw.Flush()
 				out = bw
 			}
-			r.Reset(src)
-			start := time.Now()
-			output, err := io.Copy(out, r)
+			rd, err := rangeReader(r, int64(off), int64(lim), int64(tl), total)
 			exitErr(err)
-			if !*quiet {
-				elapsed := time.Since(start)
-				mbPerSec := (float64(output) / (1024 * 1024)) / (float64(elapsed) / (float64(time.Second)))
-				pct := float64(output) * 100 / float64(rc.n)
-				fmt.Printf(" %d -> %d [%.02f%%]; %.01fMB/s\n", rc.n, output, pct, mbPerSec)
-			}
-			if *remove && !*verify {
-				closeOnce.Do(func() {
-					file.Close()
-					if !*quiet {
-						fmt.Println("Removing", filename)
-					}
-					err := os.Remove(filename)
-					exitErr(err)
-				})
+			var enc io.WriteCloser
+			if *recomp != "" && *bench == 0 && !*verify {
+				enc, err = recompWriter(out, *recomp)
+				exitErr(err)
+				out = enc
 			}
+			output, err = io.Copy(out, rd)
+			exitErr(err)
+			if enc != nil {
+				exitErr(enc.Close())
+			}
+			printResult(rc.n, output, start)
+			removeFile(filename, file, &closeOnce)
 		}()
 	}
 }
 
+// rangeReader skips to the requested part of the stream and limits the output.
+// If tail is set, the offset is calculated from the total uncompressed size.
+func rangeReader(r *s2.Reader, offset, limit, tail, total int64) (io.Reader, error) {
+	if tail > 0 {
+		offset = total - tail
+		if offset < 0 {
+			offset = 0
+		}
+	}
+	if offset > 0 {
+		if err := r.Skip(offset); err != nil {
+			return nil, err
+		}
+	}
+	if limit > 0 {
+		return io.LimitReader(r, limit), nil
+	}
+	return r, nil
+}
+
+// printResult prints the decompression result, unless quiet.
+func printResult(input int, output int64, start time.Time) {
+	if !*quiet {
+		elapsed := time.Since(start)
+		mbPerSec := (float64(output) / (1024 * 1024)) / (float64(elapsed) / (float64(time.Second)))
+		pct := float64(output) * 100 / float64(input)
+		fmt.Printf(" %d -> %d [%.02f%%]; %.01fMB/s\n", input, output, pct, mbPerSec)
+	}
+}
+
+// removeFile removes the input file if requested.
+func removeFile(filename string, file *os.File, closeOnce *sync.Once) {
+	if *remove && !*verify {
+		closeOnce.Do(func() {
+			file.Close()
+			if !*quiet {
+				fmt.Println("Removing", filename)
+			}
+			err := os.Remove(filename)
+			exitErr(err)
+		})
+	}
+}
+
+// extractTar extracts the tar stream in r to the directory dst.
+// The number of bytes of file content written is returned.
+// Entries that would be written outside dst are rejected,
+// as are entries that would be written through a symlink.
+func extractTar(r io.Reader, dst string) (int64, error) {
+	dst = filepath.Clean(dst)
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return 0, err
+	}
+	inside := func(name string) bool {
+		rel, err := filepath.Rel(dst, name)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+	var total int64
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+		name := filepath.Join(dst, filepath.FromSlash(hdr.Name))
+		if !inside(name) {
+			return total, fmt.Errorf("invalid file name in archive: %q", hdr.Name)
+		}
+		if err := checkSymlinks(dst, name); err != nil {
+			return total, err
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(name, mode.Perm()|0700); err != nil {
+				return total, err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return total, err
+			}
+			if *safe {
+				_, err := os.Stat(name)
+				if !os.IsNotExist(err) {
+					return total, fmt.Errorf("destination file exists: %v", name)
+				}
+			}
+			n, err := writeFile(name, mode.Perm(), tr)
+			total += n
+			if err != nil {
+				return total, err
+			}
+		case tar.TypeSymlink:
+			target := hdr.Linkname
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(name), target)
+			}
+			if filepath.IsAbs(hdr.Linkname) || !inside(target) {
+				return total, fmt.Errorf("invalid symlink in archive: %q -> %q", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return total, err
+			}
+			if err := os.Symlink(hdr.Linkname, name); err != nil {
+				return total, err
+			}
+		default:
+			if !*quiet {
+				fmt.Println("\nSkipping", hdr.Name, "unsupported file type")
+			}
+		}
+	}
+}
+
+// checkSymlinks returns an error if name or any of its parent directories
+// below dst is a symlink. Symlinks created by earlier entries could otherwise
+// be used to write outside dst.
+func checkSymlinks(dst, name string) error {
+	rel, err := filepath.Rel(dst, name)
+	if err != nil {
+		return err
+	}
+	p := dst
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("invalid file name in archive, %q is a symlink", p)
+		}
+	}
+	return nil
+}
+
+// writeFile writes the content of r to a new file with the given mode.
+func writeFile(name string, mode os.FileMode, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriterSize(f, 1<<20)
+	n, err := io.Copy(bw, r)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// checkPatterns returns an error if the include or exclude patterns are invalid.
+func checkPatterns() error {
+	for _, pattern := range []string{*include, *exclude} {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchName returns whether the file name is selected by the include and exclude patterns.
+func matchName(filename string) bool {
+	name := filepath.Base(filename)
+	if *include != "" {
+		if ok, _ := filepath.Match(*include, name); !ok {
+			return false
+		}
+	}
+	if *exclude != "" {
+		if ok, _ := filepath.Match(*exclude, name); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// expandFiles expands the patterns to a list of files.
+// If recursive is set, directories are walked and all
+// files ending with '.s2' or '.snappy' are added.
+func expandFiles(patterns []string, recursive bool) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		found, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("unable to find file %v", pattern)
+		}
+		for _, name := range found {
+			finfo, err := os.Stat(name)
+			if err != nil {
+				return nil, err
+			}
+			if !finfo.IsDir() {
+				if matchName(name) {
+					files = append(files, name)
+				}
+				continue
+			}
+			if !recursive {
+				return nil, fmt.Errorf("%v is a directory. Use -r to decompress directories", name)
+			}
+			err = filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.Mode().IsRegular() || !matchName(path) {
+					return nil
+				}
+				if strings.HasSuffix(path, ".s2") || strings.HasSuffix(path, ".snappy") {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
 func exitErr(err error) {
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "\nERROR:", err.Error())
@@ -165,6 +486,38 @@ func exitErr(err error) {
 	}
 }
 
+// toSize converts a size indication to bytes.
+func toSize(size string) (uint64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	firstLetter := strings.IndexFunc(size, unicode.IsLetter)
+	if firstLetter == -1 {
+		firstLetter = len(size)
+	}
+
+	bytesString, multiple := size[:firstLetter], size[firstLetter:]
+	bytes, err := strconv.ParseUint(bytesString, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse size: %v", err)
+	}
+
+	var shift uint
+	switch multiple {
+	case "G", "GB", "GIB":
+		shift = 30
+	case "M", "MB", "MIB":
+		shift = 20
+	case "K", "KB", "KIB":
+		shift = 10
+	case "B", "":
+	default:
+		return 0, fmt.Errorf("unknown size suffix: %v", multiple)
+	}
+	if bytes > math.MaxUint64>>shift {
+		return 0, fmt.Errorf("size too large: %v", size)
+	}
+	return bytes << shift, nil
+}
+
 type rCounter struct {
 	n  int
 	in io.Reader
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry is an entry written by makeTar.
+type tarEntry struct {
+	name, link, content string
+	typ                 byte
+}
+
+func makeTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Linkname: e.link, Typeflag: e.typ, Mode: 0644, Size: int64(len(e.content))}
+		if e.typ == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractTar(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "s2d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dst := filepath.Join(tmp, "out")
+
+	tr := makeTar(t, []tarEntry{
+		{name: "dir/", typ: tar.TypeDir},
+		{name: "dir/a.txt", typ: tar.TypeReg, content: "hello"},
+		{name: "dir/sub/b.txt", typ: tar.TypeReg, content: "world!"},
+		{name: "link", typ: tar.TypeSymlink, link: "dir/a.txt"},
+	})
+	n, err := extractTar(tr, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 11 {
+		t.Errorf("got %d bytes, want 11", n)
+	}
+	for name, want := range map[string]string{"dir/a.txt": "hello", "dir/sub/b.txt": "world!", "link": "hello"} {
+		got, err := ioutil.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestExtractTarEscape(t *testing.T) {
+	tests := map[string][]tarEntry{
+		"parent name": {
+			{name: "../escaped.txt", typ: tar.TypeReg, content: "x"},
+		},
+		"absolute symlink": {
+			{name: "a", typ: tar.TypeSymlink, link: "/tmp"},
+		},
+		"parent symlink": {
+			{name: "a", typ: tar.TypeSymlink, link: ".."},
+		},
+		"through symlinks": {
+			{name: "a", typ: tar.TypeSymlink, link: "."},
+			{name: "a/c", typ: tar.TypeSymlink, link: ".."},
+			{name: "c/escaped.txt", typ: tar.TypeReg, content: "x"},
+		},
+		"onto symlink": {
+			{name: "a", typ: tar.TypeSymlink, link: "."},
+			{name: "b", typ: tar.TypeSymlink, link: "a/../escaped.txt"},
+			{name: "b", typ: tar.TypeReg, content: "x"},
+		},
+	}
+	for name, entries := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "s2d")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+			dst := filepath.Join(tmp, "out")
+			if _, err := extractTar(makeTar(t, entries), dst); err == nil {
+				t.Error("want error")
+			}
+			if _, err := os.Stat(filepath.Join(tmp, "escaped.txt")); !os.IsNotExist(err) {
+				t.Error("file written outside destination")
+			}
+		})
+	}
+}
+
+func TestToSize(t *testing.T) {
+	tests := map[string]uint64{"0": 0, "92": 92, "64K": 64 << 10, "4MB": 4 << 20, "1gib": 1 << 30, " 10b ": 10}
+	for in, want := range tests {
+		got, err := toSize(in)
+		if err != nil || got != want {
+			t.Errorf("toSize(%q) = %d, %v, want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "K", "10X", "-1", "99999999999G", "18446744073709551615K"} {
+		if _, err := toSize(in); err == nil {
+			t.Errorf("toSize(%q): want error", in)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// recompExt returns the file extension for the -recomp format.
+func recompExt(format string) (string, error) {
+	switch format {
+	case "gzip":
+		return ".gz", nil
+	case "zstd":
+		return ".zst", nil
+	}
+	return "", fmt.Errorf("unknown -recomp format %q. Must be 'gzip' or 'zstd'", format)
+}
+
+// recompWriter returns a writer that compresses to w using the -recomp format.
+// The writer must be closed to flush remaining data.
+func recompWriter(w io.Writer, format string) (io.WriteCloser, error) {
+	switch format {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	}
+	_, err := recompExt(format)
+	return nil, err
+}
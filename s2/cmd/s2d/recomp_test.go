@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestRecompWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("recompress me please. "), 10000)
+	for format, wantExt := range map[string]string{"gzip": ".gz", "zstd": ".zst"} {
+		ext, err := recompExt(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ext != wantExt {
+			t.Errorf("%s: got extension %q, want %q", format, ext, wantExt)
+		}
+		var buf bytes.Buffer
+		w, err := recompWriter(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var got []byte
+		switch format {
+		case "gzip":
+			gr, err := gzip.NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err = ioutil.ReadAll(gr)
+			if err != nil {
+				t.Fatal(err)
+			}
+		case "zstd":
+			zr, err := zstd.NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err = ioutil.ReadAll(zr)
+			zr.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: got %d bytes, want %d", format, len(got), len(data))
+		}
+	}
+
+	if _, err := recompExt("brotli"); err == nil {
+		t.Error("want error for unknown format")
+	}
+	if _, err := recompWriter(ioutil.Discard, "brotli"); err == nil {
+		t.Error("want error for unknown format")
+	}
+}
@@ -8,7 +8,9 @@ package s2
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 )
 
 var (
@@ -77,11 +79,38 @@ func Decode(dst, src []byte) ([]byte, error) {
 // NewReader returns a new Reader that decompresses from r, using the framing
 // format described at
 // https://github.com/google/snappy/blob/master/framing_format.txt with S2 changes.
-func NewReader(r io.Reader) *Reader {
-	return &Reader{
+func NewReader(r io.Reader, opts ...ReaderOption) *Reader {
+	nr := Reader{
 		r:   r,
 		buf: make([]byte, MaxEncodedLen(maxBlockSize)+checksumSize),
 	}
+	for _, opt := range opts {
+		if err := opt(&nr); err != nil {
+			nr.optErr = err
+			nr.err = err
+			return &nr
+		}
+	}
+	return &nr
+}
+
+// ReaderOption is an option for creating a decoder.
+type ReaderOption func(*Reader) error
+
+// ReaderSkippableCB will register a callback for chunks with the specified ID.
+// ID must be a Reserved skippable chunks ID, 0x80-0xfd (inclusive).
+// For each chunk with the ID, the callback is called with the content.
+// Any returned non-nil error will abort decompression.
+// Content not read by the callback will be discarded.
+// Only one callback per ID is supported, latest sent will be used.
+func ReaderSkippableCB(id uint8, fn func(r io.Reader) error) ReaderOption {
+	return func(r *Reader) error {
+		if id < 0x80 || id > 0xfd {
+			return fmt.Errorf("s2: invalid skippable id %#x, must be 0x80-0xfd (inclusive)", id)
+		}
+		r.skippableCB[id-0x80] = fn
+		return nil
+	}
 }
 
 // Reader is an io.Reader that can read Snappy-compressed bytes.
@@ -93,6 +122,12 @@ type Reader struct {
 	// decoded[i:j] contains decoded bytes that have not yet been passed on.
 	i, j       int
 	readHeader bool
+
+	// optErr is the error from an invalid option, kept on Reset.
+	optErr error
+
+	// skippableCB contains callbacks for skippable chunk IDs 0x80-0xff.
+	skippableCB [0x80]func(r io.Reader) error
 }
 
 // Reset discards any buffered data, resets all state, and switches the Snappy
@@ -100,7 +135,7 @@ type Reader struct {
 // a new one.
 func (r *Reader) Reset(reader io.Reader) {
 	r.r = reader
-	r.err = nil
+	r.err = r.optErr
 	r.i = 0
 	r.j = 0
 	r.readHeader = false
@@ -116,6 +151,28 @@ func (r *Reader) readFull(p []byte, allowEOF bool) (ok bool) {
 	return true
 }
 
+// skippable will read or skip a skippable chunk with the given id and length.
+// If a callback is registered for the id, it is handed the chunk content.
+func (r *Reader) skippable(id uint8, n int) (ok bool) {
+	fn := r.skippableCB[id-0x80]
+	if fn == nil {
+		return r.readFull(r.buf[:n], false)
+	}
+	rd := &io.LimitedReader{R: r.r, N: int64(n)}
+	if r.err = fn(rd); r.err != nil {
+		return false
+	}
+	// Discard anything the callback didn't read.
+	if _, r.err = io.CopyBuffer(ioutil.Discard, rd, r.buf); r.err != nil {
+		return false
+	}
+	if rd.N != 0 {
+		r.err = ErrCorrupt
+		return false
+	}
+	return true
+}
+
 // Read satisfies the io.Reader interface.
 func (r *Reader) Read(p []byte) (int, error) {
 	if r.err != nil {
@@ -238,7 +295,7 @@ func (r *Reader) Read(p []byte) (int, error) {
 		}
 		// Section 4.4 Padding (chunk type 0xfe).
 		// Section 4.6. Reserved skippable chunks (chunk types 0x80-0xfd).
-		if !r.readFull(r.buf[:chunkLen], false) {
+		if !r.skippable(chunkType, chunkLen) {
 			return 0, r.err
 		}
 	}
@@ -393,11 +450,9 @@ func (r *Reader) Skip(n int64) error {
 		}
 		// Section 4.4 Padding (chunk type 0xfe).
 		// Section 4.6. Reserved skippable chunks (chunk types 0x80-0xfd).
-		if !r.readFull(r.buf[:chunkLen], false) {
+		if !r.skippable(chunkType, chunkLen) {
 			return r.err
 		}
-
-		return io.ErrUnexpectedEOF
 	}
 	return nil
 }
@@ -15,6 +15,9 @@ import (
 	"math/bits"
 	"runtime"
 	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/internal/snapenc"
 )
 
 // Encode returns the encoded form of src. The returned slice may be a sub-
@@ -245,6 +248,9 @@ func NewWriter(w io.Writer, opts ...WriterOption) *Writer {
 			return &w2
 		}
 	}
+	if w2.snappy && w2.blockSize > maxSnappyBlockSize {
+		w2.blockSize = maxSnappyBlockSize
+	}
 	w2.obufLen = obufHeaderLen + MaxEncodedLen(w2.blockSize)
 	w2.paramsOK = true
 	w2.ibuf = make([]byte, 0, w2.blockSize)
@@ -278,6 +284,7 @@ type Writer struct {
 	wroteStreamHeader bool
 	paramsOK          bool
 	better            bool
+	snappy            bool
 }
 
 type result []byte
@@ -295,6 +302,31 @@ func (w *Writer) err(err error) error {
 	return errSet
 }
 
+// encodeBlock encodes src to dst using the block encoder selected
+// by the writer options. It returns 0 if src should be stored uncompressed.
+func (w *Writer) encodeBlock(dst, src []byte) int {
+	if len(src) < minNonLiteralBlockSize {
+		return 0
+	}
+	switch {
+	case w.snappy && w.better:
+		return snapenc.EncodeBlockBetter(dst, src)
+	case w.snappy:
+		return encodeBlockSnappy(dst, src)
+	case w.better:
+		return encodeBlockBetter(dst, src)
+	}
+	return encodeBlock(dst, src)
+}
+
+// streamHeader returns the stream identifier chunk to write.
+func (w *Writer) streamHeader() []byte {
+	if w.snappy {
+		return []byte(magicChunkSnappy)
+	}
+	return []byte(magicChunk)
+}
+
 // Reset discards the writer's state and switches the Snappy writer to write to w.
 // This permits reusing a Writer rather than allocating a new one.
 func (w *Writer) Reset(writer io.Writer) {
@@ -428,13 +460,30 @@ func (w *Writer) ReadFrom(r io.Reader) (n int64, err error) {
 // EncodeBuffer will add a buffer to the stream.
 // This is the fastest way to encode a stream,
 // but the input buffer cannot be written to by the caller
-// until this function, Flush or Close has been called.
+// until Flush or Close has been called.
+// Use EncodeBufferCB to be notified when the buffer can be reused.
 //
 // Note that input is not buffered.
 // This means that each write will result in discrete blocks being created.
 // For buffered writes, use the regular Write function.
 func (w *Writer) EncodeBuffer(buf []byte) (err error) {
+	return w.EncodeBufferCB(buf, nil)
+}
+
+// EncodeBufferCB will add a buffer to the stream, similar to EncodeBuffer.
+// The done callback is called exactly once when buf is no longer referenced
+// by the Writer, after which the caller is free to modify or reuse it.
+// This will typically happen when all blocks of buf have been compressed,
+// before the output has been written.
+// If an error is returned done will still be called.
+// done may be called from another goroutine, or before this function returns.
+// A nil done is allowed.
+func (w *Writer) EncodeBufferCB(buf []byte, done func()) (err error) {
+	if done == nil {
+		done = func() {}
+	}
 	if err := w.err(nil); err != nil {
+		done()
 		return err
 	}
 
@@ -442,22 +491,30 @@ func (w *Writer) EncodeBuffer(buf []byte) (err error) {
 	if len(w.ibuf) > 0 {
 		err := w.Flush()
 		if err != nil {
+			done()
 			return err
 		}
 	}
 	if w.concurrency == 1 {
 		_, err := w.writeSync(buf)
+		done()
 		return err
 	}
+	if len(buf) == 0 {
+		done()
+		return nil
+	}
 
 	// Spawn goroutine and write block to output channel.
 	if !w.wroteStreamHeader {
 		w.wroteStreamHeader = true
 		hWriter := make(chan result)
 		w.output <- hWriter
-		hWriter <- []byte(magicChunk)
+		hWriter <- w.streamHeader()
 	}
 
+	// Number of blocks still referencing buf.
+	remain := int32((len(buf) + w.blockSize - 1) / w.blockSize)
 	for len(buf) > 0 {
 		// Cut input.
 		uncompressed := buf
@@ -479,12 +536,7 @@ func (w *Writer) EncodeBuffer(buf []byte) (err error) {
 
 			// Attempt compressing.
 			n := binary.PutUvarint(obuf[obufHeaderLen:], uint64(len(uncompressed)))
-			var n2 int
-			if w.better {
-				n2 = encodeBlockBetter(obuf[obufHeaderLen+n:], uncompressed)
-			} else {
-				n2 = encodeBlock(obuf[obufHeaderLen+n:], uncompressed)
-			}
+			n2 := w.encodeBlock(obuf[obufHeaderLen+n:], uncompressed)
 
 			// Check if we should use this, or store as uncompressed instead.
 			if n2 > 0 {
@@ -496,6 +548,11 @@ func (w *Writer) EncodeBuffer(buf []byte) (err error) {
 				copy(obuf[obufHeaderLen:], uncompressed)
 			}
 
+			// Input is no longer referenced.
+			if atomic.AddInt32(&remain, -1) == 0 {
+				done()
+			}
+
 			// Fill in the per-chunk header that comes before the body.
 			obuf[0] = chunkType
 			obuf[1] = uint8(chunkLen >> 0)
@@ -513,6 +570,82 @@ func (w *Writer) EncodeBuffer(buf []byte) (err error) {
 	return nil
 }
 
+// AddSkippableBlock will add a skippable block to the stream.
+// The ID must be 0x80-0xfd (inclusive).
+// Length of the skippable block must be <= 4MB.
+// Any data buffered by Write will be queued before the block,
+// so the block will appear at the current position in the stream.
+// Compatible decoders will skip the block, but the content can be
+// read using the ReaderSkippableCB option on the Reader.
+func (w *Writer) AddSkippableBlock(id uint8, data []byte) (err error) {
+	if err := w.err(nil); err != nil {
+		return err
+	}
+	if id < 0x80 || id > 0xfd {
+		return fmt.Errorf("s2: invalid skippable block id %#x, must be 0x80-0xfd (inclusive)", id)
+	}
+	if len(data) > maxBlockSize {
+		return fmt.Errorf("s2: skippable block exceeds maximum size (%d > %d)", len(data), maxBlockSize)
+	}
+
+	// Queue buffered data first to keep the order.
+	if len(w.ibuf) > 0 {
+		_, err := w.write(w.ibuf)
+		w.ibuf = w.ibuf[:0]
+		if err = w.err(err); err != nil {
+			return err
+		}
+	}
+
+	hdr := [skippableFrameHeader]byte{id, uint8(len(data)), uint8(len(data) >> 8), uint8(len(data) >> 16)}
+	if w.concurrency == 1 {
+		if !w.wroteStreamHeader {
+			w.wroteStreamHeader = true
+			if err := w.writeSyncRaw(w.streamHeader()); err != nil {
+				return err
+			}
+		}
+		if err := w.writeSyncRaw(hdr[:]); err != nil {
+			return err
+		}
+		return w.writeSyncRaw(data)
+	}
+
+	if !w.wroteStreamHeader {
+		w.wroteStreamHeader = true
+		hWriter := make(chan result)
+		w.output <- hWriter
+		hWriter <- w.streamHeader()
+	}
+
+	// Copy the block, so the caller can reuse data.
+	obuf := make([]byte, 0, len(hdr)+len(data))
+	if len(hdr)+len(data) <= w.obufLen {
+		obuf = w.buffers.Get().([]byte)[:0]
+	}
+	obuf = append(obuf, hdr[:]...)
+	obuf = append(obuf, data...)
+
+	output := make(chan result, 1)
+	w.output <- output
+	output <- obuf
+	return nil
+}
+
+// writeSyncRaw writes b directly to the output.
+// Should only be used when concurrency is 1.
+func (w *Writer) writeSyncRaw(b []byte) error {
+	n, err := w.writer.Write(b)
+	if err != nil {
+		return w.err(err)
+	}
+	if n != len(b) {
+		return w.err(io.ErrShortWrite)
+	}
+	w.written += int64(n)
+	return nil
+}
+
 func (w *Writer) write(p []byte) (nRet int, errRet error) {
 	if err := w.err(nil); err != nil {
 		return 0, err
@@ -527,7 +660,7 @@ func (w *Writer) write(p []byte) (nRet int, errRet error) {
 			w.wroteStreamHeader = true
 			hWriter := make(chan result)
 			w.output <- hWriter
-			hWriter <- []byte(magicChunk)
+			hWriter <- w.streamHeader()
 		}
 
 		var uncompressed []byte
@@ -556,12 +689,7 @@ func (w *Writer) write(p []byte) (nRet int, errRet error) {
 
 			// Attempt compressing.
 			n := binary.PutUvarint(obuf[obufHeaderLen:], uint64(len(uncompressed)))
-			var n2 int
-			if w.better {
-				n2 = encodeBlockBetter(obuf[obufHeaderLen+n:], uncompressed)
-			} else {
-				n2 = encodeBlock(obuf[obufHeaderLen+n:], uncompressed)
-			}
+			n2 := w.encodeBlock(obuf[obufHeaderLen+n:], uncompressed)
 
 			// Check if we should use this, or store as uncompressed instead.
 			if n2 > 0 {
@@ -613,7 +741,7 @@ func (w *Writer) writeFull(inbuf []byte) (errRet error) {
 		w.wroteStreamHeader = true
 		hWriter := make(chan result)
 		w.output <- hWriter
-		hWriter <- []byte(magicChunk)
+		hWriter <- w.streamHeader()
 	}
 
 	// Get an output buffer.
@@ -632,12 +760,7 @@ func (w *Writer) writeFull(inbuf []byte) (errRet error) {
 
 		// Attempt compressing.
 		n := binary.PutUvarint(obuf[obufHeaderLen:], uint64(len(uncompressed)))
-		var n2 int
-		if w.better {
-			n2 = encodeBlockBetter(obuf[obufHeaderLen+n:], uncompressed)
-		} else {
-			n2 = encodeBlock(obuf[obufHeaderLen+n:], uncompressed)
-		}
+		n2 := w.encodeBlock(obuf[obufHeaderLen+n:], uncompressed)
 
 		// Check if we should use this, or store as uncompressed instead.
 		if n2 > 0 {
@@ -674,11 +797,12 @@ func (w *Writer) writeSync(p []byte) (nRet int, errRet error) {
 	}
 	if !w.wroteStreamHeader {
 		w.wroteStreamHeader = true
-		n, err := w.writer.Write([]byte(magicChunk))
+		hdr := w.streamHeader()
+		n, err := w.writer.Write(hdr)
 		if err != nil {
 			return 0, w.err(err)
 		}
-		if n != len(magicChunk) {
+		if n != len(hdr) {
 			return 0, w.err(io.ErrShortWrite)
 		}
 		w.written += int64(n)
@@ -701,12 +825,7 @@ func (w *Writer) writeSync(p []byte) (nRet int, errRet error) {
 
 		// Attempt compressing.
 		n := binary.PutUvarint(obuf[obufHeaderLen:], uint64(len(uncompressed)))
-		var n2 int
-		if w.better {
-			n2 = encodeBlockBetter(obuf[obufHeaderLen+n:], uncompressed)
-		} else {
-			n2 = encodeBlock(obuf[obufHeaderLen+n:], uncompressed)
-		}
+		n2 := w.encodeBlock(obuf[obufHeaderLen+n:], uncompressed)
 
 		if n2 > 0 {
 			chunkType = uint8(chunkTypeCompressedData)
@@ -922,3 +1041,16 @@ func WriterPadding(n int) WriterOption {
 		return nil
 	}
 }
+
+// WriterSnappyCompat will write Snappy compatible output.
+// The stream identifier will be the Snappy identifier and only
+// Snappy compatible block encoding is used, so the output can be
+// decompressed by both Snappy and S2 stream decoders.
+// The block size will be limited to 64KB as required by the Snappy framing format.
+// Better compression can be combined with this option.
+func WriterSnappyCompat() WriterOption {
+	return func(w *Writer) error {
+		w.snappy = true
+		return nil
+	}
+}
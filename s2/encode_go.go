@@ -7,19 +7,6 @@ import (
 	"math/bits"
 )
 
-// encodeBlock encodes a non-empty src to a guaranteed-large-enough dst. It
-// assumes that the varint-encoded length of the decompressed bytes has already
-// been written.
-//
-// It also assumes that:
-//	len(dst) >= MaxEncodedLen(len(src))
-func encodeBlock(dst, src []byte) (d int) {
-	if len(src) < minNonLiteralBlockSize {
-		return 0
-	}
-	return encodeBlockGo(dst, src)
-}
-
 // emitLiteral writes a literal chunk and returns the number of bytes written.
 //
 // It assumes that:
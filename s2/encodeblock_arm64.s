@@ -0,0 +1,734 @@
+// Copyright (c) 2019 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !appengine
+// +build gc
+// +build !noasm
+
+#include "textflag.h"
+
+// The asm code follows the pure Go code in encodeBlockGo (encode_all.go)
+// and emits identical output. The emitLiteral, emitCopy and emitRepeat
+// functions from encode_go.go are inlined once each and "called" by
+// setting R_CONT to the continuation to return to.
+
+#define R_DST R0
+#define R_S R1
+#define R_NEXT R2
+#define R_REP R3
+#define R_CAND R4
+#define R_CV R5
+#define R_SRC R6
+#define R_SLEN R7
+#define R_SLIMIT R8
+#define R_DLIMIT R9
+#define R_TAB R10
+#define R_PRIME R11
+#define R_DBASE R12
+#define R_CONT R13
+#define R_TMP0 R14
+#define R_TMP1 R15
+#define R_TMP2 R19
+#define R_TMP3 R20
+#define R_TMP4 R21
+#define R_BASE R22
+#define R_LITP R23
+#define R_LITN R24
+#define R_LEN R25
+
+// Stack layout. The table is 1<<14 uint32 entries.
+#define argDst 8
+#define argSrc 16
+#define argLen 24
+#define spillDst 32
+#define spillS 40
+#define spillNext 48
+#define spillRep 56
+#define spillCand 64
+#define spillCont 72
+#define spillBase 80
+#define tableOff 96
+#define tableSize 65536
+
+// Continuations.
+#define contRepeat 1
+#define contMatch 2
+#define contRemainder 3
+
+// hash6 computes the 14 bit hash of the lowest 6 bytes of src into dst.
+#define HASH6(src, dst) \
+	LSL $16, src, dst      \
+	MUL R_PRIME, dst, dst  \
+	LSR $50, dst, dst
+
+// LOAD_CONSTANTS (re)loads all registers that are not modified in the main loop.
+#define LOAD_CONSTANTS \
+	MOVD dst_base+0(FP), R_DBASE  \
+	MOVD src_base+24(FP), R_SRC   \
+	MOVD src_len+32(FP), R_SLEN   \
+	SUB  $8, R_SLEN, R_SLIMIT     \
+	LSR  $5, R_SLEN, R_DLIMIT     \
+	SUB  R_DLIMIT, R_SLEN, R_DLIMIT \
+	SUB  $5, R_DLIMIT, R_DLIMIT   \
+	ADD  R_DBASE, R_DLIMIT, R_DLIMIT \
+	ADD  $tableOff, RSP, R_TAB    \
+	MOVD $227718039650203, R_PRIME
+
+// func encodeBlockAsm(dst, src []byte) int
+//
+// R_DST is a pointer to dst[d] and R_DLIMIT is a pointer to dst[dstLimit].
+// All other positions are indexes into src.
+TEXT ·encodeBlockAsm(SB), $65632-56
+	LOAD_CONSTANTS
+
+	// Zero the table.
+	MOVD R_TAB, R_TMP0
+	MOVD $(tableSize/64), R_TMP1
+
+zeroTable:
+	STP.P (ZR, ZR), 16(R_TMP0)
+	STP.P (ZR, ZR), 16(R_TMP0)
+	STP.P (ZR, ZR), 16(R_TMP0)
+	STP.P (ZR, ZR), 16(R_TMP0)
+	SUBS  $1, R_TMP1, R_TMP1
+	BNE   zeroTable
+
+	// d := 0; nextEmit := 0; s := 1; repeat := 1
+	MOVD R_DBASE, R_DST
+	MOVD $0, R_NEXT
+	MOVD $1, R_S
+	MOVD $1, R_REP
+
+	// cv := load64(src, s)
+	MOVD 1(R_SRC), R_CV
+
+searchLoop:
+	// nextS := s + (s-nextEmit)>>6 + 4
+	SUB R_NEXT, R_S, R_TMP0
+	ADD R_TMP0>>6, R_S, R_TMP4
+	ADD $4, R_TMP4, R_TMP4
+
+	// if nextS > sLimit { goto emitRemainder }
+	CMP R_SLIMIT, R_TMP4
+	BGT emitRemainder
+
+	// hash0 := hash6(cv, tableBits)
+	// hash1 := hash6(cv>>8, tableBits)
+	HASH6(R_CV, R_TMP0)
+	LSR $8, R_CV, R_TMP1
+	HASH6(R_TMP1, R_TMP1)
+
+	// candidate = int(table[hash0])
+	// candidate2 := int(table[hash1])
+	MOVWU (R_TAB)(R_TMP0<<2), R_CAND
+	MOVWU (R_TAB)(R_TMP1<<2), R_TMP2
+
+	// table[hash0] = uint32(s)
+	// table[hash1] = uint32(s + 1)
+	MOVW R_S, (R_TAB)(R_TMP0<<2)
+	ADD  $1, R_S, R_TMP3
+	MOVW R_TMP3, (R_TAB)(R_TMP1<<2)
+
+	// hash2 := hash6(cv>>16, tableBits)
+	LSR $16, R_CV, R_TMP1
+	HASH6(R_TMP1, R_TMP1)
+
+	// if uint32(cv>>8) == load32(src, s-repeat+1)
+	SUB   R_REP, R_S, R_TMP0
+	ADD   R_SRC, R_TMP0, R_TMP0
+	MOVWU 1(R_TMP0), R_TMP0
+	LSR   $8, R_CV, R_TMP3
+	CMPW  R_TMP0, R_TMP3
+	BEQ   repeatFound
+
+	// if uint32(cv) == load32(src, candidate) { break }
+	MOVWU (R_SRC)(R_CAND), R_TMP0
+	CMPW  R_TMP0, R_CV
+	BEQ   matchFound
+
+	// candidate = int(table[hash2])
+	MOVWU (R_TAB)(R_TMP1<<2), R_CAND
+
+	// if uint32(cv>>8) == load32(src, candidate2)
+	MOVWU (R_SRC)(R_TMP2), R_TMP0
+	CMPW  R_TMP0, R_TMP3
+	BNE   checkCandidate3
+
+	// table[hash2] = uint32(s + 2)
+	// candidate = candidate2
+	// s++
+	ADD  $2, R_S, R_TMP0
+	MOVW R_TMP0, (R_TAB)(R_TMP1<<2)
+	MOVD R_TMP2, R_CAND
+	ADD  $1, R_S, R_S
+	B    matchFound
+
+checkCandidate3:
+	// table[hash2] = uint32(s + 2)
+	ADD  $2, R_S, R_TMP0
+	MOVW R_TMP0, (R_TAB)(R_TMP1<<2)
+
+	// if uint32(cv>>16) == load32(src, candidate)
+	MOVWU (R_SRC)(R_CAND), R_TMP0
+	LSR   $16, R_CV, R_TMP3
+	CMPW  R_TMP0, R_TMP3
+	BNE   searchNext
+
+	// s += 2
+	ADD $2, R_S, R_S
+	B   matchFound
+
+searchNext:
+	// cv = load64(src, nextS)
+	// s = nextS
+	MOVD (R_SRC)(R_TMP4), R_CV
+	MOVD R_TMP4, R_S
+	B    searchLoop
+
+repeatFound:
+	// base := s + checkRep
+	ADD $1, R_S, R_BASE
+
+	// Extend back
+	// for i := base - repeat; base > nextEmit && i > 0 && src[i-1] == src[base-1]; {
+	//	i--
+	//	base--
+	// }
+	SUB R_REP, R_BASE, R_TMP0
+
+repeatExtendBack:
+	CMP   R_NEXT, R_BASE
+	BLE   repeatExtendBackEnd
+	CMP   $0, R_TMP0
+	BLE   repeatExtendBackEnd
+	ADD   R_SRC, R_TMP0, R_TMP1
+	MOVBU -1(R_TMP1), R_TMP1
+	ADD   R_SRC, R_BASE, R_TMP2
+	MOVBU -1(R_TMP2), R_TMP2
+	CMP   R_TMP1, R_TMP2
+	BNE   repeatExtendBackEnd
+	SUB   $1, R_TMP0, R_TMP0
+	SUB   $1, R_BASE, R_BASE
+	B     repeatExtendBack
+
+repeatExtendBackEnd:
+	// d += emitLiteral(dst[d:], src[nextEmit:base])
+	ADD  R_SRC, R_NEXT, R_LITP
+	SUB  R_NEXT, R_BASE, R_LITN
+	MOVD $contRepeat, R_CONT
+	B    emitLiteral
+
+repeatEmitLiteralDone:
+	// Extend forward
+	// candidate := s - repeat + 4 + checkRep
+	// s += 4 + checkRep
+	SUB R_REP, R_S, R_CAND
+	ADD $5, R_CAND, R_CAND
+	ADD $5, R_S, R_S
+
+repeatExtendForward:
+	// for s <= sLimit
+	CMP  R_SLIMIT, R_S
+	BGT  repeatExtendForwardEnd
+	MOVD (R_SRC)(R_S), R_TMP0
+	MOVD (R_SRC)(R_CAND), R_TMP1
+	EOR  R_TMP0, R_TMP1, R_TMP0
+	CBZ  R_TMP0, repeatExtendForward8
+
+	// s += bits.TrailingZeros64(diff) >> 3
+	RBIT R_TMP0, R_TMP0
+	CLZ  R_TMP0, R_TMP0
+	ADD  R_TMP0>>3, R_S, R_S
+	B    repeatExtendForwardEnd
+
+repeatExtendForward8:
+	ADD $8, R_S, R_S
+	ADD $8, R_CAND, R_CAND
+	B   repeatExtendForward
+
+repeatExtendForwardEnd:
+	SUB  R_BASE, R_S, R_LEN
+	MOVD R_REP, R_TMP4
+	MOVD $contRepeat, R_CONT
+
+	// if nextEmit > 0 {
+	//	d += emitRepeat(dst[d:], repeat, s-base)
+	// } else {
+	//	d += emitCopy(dst[d:], repeat, s-base)
+	// }
+	CBZ R_NEXT, emitCopy
+	B   emitRepeat
+
+repeatEmitCopyDone:
+	// nextEmit = s
+	MOVD R_S, R_NEXT
+
+	// if s >= sLimit { goto emitRemainder }
+	CMP R_SLIMIT, R_S
+	BGE emitRemainder
+
+	// cv = load64(src, s)
+	MOVD (R_SRC)(R_S), R_CV
+	B    searchLoop
+
+matchFound:
+	// Extend backwards
+	// for candidate > 0 && s > nextEmit && src[candidate-1] == src[s-1] {
+	//	candidate--
+	//	s--
+	// }
+	CMP   $0, R_CAND
+	BLE   matchExtendBackEnd
+	CMP   R_NEXT, R_S
+	BLE   matchExtendBackEnd
+	ADD   R_SRC, R_CAND, R_TMP1
+	MOVBU -1(R_TMP1), R_TMP1
+	ADD   R_SRC, R_S, R_TMP2
+	MOVBU -1(R_TMP2), R_TMP2
+	CMP   R_TMP1, R_TMP2
+	BNE   matchExtendBackEnd
+	SUB   $1, R_CAND, R_CAND
+	SUB   $1, R_S, R_S
+	B     matchFound
+
+matchExtendBackEnd:
+	// Bail if we exceed the maximum size.
+	// if d+(s-nextEmit) > dstLimit { return 0 }
+	SUB R_NEXT, R_S, R_LITN
+	ADD R_DST, R_LITN, R_TMP0
+	CMP R_DLIMIT, R_TMP0
+	BHI returnZero
+
+	// d += emitLiteral(dst[d:], src[nextEmit:s])
+	ADD  R_SRC, R_NEXT, R_LITP
+	MOVD $contMatch, R_CONT
+	B    emitLiteral
+
+matchLoop:
+	// base := s
+	// repeat = base - candidate
+	MOVD R_S, R_BASE
+	SUB  R_CAND, R_S, R_REP
+
+	// s += 4
+	// candidate += 4
+	ADD $4, R_S, R_S
+	ADD $4, R_CAND, R_CAND
+
+matchExtendForward:
+	// for s <= len(src)-8
+	CMP  R_SLIMIT, R_S
+	BGT  matchExtendForwardEnd
+	MOVD (R_SRC)(R_S), R_TMP0
+	MOVD (R_SRC)(R_CAND), R_TMP1
+	EOR  R_TMP0, R_TMP1, R_TMP0
+	CBZ  R_TMP0, matchExtendForward8
+
+	// s += bits.TrailingZeros64(diff) >> 3
+	RBIT R_TMP0, R_TMP0
+	CLZ  R_TMP0, R_TMP0
+	ADD  R_TMP0>>3, R_S, R_S
+	B    matchExtendForwardEnd
+
+matchExtendForward8:
+	ADD $8, R_S, R_S
+	ADD $8, R_CAND, R_CAND
+	B   matchExtendForward
+
+matchExtendForwardEnd:
+	// d += emitCopy(dst[d:], repeat, s-base)
+	SUB  R_BASE, R_S, R_LEN
+	MOVD R_REP, R_TMP4
+	MOVD $contMatch, R_CONT
+	B    emitCopy
+
+matchEmitCopyDone:
+	// nextEmit = s
+	MOVD R_S, R_NEXT
+
+	// if s >= sLimit { goto emitRemainder }
+	CMP R_SLIMIT, R_S
+	BGE emitRemainder
+
+	// if d > dstLimit { return 0 }
+	CMP R_DLIMIT, R_DST
+	BHI returnZero
+
+	// Check for an immediate match, otherwise start search at s+1
+	// x := load64(src, s-2)
+	ADD  R_SRC, R_S, R_TMP0
+	MOVD -2(R_TMP0), R_TMP2
+
+	// m2Hash := hash6(x, tableBits)
+	// currHash := hash6(x>>16, tableBits)
+	HASH6(R_TMP2, R_TMP0)
+	LSR $16, R_TMP2, R_TMP3
+	HASH6(R_TMP3, R_TMP1)
+
+	// candidate = int(table[currHash])
+	MOVWU (R_TAB)(R_TMP1<<2), R_CAND
+
+	// table[m2Hash] = uint32(s - 2)
+	// table[currHash] = uint32(s)
+	SUB  $2, R_S, R_TMP4
+	MOVW R_TMP4, (R_TAB)(R_TMP0<<2)
+	MOVW R_S, (R_TAB)(R_TMP1<<2)
+
+	// if uint32(x>>16) != load32(src, candidate)
+	MOVWU (R_SRC)(R_CAND), R_TMP0
+	CMPW  R_TMP0, R_TMP3
+	BEQ   matchLoop
+
+	// cv = load64(src, s+1)
+	// s++
+	ADD  $1, R_S, R_S
+	MOVD (R_SRC)(R_S), R_CV
+	B    searchLoop
+
+emitRemainder:
+	// if nextEmit < len(src)
+	CMP R_SLEN, R_NEXT
+	BGE returnLength
+
+	// Bail if we exceed the maximum size.
+	// if d+len(src)-nextEmit > dstLimit { return 0 }
+	SUB R_NEXT, R_SLEN, R_LITN
+	ADD R_DST, R_LITN, R_TMP0
+	CMP R_DLIMIT, R_TMP0
+	BHI returnZero
+
+	// d += emitLiteral(dst[d:], src[nextEmit:])
+	ADD  R_SRC, R_NEXT, R_LITP
+	MOVD $contRemainder, R_CONT
+	B    emitLiteral
+
+returnLength:
+	SUB  R_DBASE, R_DST, R_TMP0
+	MOVD R_TMP0, ret+48(FP)
+	RET
+
+returnZero:
+	MOVD ZR, ret+48(FP)
+	RET
+
+// ----------------------------------------
+// emitLiteral writes R_LITN bytes from R_LITP as a literal to R_DST.
+// R_DST is advanced, continues at R_CONT.
+emitLiteral:
+	CBZ R_LITN, emitLiteralDone
+
+	// n := uint(len(lit)-1)
+	SUB $1, R_LITN, R_TMP0
+	CMP $60, R_TMP0
+	BHS emitLiteral1B
+
+	// dst[0] = uint8(n)<<2 | tagLiteral
+	LSL  $2, R_TMP0, R_TMP1
+	MOVB R_TMP1, (R_DST)
+	ADD  $1, R_DST, R_DST
+	B    emitLiteralCopy
+
+emitLiteral1B:
+	CMP $256, R_TMP0
+	BHS emitLiteral2B
+
+	// dst[1] = uint8(n)
+	// dst[0] = 60<<2 | tagLiteral
+	MOVD $(60<<2), R_TMP1
+	MOVB R_TMP1, (R_DST)
+	MOVB R_TMP0, 1(R_DST)
+	ADD  $2, R_DST, R_DST
+	B    emitLiteralCopy
+
+emitLiteral2B:
+	CMP $65536, R_TMP0
+	BHS emitLiteral3B
+
+	// dst[2] = uint8(n >> 8)
+	// dst[1] = uint8(n)
+	// dst[0] = 61<<2 | tagLiteral
+	MOVD $(61<<2), R_TMP1
+	MOVB R_TMP1, (R_DST)
+	MOVH R_TMP0, 1(R_DST)
+	ADD  $3, R_DST, R_DST
+	B    emitLiteralCopy
+
+emitLiteral3B:
+	CMP $16777216, R_TMP0
+	BHS emitLiteral4B
+
+	// dst[3] = uint8(n >> 16)
+	// dst[2] = uint8(n >> 8)
+	// dst[1] = uint8(n)
+	// dst[0] = 62<<2 | tagLiteral
+	LSL  $8, R_TMP0, R_TMP1
+	ORR  $(62<<2), R_TMP1, R_TMP1
+	MOVW R_TMP1, (R_DST)
+	ADD  $4, R_DST, R_DST
+	B    emitLiteralCopy
+
+emitLiteral4B:
+	// dst[4] = uint8(n >> 24)
+	// dst[3] = uint8(n >> 16)
+	// dst[2] = uint8(n >> 8)
+	// dst[1] = uint8(n)
+	// dst[0] = 63<<2 | tagLiteral
+	MOVD $(63<<2), R_TMP1
+	MOVB R_TMP1, (R_DST)
+	MOVW R_TMP0, 1(R_DST)
+	ADD  $5, R_DST, R_DST
+
+emitLiteralCopy:
+	// copy(dst[i:], lit)
+	//
+	// Copy 16 bytes at the time if there is room in both src and dst.
+	// Subsequent writes will overwrite any excess bytes.
+	CMP  $16, R_LITN
+	BGT  emitLiteralMemmove
+	MOVD src_base+24(FP), R_TMP0
+	ADD  R_SLEN, R_TMP0, R_TMP0
+	SUB  R_LITP, R_TMP0, R_TMP0
+	CMP  $16, R_TMP0
+	BLT  emitLiteralMemmove
+	MOVD dst_len+8(FP), R_TMP0
+	ADD  R_DBASE, R_TMP0, R_TMP0
+	SUB  R_DST, R_TMP0, R_TMP0
+	CMP  $16, R_TMP0
+	BLT  emitLiteralMemmove
+	LDP  (R_LITP), (R_TMP0, R_TMP1)
+	STP  (R_TMP0, R_TMP1), (R_DST)
+	ADD  R_LITN, R_DST, R_DST
+	B    emitLiteralDone
+
+emitLiteralMemmove:
+	// Call runtime·memmove(&dst[d], &lit[0], len(lit)).
+	// All registers must be saved across the call.
+	MOVD R_DST, argDst(RSP)
+	MOVD R_LITP, argSrc(RSP)
+	MOVD R_LITN, argLen(RSP)
+	ADD  R_LITN, R_DST, R_DST
+	MOVD R_DST, spillDst(RSP)
+	MOVD R_S, spillS(RSP)
+	MOVD R_NEXT, spillNext(RSP)
+	MOVD R_REP, spillRep(RSP)
+	MOVD R_CAND, spillCand(RSP)
+	MOVD R_CONT, spillCont(RSP)
+	MOVD R_BASE, spillBase(RSP)
+	CALL runtime·memmove(SB)
+	MOVD spillDst(RSP), R_DST
+	MOVD spillS(RSP), R_S
+	MOVD spillNext(RSP), R_NEXT
+	MOVD spillRep(RSP), R_REP
+	MOVD spillCand(RSP), R_CAND
+	MOVD spillCont(RSP), R_CONT
+	MOVD spillBase(RSP), R_BASE
+	LOAD_CONSTANTS
+
+emitLiteralDone:
+	CMP $contRepeat, R_CONT
+	BEQ repeatEmitLiteralDone
+	CMP $contMatch, R_CONT
+	BEQ matchLoop
+	B   returnLength
+
+// ----------------------------------------
+// emitCopy writes a copy with offset R_TMP4 and length R_LEN to R_DST.
+// R_DST is advanced, continues at R_CONT.
+emitCopy:
+	// if offset >= 65536
+	CMP $65536, R_TMP4
+	BLT emitCopy2B
+
+	// if length > 64
+	CMP $64, R_LEN
+	BLE emitCopy4BRemain
+
+	// Emit a length 64 copy, encoded as 5 bytes.
+	// dst[4..1] = offset
+	// dst[0] = 63<<2 | tagCopy4
+	MOVD $(63<<2|3), R_TMP0
+	MOVB R_TMP0, (R_DST)
+	MOVW R_TMP4, 1(R_DST)
+	ADD  $5, R_DST, R_DST
+	SUB  $64, R_LEN, R_LEN
+
+	// if length >= 4 { emit remaining as repeats }
+	CMP $4, R_LEN
+	BGE emitRepeat
+
+emitCopy4BRemain:
+	// if length == 0 { return }
+	CBZ R_LEN, emitCopyDone
+
+	// Emit a copy, offset encoded as 4 bytes.
+	// dst[0] = uint8(length-1)<<2 | tagCopy4
+	SUB  $1, R_LEN, R_TMP0
+	LSL  $2, R_TMP0, R_TMP0
+	ORR  $3, R_TMP0, R_TMP0
+	MOVB R_TMP0, (R_DST)
+	MOVW R_TMP4, 1(R_DST)
+	ADD  $5, R_DST, R_DST
+	B    emitCopyDone
+
+emitCopy2B:
+	// if length > 64
+	CMP $64, R_LEN
+	BLE emitCopy2BShort
+
+	// Emit a length 60 copy, encoded as 3 bytes.
+	// Emit remaining as repeat value (minimum 4 bytes).
+	// dst[2..1] = offset
+	// dst[0] = 59<<2 | tagCopy2
+	MOVD $(59<<2|2), R_TMP0
+	MOVB R_TMP0, (R_DST)
+	MOVH R_TMP4, 1(R_DST)
+	ADD  $3, R_DST, R_DST
+	SUB  $60, R_LEN, R_LEN
+	B    emitRepeat
+
+emitCopy2BShort:
+	// if length >= 12 || offset >= 2048
+	CMP $12, R_LEN
+	BGE emitCopy2BRemain
+	CMP $2048, R_TMP4
+	BGE emitCopy2BRemain
+
+	// Emit the remaining copy, encoded as 2 bytes.
+	// dst[1] = uint8(offset)
+	// dst[0] = uint8(offset>>8)<<5 | uint8(length-4)<<2 | tagCopy1
+	LSR  $8, R_TMP4, R_TMP0
+	LSL  $5, R_TMP0, R_TMP0
+	SUB  $4, R_LEN, R_TMP1
+	ORR  R_TMP1<<2, R_TMP0, R_TMP0
+	ORR  $1, R_TMP0, R_TMP0
+	MOVB R_TMP0, (R_DST)
+	MOVB R_TMP4, 1(R_DST)
+	ADD  $2, R_DST, R_DST
+	B    emitCopyDone
+
+emitCopy2BRemain:
+	// Emit the remaining copy, encoded as 3 bytes.
+	// dst[2..1] = offset
+	// dst[0] = uint8(length-1)<<2 | tagCopy2
+	SUB  $1, R_LEN, R_TMP0
+	LSL  $2, R_TMP0, R_TMP0
+	ORR  $2, R_TMP0, R_TMP0
+	MOVB R_TMP0, (R_DST)
+	MOVH R_TMP4, 1(R_DST)
+	ADD  $3, R_DST, R_DST
+	B    emitCopyDone
+
+// ----------------------------------------
+// emitRepeat writes a repeat with offset R_TMP4 and length R_LEN to R_DST.
+// R_DST is advanced, continues at R_CONT.
+emitRepeat:
+	// length -= 4
+	SUB $4, R_LEN, R_LEN
+
+	// if length <= 4
+	CMP $4, R_LEN
+	BGT emitRepeatOffset
+
+	// dst[0] = uint8(length)<<2 | tagCopy1
+	// dst[1] = 0
+	LSL  $2, R_LEN, R_TMP0
+	ORR  $1, R_TMP0, R_TMP0
+	MOVH R_TMP0, (R_DST)
+	ADD  $2, R_DST, R_DST
+	B    emitCopyDone
+
+emitRepeatOffset:
+	// if length < 8 && offset < 2048
+	CMP $8, R_LEN
+	BGE emitRepeat1B
+	CMP $2048, R_TMP4
+	BGE emitRepeat1B
+
+	// Encode WITH offset
+	// dst[1] = uint8(offset)
+	// dst[0] = uint8(offset>>8)<<5 | uint8(length)<<2 | tagCopy1
+	LSR  $8, R_TMP4, R_TMP0
+	LSL  $5, R_TMP0, R_TMP0
+	ORR  R_LEN<<2, R_TMP0, R_TMP0
+	ORR  $1, R_TMP0, R_TMP0
+	MOVB R_TMP0, (R_DST)
+	MOVB R_TMP4, 1(R_DST)
+	ADD  $2, R_DST, R_DST
+	B    emitCopyDone
+
+emitRepeat1B:
+	// if length < (1<<8)+4
+	CMP $260, R_LEN
+	BGE emitRepeat2B
+
+	// length -= 4
+	// dst[2] = uint8(length)
+	// dst[1] = 0
+	// dst[0] = 5<<2 | tagCopy1
+	SUB  $4, R_LEN, R_TMP0
+	LSL  $16, R_TMP0, R_TMP0
+	ORR  $(5<<2|1), R_TMP0, R_TMP0
+	MOVH R_TMP0, (R_DST)
+	LSR  $16, R_TMP0, R_TMP0
+	MOVB R_TMP0, 2(R_DST)
+	ADD  $3, R_DST, R_DST
+	B    emitCopyDone
+
+emitRepeat2B:
+	// if length < (1<<16)+(1<<8)
+	MOVD $65792, R_TMP0
+	CMP  R_TMP0, R_LEN
+	BGE  emitRepeat3B
+
+	// length -= 1 << 8
+	// dst[3] = uint8(length >> 8)
+	// dst[2] = uint8(length >> 0)
+	// dst[1] = 0
+	// dst[0] = 6<<2 | tagCopy1
+	SUB  $256, R_LEN, R_TMP0
+	LSL  $16, R_TMP0, R_TMP0
+	ORR  $(6<<2|1), R_TMP0, R_TMP0
+	MOVW R_TMP0, (R_DST)
+	ADD  $4, R_DST, R_DST
+	B    emitCopyDone
+
+emitRepeat3B:
+	// length -= 1 << 16
+	SUB $65536, R_LEN, R_LEN
+
+	// left := 0
+	// if length > maxRepeat {
+	//	left = length - maxRepeat + 4
+	//	length = maxRepeat - 4
+	// }
+	MOVD $0, R_TMP1
+	MOVD $16777215, R_TMP0
+	CMP  R_TMP0, R_LEN
+	BLE  emitRepeat3BWrite
+	SUB  R_TMP0, R_LEN, R_TMP1
+	ADD  $4, R_TMP1, R_TMP1
+	SUB  $4, R_TMP0, R_LEN
+
+emitRepeat3BWrite:
+	// dst[4] = uint8(length >> 16)
+	// dst[3] = uint8(length >> 8)
+	// dst[2] = uint8(length >> 0)
+	// dst[1] = 0
+	// dst[0] = 7<<2 | tagCopy1
+	LSL  $16, R_LEN, R_TMP0
+	ORR  $(7<<2|1), R_TMP0, R_TMP0
+	MOVW R_TMP0, (R_DST)
+	LSR  $16, R_LEN, R_TMP0
+	MOVB R_TMP0, 4(R_DST)
+	ADD  $5, R_DST, R_DST
+
+	// if left > 0 { emitRepeat(dst[5:], offset, left) }
+	CBZ  R_TMP1, emitCopyDone
+	MOVD R_TMP1, R_LEN
+	B    emitRepeat
+
+emitCopyDone:
+	CMP $contRepeat, R_CONT
+	BEQ repeatEmitCopyDone
+	B   matchEmitCopyDone
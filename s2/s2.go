@@ -83,6 +83,10 @@ const (
 	// this is the maximum uncompressed size of a block.
 	maxBlockSize = 4 << 20
 
+	// maxSnappyBlockSize is the maximum uncompressed block size
+	// allowed by the Snappy framing format.
+	maxSnappyBlockSize = 64 << 10
+
 	// minBlockSize is the minimum size of block setting when creating a writer.
 	minBlockSize = 4 << 10
 
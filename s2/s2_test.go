@@ -8,6 +8,7 @@ package s2
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -20,6 +21,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/klauspost/compress/snappy"
@@ -651,6 +653,86 @@ func TestSlowForwardCopyOverrun(t *testing.T) {
 	}
 }
 
+// TestDecodeRepeatAfterLongLiteral tests that a repeat code uses the last
+// copy offset when it follows a literal long enough to be copied with memmove.
+func TestDecodeRepeatAfterLongLiteral(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, litLen := range []int{17, 100, 1000, 70000} {
+		first := make([]byte, 16)
+		lit := make([]byte, litLen)
+		rng.Read(first)
+		rng.Read(lit)
+
+		var want []byte
+		want = append(want, first...)
+		want = append(want, first...)
+		want = append(want, lit...)
+		want = append(want, want[len(want)-16:]...)
+
+		src := make([]byte, binary.MaxVarintLen64+MaxEncodedLen(len(want)))
+		d := binary.PutUvarint(src, uint64(len(want)))
+		d += emitLiteral(src[d:], first)
+		d += emitCopyNoRepeat(src[d:], 16, 16)
+		d += emitLiteral(src[d:], lit)
+		d += emitRepeat(src[d:], 16, 16)
+
+		got, err := Decode(nil, src[:d])
+		if err != nil {
+			t.Fatalf("literal length %d: %v", litLen, err)
+		}
+		if err := cmp(got, want); err != nil {
+			t.Fatalf("literal length %d: %v", litLen, err)
+		}
+	}
+}
+
+// TestEncodeBlockGo checks that encodeBlock decodes to the same data as
+// encodeBlockGo. Except on amd64 the output must also be identical.
+func TestEncodeBlockGo(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	exact := runtime.GOARCH != "amd64"
+	for _, size := range []int{minNonLiteralBlockSize, 100, 1000, 10000, 65536, 100000, 1 << 20} {
+		random := make([]byte, size)
+		rng.Read(random)
+		repeat := make([]byte, size)
+		for i := range repeat {
+			repeat[i] = byte(i % 13)
+		}
+		mixed := make([]byte, size)
+		for i := range mixed {
+			if i%100 < 30 {
+				mixed[i] = byte(rng.Intn(256))
+			} else {
+				mixed[i] = byte(i % 251)
+			}
+		}
+		for name, src := range map[string][]byte{"random": random, "repeat": repeat, "mixed": mixed} {
+			hdr := binary.PutUvarint(make([]byte, binary.MaxVarintLen64), uint64(len(src)))
+			dst := make([]byte, MaxEncodedLen(len(src)))
+			dstGo := make([]byte, MaxEncodedLen(len(src)))
+			n := encodeBlock(dst[hdr:], src)
+			nGo := encodeBlockGo(dstGo[hdr:], src)
+			if exact && !bytes.Equal(dst[hdr:hdr+n], dstGo[hdr:hdr+nGo]) {
+				t.Errorf("%s size %d: output differs from encodeBlockGo (%d vs %d bytes)", name, size, n, nGo)
+			}
+			for _, enc := range [][]byte{dst[:hdr+n], dstGo[:hdr+nGo]} {
+				if len(enc) == hdr {
+					// Incompressible.
+					continue
+				}
+				binary.PutUvarint(enc, uint64(len(src)))
+				got, err := Decode(nil, enc)
+				if err != nil {
+					t.Fatalf("%s size %d: %v", name, size, err)
+				}
+				if err := cmp(got, src); err != nil {
+					t.Fatalf("%s size %d: %v", name, size, err)
+				}
+			}
+		}
+	}
+}
+
 // TestEncoderSkip will test skipping various sizes and block types.
 func TestEncoderSkip(t *testing.T) {
 	for ti, origLen := range []int{10 << 10, 256 << 10, 2 << 20, 8 << 20} {
@@ -1131,6 +1213,187 @@ func TestWriterReset(t *testing.T) {
 	}
 }
 
+func TestWriterSkippableBlocks(t *testing.T) {
+	gold := bytes.Repeat([]byte("Not all those who wander are lost;\n"), 10000)
+	for _, conc := range []int{1, 4} {
+		t.Run(fmt.Sprint("concurrency-", conc), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, WriterConcurrency(conc), WriterBlockSize(64<<10), WriterPadding(1000))
+			if err := w.AddSkippableBlock(0x80, []byte("first")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(gold[:len(gold)/2]); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.AddSkippableBlock(0x81, []byte("ignored")); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.AddSkippableBlock(0x80, []byte("second")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(gold[len(gold)/2:]); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.AddSkippableBlock(0x7f, []byte("invalid")); err == nil {
+				t.Fatal("want error on invalid id")
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			compressed := buf.Bytes()
+
+			var got []string
+			var pos []int
+			var out bytes.Buffer
+			r := NewReader(bytes.NewReader(compressed), ReaderSkippableCB(0x80, func(sr io.Reader) error {
+				b, err := ioutil.ReadAll(sr)
+				got = append(got, string(b))
+				pos = append(pos, out.Len())
+				return err
+			}))
+			if _, err := io.Copy(&out, r); err != nil {
+				t.Fatal(err)
+			}
+			if err := cmp(out.Bytes(), gold); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 || got[0] != "first" || got[1] != "second" {
+				t.Fatalf("unexpected skippable blocks: %q", got)
+			}
+			if pos[0] != 0 || pos[1] != len(gold)/2 {
+				t.Fatalf("unexpected skippable block positions: %v", pos)
+			}
+
+			// Callback errors should be returned.
+			wantErr := errors.New("callback error")
+			r = NewReader(bytes.NewReader(compressed), ReaderSkippableCB(0x80, func(sr io.Reader) error {
+				return wantErr
+			}))
+			if _, err := io.Copy(ioutil.Discard, r); err != wantErr {
+				t.Fatalf("got %v, want %v", err, wantErr)
+			}
+
+			// Skipping should pass skippable blocks.
+			got = got[:0]
+			r = NewReader(bytes.NewReader(compressed), ReaderSkippableCB(0x80, func(sr io.Reader) error {
+				got = append(got, "")
+				return nil
+			}))
+			if err := r.Skip(int64(len(gold) - 10)); err != nil {
+				t.Fatal(err)
+			}
+			rest, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cmp(rest, gold[len(gold)-10:]); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 {
+				t.Fatalf("want 2 callbacks, got %d", len(got))
+			}
+		})
+	}
+	r := NewReader(nil, ReaderSkippableCB(0xfe, nil))
+	if r.err == nil {
+		t.Fatal("want error on invalid id")
+	}
+	r.Reset(bytes.NewReader(nil))
+	if _, err := r.Read(make([]byte, 1)); err == nil || err == io.EOF {
+		t.Fatalf("want option error after Reset, got %v", err)
+	}
+}
+
+func TestWriterEncodeBufferCB(t *testing.T) {
+	gold := bytes.Repeat([]byte("Not all those who wander are lost;\n"), 10000)
+	for _, conc := range []int{1, 4} {
+		t.Run(fmt.Sprint("concurrency-", conc), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, WriterConcurrency(conc), WriterBlockSize(64<<10))
+			src := make([]byte, len(gold))
+			for i := 0; i < 3; i++ {
+				copy(src, gold)
+				done := make(chan struct{})
+				if err := w.EncodeBufferCB(src, func() { close(done) }); err != nil {
+					t.Fatal(err)
+				}
+				<-done
+				// Overwrite after done has been called.
+				for i := range src {
+					src[i] = 0
+				}
+			}
+			var called int32
+			if err := w.EncodeBufferCB(nil, func() { atomic.AddInt32(&called, 1) }); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.EncodeBufferCB(gold, func() { atomic.AddInt32(&called, 1) }); err == nil {
+				t.Fatal("want error after close")
+			}
+			if atomic.LoadInt32(&called) != 2 {
+				t.Fatalf("want 2 callbacks, got %d", called)
+			}
+			got, err := ioutil.ReadAll(NewReader(&buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := bytes.Repeat(gold, 3)
+			if err := cmp(got, want); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestWriterSnappyCompat(t *testing.T) {
+	gold := readFile(t, "testdata/Mark.Twain-Tom.Sawyer.txt")
+	gold = append(gold, make([]byte, 100<<10)...)
+	for _, better := range []bool{false, true} {
+		for _, conc := range []int{1, 4} {
+			t.Run(fmt.Sprintf("better-%v-concurrency-%d", better, conc), func(t *testing.T) {
+				opts := []WriterOption{WriterSnappyCompat(), WriterConcurrency(conc), WriterPadding(1000)}
+				if better {
+					opts = append(opts, WriterBetterCompression())
+				}
+				var buf bytes.Buffer
+				w := NewWriter(&buf, opts...)
+				if _, err := w.Write(gold); err != nil {
+					t.Fatal(err)
+				}
+				// Also test unbuffered input.
+				if err := w.EncodeBuffer(gold); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				want := append(append([]byte{}, gold...), gold...)
+				if !bytes.HasPrefix(buf.Bytes(), []byte(magicChunkSnappy)) {
+					t.Fatal("missing snappy stream identifier")
+				}
+				got, err := ioutil.ReadAll(snappy.NewReader(bytes.NewReader(buf.Bytes())))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := cmp(got, want); err != nil {
+					t.Fatal(err)
+				}
+				got, err = ioutil.ReadAll(NewReader(bytes.NewReader(buf.Bytes())))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := cmp(got, want); err != nil {
+					t.Fatal(err)
+				}
+				t.Log(len(want), "->", buf.Len())
+			})
+		}
+	}
+}
+
 func TestWriterResetWithoutFlush(t *testing.T) {
 	buf0 := new(bytes.Buffer)
 	buf1 := new(bytes.Buffer)
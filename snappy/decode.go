@@ -74,11 +74,39 @@ func Decode(dst, src []byte) ([]byte, error) {
 // NewReader returns a new Reader that decompresses from r, using the framing
 // format described at
 // https://github.com/google/snappy/blob/master/framing_format.txt
-func NewReader(r io.Reader) *Reader {
-	return &Reader{
-		r:       r,
-		decoded: make([]byte, maxBlockSize),
-		buf:     make([]byte, maxEncodedLenOfMaxBlockSize+checksumSize),
+func NewReader(r io.Reader, opts ...ReaderOption) *Reader {
+	nr := Reader{
+		r:        r,
+		maxBlock: maxBlockSize,
+	}
+	for _, opt := range opts {
+		if err := opt(&nr); err != nil {
+			nr.optErr = err
+			nr.err = err
+			break
+		}
+	}
+	nr.decoded = make([]byte, nr.maxBlock)
+	nr.buf = make([]byte, MaxEncodedLen(nr.maxBlock)+checksumSize)
+	return &nr
+}
+
+// ReaderOption is an option for creating a decoder.
+type ReaderOption func(*Reader) error
+
+// ReaderMaxBlockSize limits the size of decoded blocks the Reader will accept.
+// This reduces the memory used by the Reader, which allocates buffers
+// for the maximum block size.
+// Streams containing bigger blocks will fail with ErrTooLarge.
+// n must be > 0 and <= 64KB, which is the default and the maximum
+// allowed by the framing format.
+func ReaderMaxBlockSize(n int) ReaderOption {
+	return func(r *Reader) error {
+		if n <= 0 || n > maxBlockSize {
+			return errors.New("snappy: max block size must be > 0 and <= 64KB")
+		}
+		r.maxBlock = n
+		return nil
 	}
 }
 
@@ -91,6 +119,10 @@ type Reader struct {
 	// decoded[i:j] contains decoded bytes that have not yet been passed on.
 	i, j       int
 	readHeader bool
+	maxBlock   int
+
+	// optErr is the error from an invalid option, kept on Reset.
+	optErr error
 }
 
 // Reset discards any buffered data, resets all state, and switches the Snappy
@@ -98,7 +130,7 @@ type Reader struct {
 // a new one.
 func (r *Reader) Reset(reader io.Reader) {
 	r.r = reader
-	r.err = nil
+	r.err = r.optErr
 	r.i = 0
 	r.j = 0
 	r.readHeader = false
@@ -114,6 +146,16 @@ func (r *Reader) readFull(p []byte, allowEOF bool) (ok bool) {
 	return true
 }
 
+// blockTooLarge sets the error for a block that decodes to n bytes,
+// which is more than the Reader accepts.
+func (r *Reader) blockTooLarge(n int) {
+	if n > maxBlockSize {
+		r.err = ErrCorrupt
+		return
+	}
+	r.err = ErrTooLarge
+}
+
 // Read satisfies the io.Reader interface.
 func (r *Reader) Read(p []byte) (int, error) {
 	if r.err != nil {
@@ -125,22 +167,100 @@ func (r *Reader) Read(p []byte) (int, error) {
 			r.i += n
 			return n, nil
 		}
-		if !r.readFull(r.buf[:4], true) {
+		if _, ok := r.nextBlock(0); !ok {
 			return 0, r.err
 		}
+	}
+}
+
+// WriteTo implements the io.WriterTo interface.
+// Decoded blocks are written directly to w without being copied
+// to an intermediate buffer.
+// Errors returned by w are not retained by the Reader.
+func (r *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	if r.err != nil {
+		if r.err == io.EOF {
+			return 0, nil
+		}
+		return 0, r.err
+	}
+	for {
+		if r.i < r.j {
+			n2, err := w.Write(r.decoded[r.i:r.j])
+			if err == nil && n2 != r.j-r.i {
+				err = io.ErrShortWrite
+			}
+			n += int64(n2)
+			r.i += n2
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+		if _, ok := r.nextBlock(0); !ok {
+			if r.err == io.EOF {
+				return n, nil
+			}
+			return n, r.err
+		}
+	}
+}
+
+// Skip will skip n bytes forward in the decompressed output.
+// For larger skips this consumes less CPU and is faster than reading output and discarding it.
+// CRC is not checked on skipped blocks.
+// io.ErrUnexpectedEOF is returned if the stream ends before all bytes have been skipped.
+// If a decoding error is encountered subsequent calls to Read will also fail.
+func (r *Reader) Skip(n int64) error {
+	if n < 0 {
+		return errors.New("snappy: attempted negative skip")
+	}
+	if r.err != nil {
+		return r.err
+	}
+	for n > 0 {
+		if r.i < r.j {
+			// Skip in buffer.
+			left := int64(r.j - r.i)
+			if left >= n {
+				r.i += int(n)
+				return nil
+			}
+			n -= left
+			r.i, r.j = 0, 0
+		}
+		skipped, ok := r.nextBlock(n)
+		if !ok {
+			if r.err == io.EOF {
+				r.err = io.ErrUnexpectedEOF
+			}
+			return r.err
+		}
+		n -= int64(skipped)
+	}
+	return nil
+}
+
+// nextBlock reads chunks until a block has been read and decoded,
+// so decoded[i:j] contains the block content.
+// If the entire block is within the next skip bytes, the block is not decoded
+// and its checksum is not verified. The size of the skipped block is returned
+// and decoded[i:j] will be empty.
+// If false is returned r.err has been set. It will be io.EOF at the end of the stream.
+func (r *Reader) nextBlock(skip int64) (skipped int, ok bool) {
+	for {
+		if !r.readFull(r.buf[:4], true) {
+			return 0, false
+		}
 		chunkType := r.buf[0]
 		if !r.readHeader {
 			if chunkType != chunkTypeStreamIdentifier {
 				r.err = ErrCorrupt
-				return 0, r.err
+				return 0, false
 			}
 			r.readHeader = true
 		}
 		chunkLen := int(r.buf[1]) | int(r.buf[2])<<8 | int(r.buf[3])<<16
-		if chunkLen > len(r.buf) {
-			r.err = ErrUnsupported
-			return 0, r.err
-		}
 
 		// The chunk types are specified at
 		// https://github.com/google/snappy/blob/master/framing_format.txt
@@ -149,11 +269,19 @@ func (r *Reader) Read(p []byte) (int, error) {
 			// Section 4.2. Compressed data (chunk type 0x00).
 			if chunkLen < checksumSize {
 				r.err = ErrCorrupt
-				return 0, r.err
+				return 0, false
+			}
+			if chunkLen > len(r.buf) {
+				if chunkLen > maxEncodedLenOfMaxBlockSize+checksumSize {
+					r.err = ErrUnsupported
+				} else {
+					r.err = ErrTooLarge
+				}
+				return 0, false
 			}
 			buf := r.buf[:chunkLen]
 			if !r.readFull(buf, false) {
-				return 0, r.err
+				return 0, false
 			}
 			checksum := uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16 | uint32(buf[3])<<24
 			buf = buf[checksumSize:]
@@ -161,63 +289,71 @@ func (r *Reader) Read(p []byte) (int, error) {
 			n, err := DecodedLen(buf)
 			if err != nil {
 				r.err = err
-				return 0, r.err
+				return 0, false
 			}
 			if n > len(r.decoded) {
-				r.err = ErrCorrupt
-				return 0, r.err
+				r.blockTooLarge(n)
+				return 0, false
+			}
+			if int64(n) <= skip {
+				r.i, r.j = 0, 0
+				return n, true
 			}
 			if _, err := Decode(r.decoded, buf); err != nil {
 				r.err = err
-				return 0, r.err
+				return 0, false
 			}
 			if crc(r.decoded[:n]) != checksum {
 				r.err = ErrCorrupt
-				return 0, r.err
+				return 0, false
 			}
 			r.i, r.j = 0, n
-			continue
+			return 0, true
 
 		case chunkTypeUncompressedData:
 			// Section 4.3. Uncompressed data (chunk type 0x01).
 			if chunkLen < checksumSize {
 				r.err = ErrCorrupt
-				return 0, r.err
+				return 0, false
 			}
 			buf := r.buf[:checksumSize]
 			if !r.readFull(buf, false) {
-				return 0, r.err
+				return 0, false
 			}
 			checksum := uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16 | uint32(buf[3])<<24
 			// Read directly into r.decoded instead of via r.buf.
 			n := chunkLen - checksumSize
 			if n > len(r.decoded) {
-				r.err = ErrCorrupt
-				return 0, r.err
+				r.blockTooLarge(n)
+				return 0, false
 			}
 			if !r.readFull(r.decoded[:n], false) {
-				return 0, r.err
+				return 0, false
+			}
+			if int64(n) <= skip {
+				r.i, r.j = 0, 0
+				return n, true
 			}
 			if crc(r.decoded[:n]) != checksum {
 				r.err = ErrCorrupt
-				return 0, r.err
+				return 0, false
 			}
 			r.i, r.j = 0, n
-			continue
+			return 0, true
 
 		case chunkTypeStreamIdentifier:
 			// Section 4.1. Stream identifier (chunk type 0xff).
 			if chunkLen != len(magicBody) {
 				r.err = ErrCorrupt
-				return 0, r.err
+				return 0, false
 			}
 			if !r.readFull(r.buf[:len(magicBody)], false) {
-				return 0, r.err
+				return 0, false
 			}
 			for i := 0; i < len(magicBody); i++ {
 				if r.buf[i] != magicBody[i] {
 					r.err = ErrCorrupt
-					return 0, r.err
+					return 0, false
 				}
 			}
 			continue
@@ -226,12 +362,20 @@ func (r *Reader) Read(p []byte) (int, error) {
 		if chunkType <= 0x7f {
 			// Section 4.5. Reserved unskippable chunks (chunk types 0x02-0x7f).
 			r.err = ErrUnsupported
-			return 0, r.err
+			return 0, false
 		}
 		// Section 4.4 Padding (chunk type 0xfe).
 		// Section 4.6. Reserved skippable chunks (chunk types 0x80-0xfd).
-		if !r.readFull(r.buf[:chunkLen], false) {
-			return 0, r.err
+		// These may be bigger than the buffer, so discard them in pieces.
+		for chunkLen > 0 {
+			n := chunkLen
+			if n > len(r.buf) {
+				n = len(r.buf)
+			}
+			if !r.readFull(r.buf[:n], false) {
+				return 0, false
+			}
+			chunkLen -= n
 		}
 	}
 }
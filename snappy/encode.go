@@ -5,9 +5,12 @@
 package snappy
 
 import (
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"sync"
 )
 
 // Encode returns the encoded form of src. The returned slice may be a sub-
@@ -117,10 +120,14 @@ var errClosed = errors.New("snappy: Writer is closed")
 // regardless of the frequency and shape of the writes, and remember to Close
 // that Writer when done.
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{
-		w:    w,
-		obuf: make([]byte, obufLen),
+	w2 := Writer{
+		blockSize:   maxBlockSize,
+		concurrency: 1,
+		obuf:        make([]byte, obufLen),
 	}
+	w2.init()
+	w2.Reset(w)
+	return &w2
 }
 
 // NewBufferedWriter returns a new Writer that compresses to w, using the
@@ -128,20 +135,33 @@ func NewWriter(w io.Writer) *Writer {
 // https://github.com/google/snappy/blob/master/framing_format.txt
 //
 // The Writer returned buffers writes. Users must call Close to guarantee all
-// data has been forwarded to the underlying io.Writer. They may also call
-// Flush zero or more times before calling Close.
-func NewBufferedWriter(w io.Writer) *Writer {
-	return &Writer{
-		w:    w,
-		ibuf: make([]byte, 0, maxBlockSize),
-		obuf: make([]byte, obufLen),
+// data has been forwarded to the underlying io.Writer and that resources
+// are released. They may also call Flush zero or more times before calling Close.
+//
+// By default blocks are compressed on the calling goroutine.
+// Use WriterConcurrency to compress blocks concurrently.
+func NewBufferedWriter(w io.Writer, opts ...WriterOption) *Writer {
+	w2 := Writer{
+		blockSize:   maxBlockSize,
+		concurrency: 1,
+		obuf:        make([]byte, obufLen),
+	}
+	for _, opt := range opts {
+		if err := opt(&w2); err != nil {
+			w2.errState = err
+			return &w2
+		}
 	}
+	w2.ibuf = make([]byte, 0, w2.blockSize)
+	w2.init()
+	w2.Reset(w)
+	return &w2
 }
 
 // Writer is an io.Writer that can write Snappy-compressed bytes.
 type Writer struct {
-	w   io.Writer
-	err error
+	errMu    sync.Mutex
+	errState error
 
 	// ibuf is a buffer for the incoming (uncompressed) bytes.
 	//
@@ -151,21 +171,108 @@ type Writer struct {
 	ibuf []byte
 
 	// obuf is a buffer for the outgoing (compressed) bytes.
+	// It is used when blocks are compressed on the calling goroutine.
 	obuf []byte
 
+	blockSize   int
+	concurrency int
+	written     int64
+	output      chan chan result
+	buffers     sync.Pool
+	pad         int
+
+	writer   io.Writer
+	writerWg sync.WaitGroup
+
 	// wroteStreamHeader is whether we have written the stream header.
 	wroteStreamHeader bool
+	paramsOK          bool
+}
+
+type result []byte
+
+// init marks the parameters as valid and sets up the buffer pool.
+func (w *Writer) init() {
+	w.paramsOK = true
+	w.buffers.New = func() interface{} {
+		return make([]byte, obufLen)
+	}
+}
+
+// err returns the previously set error.
+// If no error has been set it is set to err if not nil.
+func (w *Writer) err(err error) error {
+	w.errMu.Lock()
+	errSet := w.errState
+	if errSet == nil && err != nil {
+		w.errState = err
+		errSet = err
+	}
+	w.errMu.Unlock()
+	return errSet
 }
 
 // Reset discards the writer's state and switches the Snappy writer to write to
 // w. This permits reusing a Writer rather than allocating a new one.
 func (w *Writer) Reset(writer io.Writer) {
-	w.w = writer
-	w.err = nil
+	if !w.paramsOK {
+		return
+	}
+	// Close previous writer, if any.
+	if w.output != nil {
+		close(w.output)
+		w.writerWg.Wait()
+		w.output = nil
+	}
+	w.errState = nil
 	if w.ibuf != nil {
 		w.ibuf = w.ibuf[:0]
 	}
 	w.wroteStreamHeader = false
+	w.written = 0
+	w.writer = writer
+	// If we didn't get a writer, stop here.
+	if writer == nil {
+		return
+	}
+	// If no concurrency requested, don't spin up writer goroutine.
+	if w.concurrency == 1 {
+		return
+	}
+
+	toWrite := make(chan chan result, w.concurrency)
+	w.output = toWrite
+	w.writerWg.Add(1)
+
+	// Start a writer goroutine that will write all output in order.
+	go func() {
+		defer w.writerWg.Done()
+
+		// Get a queued write.
+		for write := range toWrite {
+			// Wait for the data to be available.
+			in := <-write
+			if len(in) > 0 {
+				if w.err(nil) == nil {
+					// Don't expose data from previous buffers.
+					toWrite := in[:len(in):len(in)]
+					// Write to output.
+					n, err := writer.Write(toWrite)
+					if err == nil && n != len(toWrite) {
+						err = io.ErrShortBuffer
+					}
+					_ = w.err(err)
+					w.written += int64(n)
+				}
+			}
+			if cap(in) >= obufLen {
+				w.buffers.Put([]byte(in))
+			}
+			// close the incoming write request.
+			// This can be used for synchronizing flushes.
+			close(write)
+		}
+	}()
 }
 
 // Write satisfies the io.Writer interface.
@@ -181,7 +288,7 @@ func (w *Writer) Write(p []byte) (nRet int, errRet error) {
 	// The remainder of this method is based on bufio.Writer.Write from the
 	// standard library.
 
-	for len(p) > (cap(w.ibuf)-len(w.ibuf)) && w.err == nil {
+	for len(p) > (cap(w.ibuf)-len(w.ibuf)) && w.err(nil) == nil {
 		var n int
 		if len(w.ibuf) == 0 {
 			// Large write, empty buffer.
@@ -190,13 +297,14 @@ func (w *Writer) Write(p []byte) (nRet int, errRet error) {
 		} else {
 			n = copy(w.ibuf[len(w.ibuf):cap(w.ibuf)], p)
 			w.ibuf = w.ibuf[:len(w.ibuf)+n]
-			w.Flush()
+			w.write(w.ibuf)
+			w.ibuf = w.ibuf[:0]
 		}
 		nRet += n
 		p = p[n:]
 	}
-	if w.err != nil {
-		return nRet, w.err
+	if err := w.err(nil); err != nil {
+		return nRet, err
 	}
 	n := copy(w.ibuf[len(w.ibuf):cap(w.ibuf)], p)
 	w.ibuf = w.ibuf[:len(w.ibuf)+n]
@@ -204,9 +312,208 @@ func (w *Writer) Write(p []byte) (nRet int, errRet error) {
 	return nRet, nil
 }
 
+// ReadFrom implements the io.ReaderFrom interface.
+// Using this is typically more efficient since it avoids a memory copy.
+// ReadFrom reads data from r until EOF or error.
+// The return value n is the number of bytes read.
+// Any error except io.EOF encountered during the read is also returned.
+func (w *Writer) ReadFrom(r io.Reader) (n int64, err error) {
+	if len(w.ibuf) > 0 {
+		err := w.Flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+	for {
+		inbuf := w.buffers.Get().([]byte)[:w.blockSize+chunkHeaderSize+checksumSize]
+		n2, err := io.ReadFull(r, inbuf[chunkHeaderSize+checksumSize:])
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			if err != io.EOF {
+				return n, w.err(err)
+			}
+		}
+		if n2 == 0 {
+			break
+		}
+		n += int64(n2)
+		err2 := w.writeFull(inbuf[:n2+chunkHeaderSize+checksumSize])
+		if w.err(err2) != nil {
+			break
+		}
+
+		if err != nil {
+			// We got EOF and wrote everything
+			break
+		}
+	}
+
+	return n, w.err(nil)
+}
+
+// EncodeBuffer will add a buffer to the stream.
+// This is the fastest way to encode a stream,
+// but the input buffer cannot be written to by the caller
+// until Flush or Close has been called.
+//
+// Note that input is not buffered.
+// This means that each write will result in discrete blocks being created.
+// For buffered writes, use the regular Write function.
+func (w *Writer) EncodeBuffer(buf []byte) (err error) {
+	if err := w.err(nil); err != nil {
+		return err
+	}
+
+	// Flush queued data first.
+	if len(w.ibuf) > 0 {
+		err := w.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	if w.concurrency == 1 {
+		_, err := w.writeSync(buf)
+		return err
+	}
+
+	// Spawn goroutine and write block to output channel.
+	for len(buf) > 0 {
+		w.queueStreamHeader()
+
+		// Cut input.
+		uncompressed := buf
+		if len(uncompressed) > w.blockSize {
+			uncompressed = uncompressed[:w.blockSize]
+		}
+		buf = buf[len(uncompressed):]
+		// Get an output buffer.
+		obuf := w.buffers.Get().([]byte)
+		output := make(chan result)
+		// Queue output now, so we keep order.
+		w.output <- output
+		go func() {
+			output <- encodeChunk(obuf, uncompressed)
+		}()
+	}
+	return nil
+}
+
+// queueStreamHeader queues the stream header if it hasn't been written.
+// Should only be used when concurrency is > 1.
+func (w *Writer) queueStreamHeader() {
+	if !w.wroteStreamHeader {
+		w.wroteStreamHeader = true
+		hWriter := make(chan result)
+		w.output <- hWriter
+		hWriter <- []byte(magicChunk)
+	}
+}
+
+// encodeChunk writes the uncompressed block as a chunk to obuf and returns it.
+// The block is stored uncompressed if compression doesn't save at least 12.5%.
+// The capacity of obuf must be at least obufLen.
+func encodeChunk(obuf, uncompressed []byte) []byte {
+	const hdrLen = chunkHeaderSize + checksumSize
+	checksum := crc(uncompressed)
+
+	// Compress the buffer, discarding the result if the improvement
+	// isn't at least 12.5%.
+	compressed := Encode(obuf[hdrLen:cap(obuf)], uncompressed)
+	chunkType := uint8(chunkTypeCompressedData)
+	chunkLen := 4 + len(compressed)
+	obuf = obuf[:hdrLen+len(compressed)]
+	if len(compressed) >= len(uncompressed)-len(uncompressed)/8 {
+		chunkType = chunkTypeUncompressedData
+		chunkLen = 4 + len(uncompressed)
+		obuf = append(obuf[:hdrLen], uncompressed...)
+	}
+
+	// Fill in the per-chunk header that comes before the body.
+	obuf[0] = chunkType
+	obuf[1] = uint8(chunkLen >> 0)
+	obuf[2] = uint8(chunkLen >> 8)
+	obuf[3] = uint8(chunkLen >> 16)
+	obuf[4] = uint8(checksum >> 0)
+	obuf[5] = uint8(checksum >> 8)
+	obuf[6] = uint8(checksum >> 16)
+	obuf[7] = uint8(checksum >> 24)
+	return obuf
+}
+
 func (w *Writer) write(p []byte) (nRet int, errRet error) {
-	if w.err != nil {
-		return 0, w.err
+	if err := w.err(nil); err != nil {
+		return 0, err
+	}
+	if w.concurrency == 1 {
+		return w.writeSync(p)
+	}
+
+	// Spawn goroutine and write block to output channel.
+	for len(p) > 0 {
+		w.queueStreamHeader()
+
+		var uncompressed []byte
+		if len(p) > w.blockSize {
+			uncompressed, p = p[:w.blockSize], p[w.blockSize:]
+		} else {
+			uncompressed, p = p, nil
+		}
+
+		// Copy input.
+		inbuf := w.buffers.Get().([]byte)[:len(uncompressed)]
+		copy(inbuf, uncompressed)
+		obuf := w.buffers.Get().([]byte)
+
+		output := make(chan result)
+		// Queue output now, so we keep order.
+		w.output <- output
+		go func() {
+			output <- encodeChunk(obuf, inbuf)
+			// Put unused buffer back in pool.
+			w.buffers.Put(inbuf)
+		}()
+		nRet += len(uncompressed)
+	}
+	return nRet, nil
+}
+
+// writeFull is a special version of write that will always write the full buffer.
+// Data to be compressed should start at offset chunkHeaderSize+checksumSize and
+// fill the remainder of the buffer.
+// The data will be written as a single block.
+// The caller is not allowed to use inbuf after this function has been called.
+func (w *Writer) writeFull(inbuf []byte) (errRet error) {
+	if err := w.err(nil); err != nil {
+		return err
+	}
+	uncompressed := inbuf[chunkHeaderSize+checksumSize:]
+	if w.concurrency == 1 {
+		_, err := w.writeSync(uncompressed)
+		w.buffers.Put(inbuf)
+		return err
+	}
+
+	w.queueStreamHeader()
+
+	// Get an output buffer.
+	obuf := w.buffers.Get().([]byte)
+
+	output := make(chan result)
+	// Queue output now, so we keep order.
+	w.output <- output
+	go func() {
+		output <- encodeChunk(obuf, uncompressed)
+		// Put unused buffer back in pool.
+		w.buffers.Put(inbuf)
+	}()
+	return nil
+}
+
+func (w *Writer) writeSync(p []byte) (nRet int, errRet error) {
+	if err := w.err(nil); err != nil {
+		return 0, err
 	}
 	for len(p) > 0 {
 		obufStart := len(magicChunk)
@@ -217,8 +524,8 @@ func (w *Writer) write(p []byte) (nRet int, errRet error) {
 		}
 
 		var uncompressed []byte
-		if len(p) > maxBlockSize {
-			uncompressed, p = p[:maxBlockSize], p[maxBlockSize:]
+		if len(p) > w.blockSize {
+			uncompressed, p = p[:w.blockSize], p[w.blockSize:]
 		} else {
 			uncompressed, p = p, nil
 		}
@@ -246,13 +553,11 @@ func (w *Writer) write(p []byte) (nRet int, errRet error) {
 		w.obuf[len(magicChunk)+6] = uint8(checksum >> 16)
 		w.obuf[len(magicChunk)+7] = uint8(checksum >> 24)
 
-		if _, err := w.w.Write(w.obuf[obufStart:obufEnd]); err != nil {
-			w.err = err
+		if err := w.writeSyncRaw(w.obuf[obufStart:obufEnd]); err != nil {
 			return nRet, err
 		}
 		if chunkType == chunkTypeUncompressedData {
-			if _, err := w.w.Write(uncompressed); err != nil {
-				w.err = err
+			if err := w.writeSyncRaw(uncompressed); err != nil {
 				return nRet, err
 			}
 		}
@@ -261,25 +566,161 @@ func (w *Writer) write(p []byte) (nRet int, errRet error) {
 	return nRet, nil
 }
 
+// writeSyncRaw writes b directly to the output.
+// Should only be used when concurrency is 1.
+func (w *Writer) writeSyncRaw(b []byte) error {
+	n, err := w.writer.Write(b)
+	if err != nil {
+		return w.err(err)
+	}
+	if n != len(b) {
+		return w.err(io.ErrShortWrite)
+	}
+	w.written += int64(n)
+	return nil
+}
+
 // Flush flushes the Writer to its underlying io.Writer.
+// This does not apply padding.
 func (w *Writer) Flush() error {
-	if w.err != nil {
-		return w.err
+	if err := w.err(nil); err != nil {
+		return err
 	}
-	if len(w.ibuf) == 0 {
-		return nil
+
+	// Queue any data still in input buffer.
+	if len(w.ibuf) != 0 {
+		_, err := w.write(w.ibuf)
+		w.ibuf = w.ibuf[:0]
+		err = w.err(err)
+		if err != nil {
+			return err
+		}
 	}
-	w.write(w.ibuf)
-	w.ibuf = w.ibuf[:0]
-	return w.err
+	if w.output == nil {
+		return w.err(nil)
+	}
+
+	// Send empty buffer
+	res := make(chan result)
+	w.output <- res
+	// Block until this has been picked up.
+	res <- nil
+	// When it is closed, we have flushed.
+	<-res
+	return w.err(nil)
 }
 
 // Close calls Flush and then closes the Writer.
+// Calling Close multiple times is ok.
 func (w *Writer) Close() error {
-	w.Flush()
-	ret := w.err
-	if w.err == nil {
-		w.err = errClosed
+	err := w.Flush()
+	if w.output != nil {
+		close(w.output)
+		w.writerWg.Wait()
+		w.output = nil
+	}
+	if w.err(nil) == nil && w.writer != nil && w.pad > 0 {
+		add := calcPaddingFrame(w.written, int64(w.pad))
+		frame, err := paddingFrame(nil, add, rand.Reader)
+		if err = w.err(err); err != nil {
+			return err
+		}
+		_, err2 := w.writer.Write(frame)
+		_ = w.err(err2)
+	}
+	_ = w.err(errClosed)
+	if err == errClosed {
+		return nil
+	}
+	return err
+}
+
+// calcPaddingFrame will return a total size to be added for written
+// to be divisible by multiple.
+// The value will always be > chunkHeaderSize.
+func calcPaddingFrame(written, wantMultiple int64) int {
+	leftOver := written % wantMultiple
+	if leftOver == 0 {
+		return 0
+	}
+	toAdd := wantMultiple - leftOver
+	for toAdd < chunkHeaderSize {
+		toAdd += wantMultiple
+	}
+	return int(toAdd)
+}
+
+// paddingFrame will add a padding frame with a total size of bytes.
+// total should be >= chunkHeaderSize.
+func paddingFrame(dst []byte, total int, r io.Reader) ([]byte, error) {
+	if total == 0 {
+		return dst, nil
+	}
+	if total < chunkHeaderSize {
+		return dst, fmt.Errorf("snappy: requested padding frame (%d) < 4", total)
+	}
+	// Chunk type 0xfe "Section 4.4 Padding (chunk type 0xfe)"
+	dst = append(dst, chunkTypePadding)
+	f := uint32(total - chunkHeaderSize)
+	// Add chunk length.
+	dst = append(dst, uint8(f), uint8(f>>8), uint8(f>>16))
+	// Add data
+	start := len(dst)
+	dst = append(dst, make([]byte, f)...)
+	_, err := io.ReadFull(r, dst[start:])
+	return dst, err
+}
+
+// WriterOption is an option for creating a buffered Writer.
+type WriterOption func(*Writer) error
+
+// WriterConcurrency will set the concurrency,
+// meaning the maximum number of blocks to compress concurrently.
+// The value supplied must be at least 1.
+// By default blocks are compressed on the calling goroutine.
+func WriterConcurrency(n int) WriterOption {
+	return func(w *Writer) error {
+		if n <= 0 {
+			return errors.New("snappy: concurrency must be at least 1")
+		}
+		w.concurrency = n
+		return nil
+	}
+}
+
+// WriterBlockSize allows to override the default block size.
+// Blocks will be this size or smaller.
+// Minimum size is 4KB and maximum size is 64KB, which is also the default,
+// since the Snappy framing format does not allow bigger blocks.
+func WriterBlockSize(n int) WriterOption {
+	return func(w *Writer) error {
+		if n > maxBlockSize || n < minBlockSize {
+			return errors.New("snappy: block size must be <= 64KB and >= 4KB")
+		}
+		w.blockSize = n
+		return nil
+	}
+}
+
+// WriterPadding will add padding to all output so the size will be a multiple of n.
+// This can be used to obfuscate the exact output size or make blocks of a certain size.
+// The contents will be a padding chunk, so it will be invisible to the decoder.
+// n must be > 0 and <= 4MB.
+// The padded area will be filled with data from crypto/rand.Reader.
+// The padding will be applied whenever Close is called on the writer.
+func WriterPadding(n int) WriterOption {
+	return func(w *Writer) error {
+		if n <= 0 {
+			return fmt.Errorf("snappy: padding must be at least 1")
+		}
+		if n > maxPadding {
+			return fmt.Errorf("snappy: padding must less than 4MB")
+		}
+		// No need to waste our time.
+		if n == 1 {
+			n = 0
+		}
+		w.pad = n
+		return nil
 	}
-	return ret
 }
@@ -77,6 +77,12 @@ const (
 	// TestMaxEncodedLenOfMaxBlockSize.
 	maxEncodedLenOfMaxBlockSize = 76490
 
+	// minBlockSize is the minimum block size that can be set on a Writer.
+	minBlockSize = 4 << 10
+
+	// maxPadding is the maximum padding that can be set on a Writer.
+	maxPadding = 4 << 20
+
 	obufHeaderLen = len(magicChunk) + checksumSize + chunkHeaderSize
 	obufLen       = obufHeaderLen + maxEncodedLenOfMaxBlockSize
 )
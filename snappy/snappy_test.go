@@ -708,6 +708,64 @@ func TestEncodeNoiseThenRepeats(t *testing.T) {
 	}
 }
 
+func TestEncodeBetter(t *testing.T) {
+	tDir := filepath.FromSlash(*testdataDir)
+	files, err := ioutil.ReadDir(tDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if f.IsDir() || strings.HasSuffix(f.Name(), ".rawsnappy") {
+			continue
+		}
+		src, err := ioutil.ReadFile(filepath.Join(tDir, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(f.Name(), func(t *testing.T) {
+			for _, n := range []int{0, 1, minNonLiteralBlockSize - 1, minNonLiteralBlockSize, 1000, maxBlockSize, len(src)} {
+				if n > len(src) {
+					continue
+				}
+				b := src[:n]
+				enc := EncodeBetter(nil, b)
+				dec, err := Decode(nil, enc)
+				if err != nil {
+					t.Fatalf("n=%d: %v", n, err)
+				}
+				if err := cmp(dec, b); err != nil {
+					t.Fatalf("n=%d: %v", n, err)
+				}
+				// Only compare sizes when the input is compressible.
+				if base := len(Encode(nil, b)); n == len(src) && base < n*15/16 && len(enc) > base {
+					t.Errorf("EncodeBetter size %d > Encode size %d", len(enc), base)
+				}
+			}
+		})
+	}
+
+	// Noise then repeats, as TestEncodeNoiseThenRepeats.
+	src := make([]byte, 256*1024)
+	rng := rand.New(rand.NewSource(1))
+	for i := range src[:len(src)/2] {
+		src[i] = uint8(rng.Intn(256))
+	}
+	for i := len(src) / 2; i < len(src); i++ {
+		src[i] = uint8(i >> 8)
+	}
+	enc := EncodeBetter(nil, src)
+	if got, want := len(enc), len(src)*3/4; got >= want {
+		t.Errorf("got %d encoded bytes, want less than %d", got, want)
+	}
+	dec, err := Decode(nil, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmp(dec, src); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestFramingFormat(t *testing.T) {
 	// src is comprised of alternating 1e5-sized sequences of random
 	// (incompressible) bytes and repeated (compressible) bytes. 1e5 was chosen
@@ -955,6 +1013,75 @@ func TestFlush(t *testing.T) {
 	}
 }
 
+func TestWriterOptions(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	input := make([]byte, 1<<20)
+	for i := range input {
+		// Mix compressible and random data.
+		if i&(64<<10) == 0 {
+			input[i] = byte(i / 1000)
+		} else {
+			input[i] = byte(rng.Intn(256))
+		}
+	}
+	testCases := map[string][]WriterOption{
+		"default":     nil,
+		"concurrency": {WriterConcurrency(4)},
+		"blocksize":   {WriterConcurrency(4), WriterBlockSize(16 << 10)},
+		"pad":         {WriterConcurrency(1), WriterPadding(64 << 10)},
+		"pad-conc":    {WriterConcurrency(8), WriterBlockSize(4 << 10), WriterPadding(1000)},
+		"pad-big":     {WriterConcurrency(2), WriterPadding(3 << 20)},
+	}
+	for name, opts := range testCases {
+		for _, method := range []string{"Write", "ReadFrom", "EncodeBuffer"} {
+			t.Run(name+"-"+method, func(t *testing.T) {
+				var buf bytes.Buffer
+				w := NewBufferedWriter(&buf, opts...)
+				var err error
+				switch method {
+				case "Write":
+					for i := 0; i < len(input) && err == nil; i += 10000 {
+						end := i + 10000
+						if end > len(input) {
+							end = len(input)
+						}
+						_, err = w.Write(input[i:end])
+					}
+				case "ReadFrom":
+					_, err = w.ReadFrom(bytes.NewReader(input))
+				case "EncodeBuffer":
+					err = w.EncodeBuffer(input)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if w.pad > 0 && buf.Len()%w.pad != 0 {
+					t.Errorf("output size %d is not a multiple of %d", buf.Len(), w.pad)
+				}
+				if !bytes.HasPrefix(buf.Bytes(), []byte(magicChunk)) {
+					t.Fatal("stream does not start with Snappy stream identifier")
+				}
+				got, err := ioutil.ReadAll(NewReader(&buf))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := cmp(got, input); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+	for _, opt := range []WriterOption{WriterConcurrency(0), WriterBlockSize(1 << 20), WriterBlockSize(1000), WriterPadding(0), WriterPadding(8 << 20)} {
+		w := NewBufferedWriter(ioutil.Discard, opt)
+		if _, err := w.Write([]byte("hello")); err == nil {
+			t.Error("expected error from invalid option")
+		}
+	}
+}
+
 func TestReaderUncompressedDataOK(t *testing.T) {
 	r := NewReader(strings.NewReader(magicChunk +
 		"\x01\x08\x00\x00" + // Uncompressed chunk, 8 bytes long (including 4 byte checksum).
@@ -994,6 +1121,121 @@ func TestReaderUncompressedDataTooLong(t *testing.T) {
 	}
 }
 
+func TestReaderSkip(t *testing.T) {
+	input := make([]byte, 500000)
+	rng := rand.New(rand.NewSource(1))
+	for i := range input {
+		input[i] = byte(rng.Intn(4))
+	}
+	var buf bytes.Buffer
+	w := NewBufferedWriter(&buf)
+	if _, err := w.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, skip := range []int64{0, 1, 100, maxBlockSize - 1, maxBlockSize, maxBlockSize + 1, 300000, int64(len(input)) - 10} {
+		r := NewReader(bytes.NewReader(buf.Bytes()))
+		// Read a little first, so Skip starts within a block.
+		var first [10]byte
+		if _, err := io.ReadFull(r, first[:]); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Skip(skip); err != nil {
+			t.Fatalf("skip %d: %v", skip, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("skip %d: %v", skip, err)
+		}
+		if err := cmp(got, input[min64(skip+10, int64(len(input))):]); err != nil {
+			t.Fatalf("skip %d: %v", skip, err)
+		}
+	}
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	if err := r.Skip(int64(len(input)) + 1); err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestReaderWriteTo(t *testing.T) {
+	input := bytes.Repeat([]byte("Not all those who wander are lost;\n"), 10000)
+	var buf bytes.Buffer
+	w := NewBufferedWriter(&buf, WriterPadding(1<<20))
+	if _, err := w.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	var got bytes.Buffer
+	n, err := r.WriteTo(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(input)) {
+		t.Fatalf("got %d bytes, want %d", n, len(input))
+	}
+	if err := cmp(got.Bytes(), input); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the stream.
+	b := append([]byte{}, buf.Bytes()...)
+	b[len(magicChunk)+10] ^= 0xff
+	r.Reset(bytes.NewReader(b))
+	if _, err := r.WriteTo(ioutil.Discard); err != ErrCorrupt {
+		t.Fatalf("got %v, want %v", err, ErrCorrupt)
+	}
+}
+
+func TestReaderMaxBlockSize(t *testing.T) {
+	input := bytes.Repeat([]byte("Not all those who wander are lost;\n"), 10000)
+	for _, blockSize := range []int{4 << 10, 16 << 10, maxBlockSize} {
+		var buf bytes.Buffer
+		w := NewBufferedWriter(&buf, WriterBlockSize(blockSize))
+		if _, err := w.Write(input); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for _, maxBlock := range []int{1 << 10, 16 << 10, maxBlockSize} {
+			r := NewReader(bytes.NewReader(buf.Bytes()), ReaderMaxBlockSize(maxBlock))
+			got, err := ioutil.ReadAll(r)
+			if blockSize > maxBlock {
+				if err != ErrTooLarge {
+					t.Errorf("block size %d, max %d: got %v, want %v", blockSize, maxBlock, err, ErrTooLarge)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("block size %d, max %d: %v", blockSize, maxBlock, err)
+			}
+			if err := cmp(got, input); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	r := NewReader(nil, ReaderMaxBlockSize(maxBlockSize+1))
+	if _, err := r.Read(make([]byte, 10)); err == nil {
+		t.Fatal("expected error from invalid option")
+	}
+	r.Reset(bytes.NewReader(nil))
+	if _, err := r.Read(make([]byte, 10)); err == nil || err == io.EOF {
+		t.Fatalf("want option error after Reset, got %v", err)
+	}
+}
+
 func TestReaderReset(t *testing.T) {
 	gold := bytes.Repeat([]byte("All that is gold does not glitter,\n"), 10000)
 	buf := new(bytes.Buffer)
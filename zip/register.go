@@ -65,7 +65,7 @@ func (w *pooledFlateWriter) Close() error {
 	return err
 }
 
-var flateReaderPool sync.Pool
+var flateReaderPool, flateReader64Pool sync.Pool
 
 func newFlateReader(r io.Reader) io.ReadCloser {
 	fr, ok := flateReaderPool.Get().(io.ReadCloser)
@@ -74,12 +74,23 @@ func newFlateReader(r io.Reader) io.ReadCloser {
 	} else {
 		fr = flate.NewReader(r)
 	}
-	return &pooledFlateReader{fr: fr}
+	return &pooledFlateReader{fr: fr, pool: &flateReaderPool}
+}
+
+func newFlateReader64(r io.Reader) io.ReadCloser {
+	fr, ok := flateReader64Pool.Get().(io.ReadCloser)
+	if ok {
+		fr.(flate.Resetter).Reset(r, nil)
+	} else {
+		fr = flate.NewReader64(r)
+	}
+	return &pooledFlateReader{fr: fr, pool: &flateReader64Pool}
 }
 
 type pooledFlateReader struct {
-	mu sync.Mutex // guards Close and Read
-	fr io.ReadCloser
+	mu   sync.Mutex // guards Close and Read
+	fr   io.ReadCloser
+	pool *sync.Pool // pool fr is returned to
 }
 
 func (r *pooledFlateReader) Read(p []byte) (n int, err error) {
@@ -97,7 +108,7 @@ func (r *pooledFlateReader) Close() error {
 	var err error
 	if r.fr != nil {
 		err = r.fr.Close()
-		flateReaderPool.Put(r.fr)
+		r.pool.Put(r.fr)
 		r.fr = nil
 	}
 	return err
@@ -114,10 +125,11 @@ func init() {
 
 	decompressors.Store(Store, Decompressor(ioutil.NopCloser))
 	decompressors.Store(Deflate, Decompressor(newFlateReader))
+	decompressors.Store(Deflate64, Decompressor(newFlateReader64))
 }
 
 // RegisterDecompressor allows custom decompressors for a specified method ID.
-// The common methods Store and Deflate are built in.
+// The common methods Store, Deflate and Deflate64 are built in.
 func RegisterDecompressor(method uint16, dcomp Decompressor) {
 	if _, dup := decompressors.LoadOrStore(method, dcomp); dup {
 		panic("decompressor already registered")
@@ -27,8 +27,9 @@ import (
 
 // Compression methods.
 const (
-	Store   uint16 = 0 // no compression
-	Deflate uint16 = 8 // DEFLATE compressed
+	Store     uint16 = 0 // no compression
+	Deflate   uint16 = 8 // DEFLATE compressed
+	Deflate64 uint16 = 9 // Deflate64 (enhanced deflate) compressed, decompression only
 )
 
 const (
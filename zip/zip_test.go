@@ -826,3 +826,59 @@ func (zeros) Read(p []byte) (int, error) {
 	}
 	return len(p), nil
 }
+
+// rawCompressor discards the input and writes stream on Close.
+type rawCompressor struct {
+	w      io.Writer
+	stream []byte
+}
+
+func (c *rawCompressor) Write(p []byte) (int, error) { return len(p), nil }
+func (c *rawCompressor) Close() error {
+	_, err := c.w.Write(c.stream)
+	return err
+}
+
+func TestDeflate64(t *testing.T) {
+	// Fixed Huffman block with a literal 'a' followed by
+	// length code 285 with 16 extra bits set to 99 and distance 1.
+	stream := []byte{0x4b, 0x1c, 0x1d, 0x03, 0x00, 0x00}
+	want := bytes.Repeat([]byte("a"), 1+3+99)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.RegisterCompressor(Deflate64, func(out io.Writer) (io.WriteCloser, error) {
+		return &rawCompressor{w: out, stream: stream}, nil
+	})
+	for i := 0; i < 2; i++ {
+		fw, err := w.CreateHeader(&FileHeader{Name: fmt.Sprint("file", i), Method: Deflate64})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(want)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		if err != nil {
+			t.Fatal(f.Name, err)
+		}
+		if err := rc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: got %q, want %q", f.Name, got, want)
+		}
+	}
+}
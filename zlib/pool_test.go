@@ -0,0 +1,118 @@
+package zlib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/flate"
+)
+
+func TestWriterPool(t *testing.T) {
+	if _, err := NewWriterPool(10, 0); err == nil {
+		t.Error("want error for invalid level")
+	}
+	for _, level := range []int{HuffmanOnly, BestSpeed} {
+		const max = 2
+		p, err := NewWriterPool(level, max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var w *Writer
+		for i := 0; i < 2; i++ {
+			var buf bytes.Buffer
+			zw := p.Get(&buf)
+			if i > 0 && zw != w {
+				t.Fatalf("level %d: writer was not reused", level)
+			}
+			w = zw
+			input := bytes.Repeat([]byte("payload "), 100+i)
+			w.Write(input)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			p.Put(w)
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, input) {
+				t.Errorf("level %d: output mismatch", level)
+			}
+		}
+		<-p.idle
+
+		// Writers with another level, a dictionary or options are not kept.
+		other, _ := NewWriterLevel(nil, BestCompression)
+		p.Put(other)
+		dict, _ := NewWriterLevelDict(nil, level, []byte("dictionary"))
+		p.Put(dict)
+		opts, _ := NewWriterLevel(nil, level)
+		if err := opts.SetOptions(flate.WithWindowBits(10)); err != nil {
+			t.Fatal(err)
+		}
+		p.Put(opts)
+		if len(p.idle) != 0 {
+			t.Errorf("level %d: %d idle writers, want 0", level, len(p.idle))
+		}
+
+		for i := 0; i < 2*max; i++ {
+			w, _ := NewWriterLevel(nil, level)
+			p.Put(w)
+		}
+		if len(p.idle) != max {
+			t.Errorf("level %d: %d idle writers, want %d", level, len(p.idle), max)
+		}
+	}
+}
+
+func TestReaderPool(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write([]byte("payload"))
+	w.Close()
+	compressed := buf.Bytes()
+
+	const max = 2
+	p := NewReaderPool(max)
+	zr, err := p.Get(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "payload" {
+		t.Errorf("got %q, want %q", got, "payload")
+	}
+	p.Put(zr)
+	if zr2, _ := p.Get(bytes.NewReader(compressed)); zr2 != zr {
+		t.Error("reader was not reused")
+	}
+
+	// Readers selecting from dictionaries are not kept.
+	dicts, err := NewReaderDicts(bytes.NewReader(compressed), map[uint32][]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(dicts)
+	if len(p.idle) != 0 {
+		t.Errorf("%d idle readers, want 0", len(p.idle))
+	}
+
+	for i := 0; i < 2*max; i++ {
+		zr, _ := NewReader(bytes.NewReader(compressed))
+		p.Put(zr)
+	}
+	if len(p.idle) != max {
+		t.Errorf("%d idle readers, want %d", len(p.idle), max)
+	}
+	if _, err := p.Get(bytes.NewReader([]byte("not compressed data"))); err == nil {
+		t.Error("want error for invalid header")
+	}
+}
@@ -50,6 +50,7 @@ type reader struct {
 	digest       hash.Hash32
 	err          error
 	scratch      [4]byte
+	dicts        map[uint32][]byte
 }
 
 // Resetter resets a ReadCloser returned by NewReader or NewReaderDict to
@@ -86,6 +87,59 @@ func NewReaderDict(r io.Reader, dict []byte) (io.ReadCloser, error) {
 	return z, nil
 }
 
+// NewReaderDicts is like NewReaderDict but selects the preset dictionary
+// requested by the compressed data from dicts, which is keyed by DictionaryID.
+// If the compressed data refers to a dictionary that is not in dicts,
+// NewReaderDicts returns ErrDictionary.
+//
+// The ReadCloser returned by NewReaderDicts also implements Resetter.
+// Reset selects from dicts if the compressed data does not refer to the
+// dictionary given to Reset.
+func NewReaderDicts(r io.Reader, dicts map[uint32][]byte) (io.ReadCloser, error) {
+	z := &reader{dicts: dicts}
+	err := z.Reset(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// DictionaryID returns the ID stored in the header of streams
+// compressed with dict, which is the Adler-32 checksum of dict.
+func DictionaryID(dict []byte) uint32 {
+	return adler32.Checksum(dict)
+}
+
+// PeekDictionaryID returns the ID of the preset dictionary required to
+// decompress the zlib stream read by r, without consuming any input.
+// ok is false if the stream does not use a preset dictionary.
+// ErrHeader is returned if the stream does not start with a zlib header.
+// r can then be passed to NewReaderDict with the dictionary.
+func PeekDictionaryID(r *bufio.Reader) (id uint32, ok bool, err error) {
+	b, err := r.Peek(2)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, false, err
+	}
+	h := uint(b[0])<<8 | uint(b[1])
+	if (b[0]&0x0f != zlibDeflate) || (h%31 != 0) {
+		return 0, false, ErrHeader
+	}
+	if b[1]&0x20 == 0 {
+		return 0, false, nil
+	}
+	b, err = r.Peek(6)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, false, err
+	}
+	return uint32(b[2])<<24 | uint32(b[3])<<16 | uint32(b[4])<<8 | uint32(b[5]), true, nil
+}
+
 func (z *reader) Read(p []byte) (int, error) {
 	if z.err != nil {
 		return 0, z.err
@@ -128,7 +182,7 @@ func (z *reader) Close() error {
 }
 
 func (z *reader) Reset(r io.Reader, dict []byte) error {
-	*z = reader{decompressor: z.decompressor, digest: z.digest}
+	*z = reader{decompressor: z.decompressor, digest: z.digest, dicts: z.dicts}
 	if fr, ok := r.(flate.Reader); ok {
 		z.r = fr
 	} else {
@@ -158,9 +212,13 @@ func (z *reader) Reset(r io.Reader, dict []byte) error {
 			return z.err
 		}
 		checksum := uint32(z.scratch[0])<<24 | uint32(z.scratch[1])<<16 | uint32(z.scratch[2])<<8 | uint32(z.scratch[3])
-		if checksum != adler32.Checksum(dict) {
-			z.err = ErrDictionary
-			return z.err
+		if checksum != DictionaryID(dict) {
+			d, ok := z.dicts[checksum]
+			if !ok {
+				z.err = ErrDictionary
+				return z.err
+			}
+			dict = d
 		}
 	}
 
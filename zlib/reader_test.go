@@ -5,8 +5,10 @@
 package zlib
 
 import (
+	"bufio"
 	"bytes"
 	"io"
+	"io/ioutil"
 	"testing"
 )
 
@@ -177,3 +179,71 @@ func TestDecompressor(t *testing.T) {
 		}
 	}
 }
+
+func TestReaderDicts(t *testing.T) {
+	dicts := [][]byte{
+		[]byte("version 1 of the dictionary"),
+		[]byte("version 2 of the dictionary, with more words"),
+	}
+	byID := make(map[uint32][]byte)
+	for _, d := range dicts {
+		byID[DictionaryID(d)] = d
+	}
+	input := []byte("a message using words from version 2 of the dictionary")
+
+	var streams [][]byte
+	for _, d := range append(dicts, nil) {
+		var buf bytes.Buffer
+		w, err := NewWriterLevelDict(&buf, BestCompression, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, ok := w.DictionaryID(); ok != (d != nil) || ok && id != DictionaryID(d) {
+			t.Errorf("Writer.DictionaryID() = %d, %v", id, ok)
+		}
+		w.Write(input)
+		w.Close()
+		streams = append(streams, buf.Bytes())
+	}
+
+	var zr io.ReadCloser
+	for i, stream := range streams {
+		br := bufio.NewReader(bytes.NewReader(stream))
+		id, ok, err := PeekDictionaryID(br)
+		if err != nil {
+			t.Fatalf("stream %d: %v", i, err)
+		}
+		if want := i < len(dicts); ok != want {
+			t.Fatalf("stream %d: PeekDictionaryID ok = %v, want %v", i, ok, want)
+		}
+		if ok && id != DictionaryID(dicts[i]) {
+			t.Fatalf("stream %d: PeekDictionaryID = %d, want %d", i, id, DictionaryID(dicts[i]))
+		}
+		if i == 0 {
+			var err error
+			zr, err = NewReaderDicts(br, byID)
+			if err != nil {
+				t.Fatal(err)
+			}
+		} else if err := zr.(Resetter).Reset(br, nil); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("stream %d: %v", i, err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Fatalf("stream %d: got %q, want %q", i, got, input)
+		}
+	}
+
+	if _, err := NewReaderDicts(bytes.NewReader(streams[1]), map[uint32][]byte{DictionaryID(dicts[0]): dicts[0]}); err != ErrDictionary {
+		t.Errorf("missing dictionary: got %v, want %v", err, ErrDictionary)
+	}
+	if _, _, err := PeekDictionaryID(bufio.NewReader(bytes.NewReader([]byte("not zlib")))); err != ErrHeader {
+		t.Errorf("invalid header: got %v, want %v", err, ErrHeader)
+	}
+	if _, _, err := PeekDictionaryID(bufio.NewReader(bytes.NewReader(streams[0][:4]))); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated header: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -5,6 +5,7 @@
 package zlib
 
 import (
+	"errors"
 	"fmt"
 	"hash"
 	"hash/adler32"
@@ -22,19 +23,69 @@ const (
 	DefaultCompression  = flate.DefaultCompression
 	ConstantCompression = flate.ConstantCompression
 	HuffmanOnly         = flate.HuffmanOnly
+
+	// StatelessCompression will do compression but without maintaining any state
+	// between Write calls.
+	// There will be no memory kept between Write calls,
+	// but compression and speed will be suboptimal.
+	// Because of this, the size of actual Write calls will affect output size.
+	StatelessCompression = -3
 )
 
+// statelessWriter compresses each Write with flate.StatelessDeflate.
+// A preset dictionary is only used by the first Write.
+type statelessWriter struct {
+	w     io.Writer
+	dict  []byte
+	wrote bool
+}
+
+func (s *statelessWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var dict []byte
+	if !s.wrote {
+		dict = s.dict
+	}
+	s.wrote = true
+	return len(p), flate.StatelessDeflate(s.w, p, false, dict)
+}
+
+// Flush does nothing, since all output is written by Write.
+func (s *statelessWriter) Flush() error {
+	return nil
+}
+
+func (s *statelessWriter) Close() error {
+	return flate.StatelessDeflate(s.w, nil, true, nil)
+}
+
+func (s *statelessWriter) Reset(w io.Writer) {
+	s.w = w
+	s.wrote = false
+}
+
 // A Writer takes data written to it and writes the compressed
 // form of that data to an underlying writer (see NewWriter).
 type Writer struct {
 	w           io.Writer
 	level       int
 	dict        []byte
-	compressor  *flate.Writer
+	compressor  flate.WriteResetter
 	digest      hash.Hash32
 	err         error
 	scratch     [4]byte
 	wroteHeader bool
+
+	// Parallel compression parameters, see SetConcurrency.
+	blockSize   int
+	concurrency int
+
+	// windowBits is the window size set by SetOptions, or 0.
+	windowBits int
+	// hasOptions is set by SetOptions.
+	hasOptions bool
 }
 
 // NewWriter creates a new Writer.
@@ -50,8 +101,9 @@ func NewWriter(w io.Writer) *Writer {
 // NewWriterLevel is like NewWriter but specifies the compression level instead
 // of assuming DefaultCompression.
 //
-// The compression level can be DefaultCompression, NoCompression, HuffmanOnly
-// or any integer value between BestSpeed and BestCompression inclusive.
+// The compression level can be DefaultCompression, NoCompression, HuffmanOnly,
+// StatelessCompression or any integer value between BestSpeed and
+// BestCompression inclusive.
 // The error returned will be nil if the level is valid.
 func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
 	return NewWriterLevelDict(w, level, nil)
@@ -62,8 +114,10 @@ func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
 //
 // The dictionary may be nil. If not, its contents should not be modified until
 // the Writer is closed.
+// The header of the stream contains DictionaryID(dict), which readers
+// can use to select the dictionary, see PeekDictionaryID and NewReaderDicts.
 func NewWriterLevelDict(w io.Writer, level int, dict []byte) (*Writer, error) {
-	if level < HuffmanOnly || level > BestCompression {
+	if level < StatelessCompression || level > BestCompression {
 		return nil, fmt.Errorf("zlib: invalid compression level: %d", level)
 	}
 	return &Writer{
@@ -73,6 +127,69 @@ func NewWriterLevelDict(w io.Writer, level int, dict []byte) (*Writer, error) {
 	}, nil
 }
 
+// SetConcurrency enables compressing the stream on multiple goroutines.
+// Input is split into blocks of blockSize bytes and up to blocks
+// blocks are compressed at once before Write will block.
+// The output is still a single regular zlib stream.
+// If blockSize is <= 0 a block size of 1MB is used.
+// If blocks is < 0 runtime.GOMAXPROCS(0) is used.
+// Calling SetConcurrency with blocks == 0 disables concurrent compression,
+// which is the default.
+//
+// SetConcurrency must be called before the first call to Write, Flush, or Close.
+// The setting is kept when the Writer is Reset.
+func (z *Writer) SetConcurrency(blockSize, blocks int) error {
+	if z.wroteHeader {
+		return errors.New("zlib: SetConcurrency called after writing")
+	}
+	if z.level == StatelessCompression && blocks != 0 {
+		return errors.New("zlib: SetConcurrency cannot be used with StatelessCompression")
+	}
+	z.blockSize = blockSize
+	z.concurrency = blocks
+	// A new compressor is created when the header is written.
+	if pw, ok := z.compressor.(*flate.ParallelWriter); ok {
+		// Stop background writer.
+		pw.Reset(nil)
+	}
+	z.compressor = nil
+	z.windowBits = 0
+	z.hasOptions = false
+	return nil
+}
+
+// SetOptions sets options for the deflate compressor.
+// The compression level of the Writer is applied before the options.
+// If a window smaller than the default is selected with flate.WithWindowBits,
+// the window size stored in the zlib header is adjusted to match,
+// so decoders can limit the memory used.
+//
+// SetOptions must be called before the first call to Write, Flush, or Close.
+// It disables concurrent compression.
+// The options are kept when the Writer is Reset.
+func (z *Writer) SetOptions(opts ...flate.Option) error {
+	if z.wroteHeader {
+		return errors.New("zlib: SetOptions called after writing")
+	}
+	fw, err := flate.NewWriterOpts(z.w, append([]flate.Option{flate.WithLevel(z.level)}, opts...)...)
+	if err != nil {
+		return err
+	}
+	if z.dict != nil {
+		fw.ResetDict(z.w, z.dict)
+	}
+	if pw, ok := z.compressor.(*flate.ParallelWriter); ok {
+		// Stop background writer.
+		pw.Reset(nil)
+	}
+	z.concurrency = 0
+	z.compressor = fw
+	z.digest = adler32.New()
+	z.windowBits = fw.WindowBits()
+	z.hasOptions = true
+	return nil
+}
+
 // Reset clears the state of the Writer z such that it is equivalent to its
 // initial state from NewWriterLevel or NewWriterLevelDict, but instead writing
 // to w.
@@ -90,6 +207,15 @@ func (z *Writer) Reset(w io.Writer) {
 	z.wroteHeader = false
 }
 
+// DictionaryID returns the ID of the preset dictionary written to the header.
+// ok is false if no dictionary is used.
+func (z *Writer) DictionaryID() (id uint32, ok bool) {
+	if z.dict == nil {
+		return 0, false
+	}
+	return DictionaryID(z.dict), true
+}
+
 // writeHeader writes the ZLIB header.
 func (z *Writer) writeHeader() (err error) {
 	z.wroteHeader = true
@@ -97,12 +223,16 @@ func (z *Writer) writeHeader() (err error) {
 	// The first four bits is the CINFO (compression info), which is 7 for the default deflate window size.
 	// The next four bits is the CM (compression method), which is 8 for deflate.
 	z.scratch[0] = 0x78
+	if z.windowBits != 0 {
+		// CINFO is the base-2 logarithm of the window size minus eight.
+		z.scratch[0] = uint8(z.windowBits-8)<<4 | 8
+	}
 	// The next two bits is the FLEVEL (compression level). The four values are:
 	// 0=fastest, 1=fast, 2=default, 3=best.
 	// The next bit, FDICT, is set if a dictionary is given.
 	// The final five FCHECK bits form a mod-31 checksum.
 	switch z.level {
-	case -2, 0, 1:
+	case -3, -2, 0, 1:
 		z.scratch[1] = 0 << 6
 	case 2, 3, 4, 5:
 		z.scratch[1] = 1 << 6
@@ -122,7 +252,7 @@ func (z *Writer) writeHeader() (err error) {
 	}
 	if z.dict != nil {
 		// The next four bytes are the Adler-32 checksum of the dictionary.
-		checksum := adler32.Checksum(z.dict)
+		checksum := DictionaryID(z.dict)
 		z.scratch[0] = uint8(checksum >> 24)
 		z.scratch[1] = uint8(checksum >> 16)
 		z.scratch[2] = uint8(checksum >> 8)
@@ -134,9 +264,22 @@ func (z *Writer) writeHeader() (err error) {
 	if z.compressor == nil {
 		// Initialize deflater unless the Writer is being reused
 		// after a Reset call.
-		z.compressor, err = flate.NewWriterDict(z.w, z.level, z.dict)
-		if err != nil {
-			return err
+		if z.level == StatelessCompression {
+			z.compressor = &statelessWriter{w: z.w, dict: z.dict}
+		} else if z.concurrency != 0 {
+			pw, err := flate.NewParallelWriter(z.w, z.level, z.blockSize, z.concurrency)
+			if err != nil {
+				return err
+			}
+			if z.dict != nil {
+				pw.ResetDict(z.w, z.dict)
+			}
+			z.compressor = pw
+		} else {
+			z.compressor, err = flate.NewWriterDict(z.w, z.level, z.dict)
+			if err != nil {
+				return err
+			}
 		}
 		z.digest = adler32.New()
 	}
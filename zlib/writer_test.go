@@ -11,6 +11,8 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+
+	"github.com/klauspost/compress/flate"
 )
 
 var filenames = []string{
@@ -170,6 +172,7 @@ func TestWriterDict(t *testing.T) {
 		testFileLevelDict(t, fn, DefaultCompression, dictionary)
 		testFileLevelDict(t, fn, NoCompression, dictionary)
 		testFileLevelDict(t, fn, HuffmanOnly, dictionary)
+		testFileLevelDict(t, fn, StatelessCompression, dictionary)
 		for level := BestSpeed; level <= BestCompression; level++ {
 			testFileLevelDict(t, fn, level, dictionary)
 		}
@@ -185,6 +188,8 @@ func TestWriterReset(t *testing.T) {
 		testFileLevelDictReset(t, fn, NoCompression, []byte(dictionary))
 		testFileLevelDictReset(t, fn, DefaultCompression, []byte(dictionary))
 		testFileLevelDictReset(t, fn, HuffmanOnly, []byte(dictionary))
+		testFileLevelDictReset(t, fn, StatelessCompression, nil)
+		testFileLevelDictReset(t, fn, StatelessCompression, []byte(dictionary))
 		if testing.Short() {
 			break
 		}
@@ -194,6 +199,52 @@ func TestWriterReset(t *testing.T) {
 	}
 }
 
+func TestWriterStateless(t *testing.T) {
+	input, err := ioutil.ReadFile("../testdata/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict := input[:1000]
+	var buf bytes.Buffer
+	w, err := NewWriterLevelDict(&buf, StatelessCompression, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetConcurrency(0, 4); err == nil {
+		t.Error("want error with SetConcurrency")
+	}
+	for in := input; len(in) > 0; {
+		n := 5000
+		if n > len(in) {
+			n = len(in)
+		}
+		if _, err := w.Write(in[:n]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		in = in[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() >= len(input) {
+		t.Errorf("no compression: %d >= %d", buf.Len(), len(input))
+	}
+	r, err := NewReaderDict(&buf, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, input) {
+		t.Error("output mismatch")
+	}
+}
+
 func TestWriterDictIsUsed(t *testing.T) {
 	var input = []byte("Lorem ipsum dolor sit amet, consectetur adipisicing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua.")
 	var buf bytes.Buffer
@@ -210,3 +261,100 @@ func TestWriterDictIsUsed(t *testing.T) {
 		t.Errorf("result too large (got %d, want <= %d bytes). Is the dictionary being used?", len(output), expectedMaxSize)
 	}
 }
+
+func TestWriterConcurrency(t *testing.T) {
+	const dictionary = "0123456789."
+	for _, fn := range filenames {
+		golden, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dict := range []string{"", dictionary} {
+			var d []byte
+			if dict != "" {
+				d = []byte(dict)
+			}
+			var buf bytes.Buffer
+			w, err := NewWriterLevelDict(&buf, DefaultCompression, d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.SetConcurrency(10000, 4); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				buf.Reset()
+				if _, err := w.Write(golden); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				r, err := NewReaderDict(&buf, d)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatalf("%s: %v", fn, err)
+				}
+				if !bytes.Equal(got, golden) {
+					t.Fatalf("%s: decoded content does not match", fn)
+				}
+				w.Reset(&buf)
+			}
+		}
+	}
+}
+
+func TestWriterOptions(t *testing.T) {
+	const dictionary = "0123456789."
+	golden, err := ioutil.ReadFile("../testdata/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, wb := range []int{9, 12, 15} {
+		for _, dict := range []string{"", dictionary} {
+			var d []byte
+			if dict != "" {
+				d = []byte(dict)
+			}
+			var buf bytes.Buffer
+			w, err := NewWriterLevelDict(&buf, BestCompression, d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.SetOptions(flate.WithWindowBits(wb), flate.WithBlockType(flate.BlockDynamic)); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				buf.Reset()
+				if _, err := w.Write(golden); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if cinfo := int(buf.Bytes()[0] >> 4); cinfo != wb-8 {
+					t.Fatalf("window bits %d: CINFO is %d, want %d", wb, cinfo, wb-8)
+				}
+				r, err := NewReaderDict(&buf, d)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, golden) {
+					t.Fatal("decoded content does not match")
+				}
+				w.Reset(&buf)
+			}
+			w.Write([]byte("data"))
+			if err := w.SetOptions(); err == nil {
+				t.Fatal("want error after writing")
+			}
+		}
+	}
+}